@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// asciiWriter is a Writer that escapes each non-ASCII rune written to it as a
+// JSON \uXXXX escape, using surrogate pairs for runes outside the Basic
+// Multilingual Plane. It is only used to wrap JSON encoders, which write each
+// value in a single call, where non-ASCII runes can only appear in strings.
+type asciiWriter struct {
+	w io.Writer
+}
+
+func (a asciiWriter) Write(p []byte) (int, error) {
+	buf := make([]byte, 0, len(p))
+	for i := 0; i < len(p); {
+		if p[i] < utf8.RuneSelf {
+			buf = append(buf, p[i])
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(p[i:])
+		i += size
+		if r1, r2 := utf16.EncodeRune(r); r1 != utf8.RuneError {
+			buf = append(buf, fmt.Sprintf(`\u%04x\u%04x`, r1, r2)...)
+		} else {
+			buf = append(buf, fmt.Sprintf(`\u%04x`, r)...)
+		}
+	}
+	if _, err := a.w.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
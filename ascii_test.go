@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestASCIIWriter(t *testing.T) {
+	cases := []struct {
+		name, in, want string
+	}{
+		{"ascii", `"plain"`, `"plain"`},
+		{"accented", `"café"`, `"caf\u00e9"`},
+		{"cjk", `"日本"`, `"\u65e5\u672c"`},
+		{"emoji", `"🔥"`, `"\ud83d\udd25"`},
+		{"mixed", `{"ü":"a😀b"}`, `{"\u00fc":"a\ud83d\ude00b"}`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := asciiWriter{&buf}.Write([]byte(c.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(c.in) {
+				t.Errorf("Write(%q) = %d; want %d", c.in, n, len(c.in))
+			}
+			if got := buf.String(); got != c.want {
+				t.Errorf("Write(%q) wrote %q; want %q", c.in, got, c.want)
+			}
+		})
+	}
+}
+
+func TestASCIIOutput(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{name: "json", script: `event -a -json -n '"café 🔥"'`, want: `"caf\u00e9 \ud83d\udd25"` + "\n"},
+		{name: "ndjson", script: `event -a -ndjson -n '{"ü": "é"}'`, want: `{"\u00fc":"\u00e9"}` + "\n"},
+		{name: "pretty", script: `event -a -json -pretty -n '["ñ"]'`, want: "[\n  \"\\u00f1\"\n]\n"},
+		{name: "without -a", script: `event -ndjson -n '"é"'`, want: `"é"` + "\n"},
+	}, nil)
+}
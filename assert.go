@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// assertCount implements the assert-count builtin. It counts the results of a
+// query against a source and fails if the count does not satisfy every given
+// constraint:
+//
+//	assert-count [options] SOURCE QUERY
+func (p *Prog) assertCount(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "assert-count")
+	f := flag.NewFlagSet("assert-count", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	eq, min, max := -1, -1, -1
+	f.IntVar(&eq, "eq", eq, "Require exactly `n` results.")
+	f.IntVar(&min, "min", min, "Require at least `n` results.")
+	f.IntVar(&max, "max", max, "Require at most `n` results.")
+
+	pos, err := parseArgs(f, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	if len(pos) != 2 {
+		logger.Printf("expected a source and a query")
+		return interp.NewExitStatus(1)
+	} else if eq < 0 && min < 0 && max < 0 {
+		logger.Printf("no constraint given: expected one of -eq, -min, or -max")
+		return interp.NewExitStatus(1)
+	}
+
+	docs, err := p.inputs(ctx, pos[0])
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	query, err := p.compileUserQuery(ctx, pos[1])
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	count := 0
+	for _, doc := range docs {
+		vals, err := evalCode(ctx, query, doc)
+		if err != nil {
+			logger.Print(err)
+			return interp.NewExitStatus(1)
+		}
+		count += len(vals)
+	}
+
+	switch {
+	case eq >= 0 && count != eq:
+		logger.Printf("expected %d results, got %d", eq, count)
+	case min >= 0 && count < min:
+		logger.Printf("expected at least %d results, got %d", min, count)
+	case max >= 0 && count > max:
+		logger.Printf("expected at most %d results, got %d", max, count)
+	default:
+		return nil
+	}
+	return interp.NewExitStatus(1)
+}
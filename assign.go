@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// assign implements the assign builtin. It runs a query against a source,
+// defaulting to the event, and stores its result in the shell variable NAME.
+// Strings are stored as-is and other values as compact JSON:
+//
+//	assign [-a|-array] NAME QUERY [SOURCE]
+//
+// The query must produce exactly one value unless -array is given, in which
+// case every value is stored as an element of an indexed array. Because let is
+// a shell keyword, this cannot be named let.
+//
+// Variables are set in the script's top-level shell, so assign fails in
+// subshells, command substitutions, and pipeline stages other than the last.
+// Since a local variable would hide the value set, assigning to a local
+// variable is an error.
+func (p *Prog) assign(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "assign")
+	f := flag.NewFlagSet("assign", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	array := false
+	// -a, -array
+	f.BoolVar(&array, "a", array, "Store every result as an element of an indexed array. (long: -array)")
+	f.BoolVar(&array, "array", array, "Store every result as an element of an indexed array. (short: -a)")
+
+	pos, err := parseArgs(f, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	if len(pos) < 2 || len(pos) > 3 {
+		logger.Printf("expected a variable name, a query, and an optional source")
+		return interp.NewExitStatus(1)
+	}
+	name, source := pos[0], "event"
+	if len(pos) == 3 {
+		source = pos[2]
+	}
+	if !syntax.ValidName(name) {
+		logger.Printf("invalid variable name: %q", name)
+		return interp.NewExitStatus(1)
+	}
+	cur, err := p.assignable(h.Env, name)
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	docs, err := p.inputs(ctx, source)
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+	query, err := p.compileUserQuery(ctx, pos[1])
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	var list []string
+	for _, doc := range docs {
+		vals, err := evalCode(ctx, query, doc)
+		if err != nil {
+			logger.Print(err)
+			return interp.NewExitStatus(1)
+		}
+		for _, val := range vals {
+			str, err := plainString(val)
+			if err != nil {
+				logger.Print(err)
+				return interp.NewExitStatus(1)
+			}
+			list = append(list, str)
+		}
+	}
+
+	switch {
+	case array:
+	case len(list) == 0:
+		logger.Printf("query produced no value")
+		return interp.NewExitStatus(1)
+	case len(list) > 1:
+		logger.Printf("query produced %d values: use -array to store more than one", len(list))
+		return interp.NewExitStatus(1)
+	}
+	p.storeVar(name, cur, array, list)
+	return nil
+}
+
+// assignable returns the current value of the variable name in env, the
+// environment of a builtin, or an error if the builtin cannot set it. Builtins
+// can only set variables in the script's top-level shell: a subshell's
+// variables are its own, and a local variable of the same name would hide the
+// value set.
+func (p *Prog) assignable(env expand.Environ, name string) (expand.Variable, error) {
+	cur := env.Get(name)
+	switch {
+	case cur.ReadOnly:
+		return cur, fmt.Errorf("%s: readonly variable", name)
+	case cur.Local:
+		return cur, fmt.Errorf("%s: cannot assign to a local variable", name)
+	case !p.topLevel(env):
+		return cur, fmt.Errorf("%s: cannot assign outside the top-level shell, such as in a subshell or pipeline", name)
+	}
+	return cur, nil
+}
+
+// storeVar sets the variable name, whose current value is cur, in the
+// script's top-level shell. If indexed is set, the variable becomes an
+// indexed array of list. Otherwise, it becomes the string list[0], which is
+// exported if cur is an exported string.
+//
+// storeVar must only be called once assignable has succeeded, so that the
+// interpreter is not changing the variables concurrently.
+func (p *Prog) storeVar(name string, cur expand.Variable, indexed bool, list []string) {
+	if indexed {
+		p.runner.Vars[name] = expand.Variable{Kind: expand.Indexed, List: list}
+		return
+	}
+	p.runner.Vars[name] = expand.Variable{
+		Exported: cur.Exported && cur.Kind == expand.String,
+		Kind:     expand.String,
+		Str:      list[0],
+	}
+}
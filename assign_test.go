@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestAssign(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{name: "string", script: "assign name .entity.metadata.name\necho \"$name\"", want: "web-1\n"},
+		{name: "number", script: "assign status .check.status\necho $((status + 1))", want: "2\n"},
+		{name: "json", script: "assign check '.check | {status}'\necho \"$check\"", want: `{"status":1}` + "\n"},
+		{name: "source variable", script: "doc='{\"a\": [1, 2]}'\nassign n '.a | length' doc\necho $n", want: "2\n"},
+		{name: "stdin", script: "echo '{\"a\": 3}' | { assign n .a -; echo $n; }", want: "3\n"},
+		{name: "array", script: "assign -array xs '.check.status, \"a b\", null'\necho ${#xs[@]}; printf '[%s]\\n' \"${xs[@]}\"", want: "3\n[1]\n[a b]\n[]\n"},
+		{name: "array empty", script: "assign -a xs empty\necho ${#xs[@]}", want: "0\n"},
+		{name: "replace", script: "x=old\nassign x .entity.metadata.name\necho $x", want: "web-1\n"},
+		{name: "keeps export", script: "export x=old\nassign x .check.status\nsh -c 'echo $x'", want: "1\n"},
+		{name: "in function", script: "f() { assign x .check.status; }\nf\necho $x", want: "1\n"},
+		{name: "global from function", script: "x=0\nf() { assign x .check.status; echo \"in $x\"; }\nf\necho \"out $x\"", want: "in 1\nout 1\n"},
+		{name: "local", script: "f() { local w=0; assign w .check.status; echo \"$? $w\"; }\nf", want: "1 0\n", wantErr: "w: cannot assign to a local variable"},
+		{name: "local global untouched", script: "w=g\nf() { local w; assign w .check.status; }\nf\necho $w", want: "g\n", wantErr: "cannot assign to a local variable"},
+		{name: "readonly", script: "readonly r=1\nassign r .check.status", status: 1, wantErr: "r: readonly variable"},
+		{name: "subshell", script: "x=0\n( assign x .check.status; echo \"inner=$? $x\" )\necho outer=$x", want: "inner=1 0\nouter=0\n", wantErr: "x: cannot assign outside the top-level shell"},
+		{name: "command substitution", script: "x=0\nst=$(assign x .check.status; echo $?)\necho \"$st $x\"", want: "1 0\n", wantErr: "cannot assign outside the top-level shell"},
+		{name: "pipeline", script: "a=0 b=0\nassign a .check.status | assign b .check.status | assign -a c .check.status\necho \"$a $b ${c[@]}\"", want: "0 0 1\n", wantErr: "a: cannot assign outside the top-level shell"},
+		{name: "multiple values", script: "assign x '1, 2'", status: 1, wantErr: "query produced 2 values: use -array"},
+		{name: "no value", script: "assign x empty", status: 1, wantErr: "query produced no value"},
+		{name: "invalid name", script: "assign 1x .", status: 1, wantErr: `invalid variable name: "1x"`},
+		{name: "missing query", script: "assign x", status: 1, wantErr: "expected a variable name, a query"},
+	}, nil)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// bashAssoc implements the bash-assoc builtin. It queries the event for an
+// object, flattens it, and prints a bash declaration of an associative array
+// holding its keys and values:
+//
+//	bash-assoc [options] NAME [query]
+func (p *Prog) bashAssoc(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "bash-assoc")
+	f := flag.NewFlagSet("bash-assoc", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	sep := "."
+	// -s, -separator
+	f.StringVar(&sep, "s", sep, "Separator used to join flattened keys. (long: -separator)")
+	f.StringVar(&sep, "separator", sep, "Separator used to join flattened keys. (short: -s)")
+
+	if err := f.Parse(args[1:]); errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	queryStr := "."
+	switch f.NArg() {
+	case 2:
+		queryStr = f.Arg(1)
+	case 1:
+	default:
+		logger.Printf("expected a variable name and optional query")
+		return interp.NewExitStatus(1)
+	}
+
+	name := f.Arg(0)
+	if !syntax.ValidName(name) {
+		logger.Printf("invalid variable name: %q", name)
+		return interp.NewExitStatus(1)
+	}
+
+	vals, err := p.evalUserQuery(ctx, queryStr, p.currentEvent())
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	} else if len(vals) != 1 {
+		logger.Printf("query must produce exactly one value, got %d", len(vals))
+		return interp.NewExitStatus(1)
+	}
+
+	obj, ok := vals[0].(map[string]interface{})
+	if !ok {
+		logger.Printf("query result is not an object: %T", vals[0])
+		return interp.NewExitStatus(1)
+	}
+
+	flat := map[string]string{}
+	if err := flattenValue(flat, sep, nil, obj); err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	keys := make([]string, 0, len(flat))
+	for k := range flat {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	arr := &syntax.ArrayExpr{}
+	for _, k := range keys {
+		arr.Elems = append(arr.Elems, &syntax.ArrayElem{
+			Index: bashWord(k),
+			Value: bashWord(flat[k]),
+		})
+	}
+
+	decl := &syntax.DeclClause{
+		Variant: &syntax.Lit{Value: "declare"},
+		Args: []*syntax.Assign{
+			{Naked: true, Value: &syntax.Word{Parts: []syntax.WordPart{&syntax.Lit{Value: "-A"}}}},
+			{Name: &syntax.Lit{Value: name}, Array: arr},
+		},
+	}
+
+	if err := syntax.NewPrinter().Print(h.Stdout, &syntax.Stmt{Cmd: decl}); err != nil {
+		logger.Printf("error writing declaration: %v", err)
+		return interp.NewExitStatus(1)
+	}
+	if _, err := fmt.Fprintln(h.Stdout); err != nil {
+		logger.Printf("error writing declaration: %v", err)
+		return interp.NewExitStatus(1)
+	}
+	return nil
+}
+
+// flattenValue writes the leaves of val into dst, keyed by their paths joined
+// with sep. Objects and arrays are descended into, all other values are
+// formatted using plainString. Since bash rejects empty subscripts, a leaf
+// whose key is empty is an error, as is a key shared by two leaves, such as
+// that of {"a.b": 1, "a": {"b": 2}}.
+func flattenValue(dst map[string]string, sep string, path []string, val interface{}) error {
+	switch val := val.(type) {
+	case map[string]interface{}:
+		for k, v := range val {
+			if err := flattenValue(dst, sep, append(path, k), v); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, v := range val {
+			if err := flattenValue(dst, sep, append(path, strconv.Itoa(i)), v); err != nil {
+				return err
+			}
+		}
+	default:
+		key := strings.Join(path, sep)
+		if key == "" {
+			return errors.New("cannot use an empty key as a bash subscript")
+		} else if _, ok := dst[key]; ok {
+			return fmt.Errorf("more than one value has the flattened key %q", key)
+		}
+		str, err := plainString(val)
+		if err != nil {
+			return err
+		}
+		dst[key] = str
+	}
+	return nil
+}
+
+// bashWord returns a word that single-quotes s for bash. Single quotes in s
+// are escaped outside of the quoted segments.
+func bashWord(s string) *syntax.Word {
+	w := &syntax.Word{}
+	for i, part := range strings.Split(s, "'") {
+		if i > 0 {
+			w.Parts = append(w.Parts, &syntax.Lit{Value: `\'`})
+		}
+		if part != "" || i == 0 {
+			w.Parts = append(w.Parts, &syntax.SglQuoted{Value: part})
+		}
+	}
+	return w
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestBashAssoc(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not found")
+	}
+	// dump evaluates the declaration printed by bash-assoc in bash and prints
+	// each key and value of the array, sorted by key. Keys must not hold
+	// newlines.
+	dump := func(args string) string {
+		return `decl="$(bash-assoc ` + args + `)" || exit
+bash -c "$decl"'
+readarray -t keys < <(printf "%s\n" "${!x[@]}" | LC_ALL=C sort)
+for k in "${keys[@]}"; do printf "[%s]=[%s]\n" "$k" "${x[$k]}"; done'`
+	}
+	const doc = `'{"b": {"c": [1, {"d": null}], "e": true}, "a": "x"}'`
+	runScriptCases(t, []scriptCase{
+		{name: "flatten", script: dump(`x ` + doc), want: "[a]=[x]\n[b.c.0]=[1]\n[b.c.1.d]=[]\n[b.e]=[true]\n"},
+		{name: "separator", script: dump(`-s / x ` + doc), want: "[a]=[x]\n[b/c/0]=[1]\n[b/c/1/d]=[]\n[b/e]=[true]\n"},
+		{name: "event", script: dump(`x .check`), want: "[metadata.name]=[disk]\n[status]=[1]\n"},
+		{
+			name:   "quoting",
+			script: dump(`x '{"it'"'"'s": "a'"'"'b", "$(id)": "` + "`id`" + `", "a]b": " x\ny ", "*": "\\"}'`),
+			want:   "[$(id)]=[`id`]\n[*]=[\\]\n[a]b]=[ x\ny ]\n[it's]=[a'b]\n",
+		},
+		{name: "empty key", script: `bash-assoc x '{"": 1}'`, status: 1, wantErr: "cannot use an empty key as a bash subscript"},
+		{name: "empty nested key", script: dump(`x '{"a": {"": 1}, "": {"b": 2}}'`), want: "[.b]=[2]\n[a.]=[1]\n"},
+		{name: "colliding keys", script: `bash-assoc x '{"a.b": 1, "a": {"b": 2}}'`, status: 1, wantErr: `more than one value has the flattened key "a.b"`},
+		{name: "colliding separator", script: `bash-assoc -s '' x '{"ab": 1, "a": {"b": 2}}'`, status: 1, wantErr: `flattened key "ab"`},
+		{name: "not an object", script: `bash-assoc x .check.status`, status: 1, wantErr: "query result is not an object"},
+		{name: "invalid name", script: `bash-assoc 1x`, status: 1, wantErr: `invalid variable name: "1x"`},
+	}, nil)
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/itchyny/gojq"
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+)
+
+// Sensu check statuses.
+const (
+	statusOK       = 0
+	statusWarning  = 1
+	statusCritical = 2
+	statusUnknown  = 3
+)
+
+// parseArgs parses args using f, permitting flags to follow positional
+// arguments. The positional arguments are returned in order. Any arguments
+// following a "--" are treated as positional.
+func parseArgs(f *flag.FlagSet, args []string) ([]string, error) {
+	var pos []string
+	args = pairArgs(f, args)
+	for {
+		if err := f.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := f.Args()
+		if len(rest) == 0 {
+			return pos, nil
+		}
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			return append(pos, rest...), nil
+		}
+		pos, args = append(pos, rest[0]), rest[1:]
+	}
+}
+
+// pairFlag is implemented by flag values, such as the value of -arg, that take
+// two arguments. The arguments are joined by pairArgs as NAME=VALUE.
+type pairFlag interface {
+	flag.Value
+	pair()
+}
+
+// pairArgs returns args with the two arguments following each flag of f whose
+// value is a pairFlag joined into a single NAME=VALUE argument. If the first
+// argument following the flag already contains an "=", it is left as-is.
+// Arguments following a "--" are not changed.
+func pairArgs(f *flag.FlagSet, args []string) []string {
+	joined := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		joined = append(joined, arg)
+		if arg == "--" {
+			return append(joined, args[i+1:]...)
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		if dashes := len(arg) - len(name); dashes == 0 || dashes > 2 || strings.Contains(name, "=") {
+			continue
+		}
+		fl := f.Lookup(name)
+		if fl == nil {
+			continue
+		} else if _, ok := fl.Value.(pairFlag); !ok {
+			continue
+		}
+		if i+2 < len(args) && !strings.Contains(args[i+1], "=") {
+			joined = append(joined, args[i+1]+"="+args[i+2])
+			i += 2
+		}
+	}
+	return joined
+}
+
+// chainQueries reads a chain of queries from the front of args: a query
+// followed by any number of "//" arguments, each followed by another query.
+// It returns the queries composed into one, in which each query is run
+// against each result of the one before it, and the arguments that follow
+// the chain.
+func chainQueries(args []string) (string, []string, error) {
+	stages, rest := args[:1], args[1:]
+	for len(rest) > 0 && rest[0] == "//" {
+		if len(rest) == 1 {
+			return "", nil, errors.New("expected a query after //")
+		}
+		stages, rest = append(stages[:len(stages):len(stages)], rest[1]), rest[2:]
+	}
+	if len(stages) == 1 {
+		return stages[0], rest, nil
+	}
+
+	// Each query is parsed on its own so that errors name the query they
+	// occur in. Closing parentheses follow a newline so that a query
+	// ending in a comment does not comment them out.
+	for i, stage := range stages {
+		if _, err := gojq.Parse(stage); err != nil {
+			return "", nil, fmt.Errorf("unable to parse query %d: %w", i+1, err)
+		}
+	}
+	return "(" + strings.Join(stages, "\n) | (") + "\n)", rest, nil
+}
+
+// inputs returns the documents held by the named source. The source "event"
+// is the event, while all other sources are decoded as a stream of JSON or
+// YAML documents from the reader returned by sourceReader.
+func (p *Prog) inputs(ctx context.Context, source string) ([]interface{}, error) {
+	if source == "event" {
+		return []interface{}{p.currentEvent()}, nil
+	}
+
+	h := interp.HandlerCtx(ctx)
+	or := newOffsetReader(sourceReader(h, source))
+	dec := newDecoder(or, false)
+	var docs []interface{}
+	for {
+		var doc interface{}
+		if err := dec.Decode(&doc); errors.Is(err, io.EOF) {
+			return docs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("error decoding input: %w", or.annotate(err))
+		}
+		docs = append(docs, doc)
+	}
+}
+
+// compileQuery parses and compiles queryStr. The query's env is the string
+// variables of the interpreter, exported or not, while $ENV is bound to its
+// exported variables, which are the environment of the commands it runs. Both
+// include the event's SENSU_* variables and those set by the script. Any
+// additional variable names, including their leading "$", follow $ENV.
+// Compiled queries must be run using runQuery with the values of those
+// variables.
+func compileQuery(ctx context.Context, queryStr string, vars ...string) (*gojq.Code, error) {
+	return compileQueryWith(ctx, queryStr, vars)
+}
+
+// compileQueryWith is compileQuery with additional compiler options, such as a
+// module loader.
+func compileQueryWith(ctx context.Context, queryStr string, vars []string, opts ...gojq.CompilerOption) (*gojq.Code, error) {
+	query, err := gojq.Parse(queryStr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse query: %w", err)
+	}
+
+	h := interp.HandlerCtx(ctx)
+	opts = append([]gojq.CompilerOption{
+		gojq.WithVariables(append([]string{"$ENV"}, vars...)),
+		gojq.WithEnvironLoader(func() []string {
+			return shellVars(h.Env)
+		}),
+	}, opts...)
+	code, err := gojq.Compile(query, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compile query: %w", err)
+	}
+	return code, nil
+}
+
+// runQuery runs a query compiled by compileQuery against input. The values
+// of any variables passed to compileQuery are given in the same order. The
+// query stops with an error if ctx is cancelled.
+func runQuery(ctx context.Context, code *gojq.Code, input interface{}, values ...interface{}) gojq.Iter {
+	env := envObject(interp.HandlerCtx(ctx).Env)
+	return code.RunWithContext(ctx, input, append([]interface{}{env}, values...)...)
+}
+
+// envObject returns the exported variables of env as an object, as the env
+// builtin prints them. Since the interpreter's environments visit a variable
+// before any that replace it, such as by unsetting it, a later variable that
+// is not exported removes an earlier one.
+func envObject(env expand.Environ) map[string]interface{} {
+	obj := map[string]interface{}{}
+	env.Each(func(name string, vr expand.Variable) bool {
+		if vr.Exported && vr.IsSet() {
+			obj[name] = vr.String()
+		} else {
+			delete(obj, name)
+		}
+		return true
+	})
+	return obj
+}
+
+// environ returns the exported variables of env as a list of NAME=VALUE
+// strings.
+func environ(env expand.Environ) []string {
+	var list []string
+	for name, val := range envObject(env) {
+		list = append(list, name+"="+val.(string))
+	}
+	return list
+}
+
+// shellVars returns the string variables of env, whether or not they are
+// exported, as a list of NAME=VALUE strings. Arrays are omitted, and replace
+// any earlier variable of the same name as envObject describes.
+func shellVars(env expand.Environ) []string {
+	vars := map[string]string{}
+	env.Each(func(name string, vr expand.Variable) bool {
+		if vr.Kind == expand.String {
+			vars[name] = vr.Str
+		} else {
+			delete(vars, name)
+		}
+		return true
+	})
+	list := make([]string, 0, len(vars))
+	for name, val := range vars {
+		list = append(list, name+"="+val)
+	}
+	return list
+}
+
+// evalQuery compiles queryStr and runs it against input, returning every value
+// produced by the query. Errors produced by the query stop evaluation.
+func evalQuery(ctx context.Context, queryStr string, input interface{}) ([]interface{}, error) {
+	code, err := compileQuery(ctx, queryStr)
+	if err != nil {
+		return nil, err
+	}
+	return evalCode(ctx, code, input)
+}
+
+// evalCode runs a query compiled by compileQuery against input, as evalQuery
+// does. It is used to run the same query against several inputs.
+func evalCode(ctx context.Context, code *gojq.Code, input interface{}) ([]interface{}, error) {
+	var vals []interface{}
+	iter := runQuery(ctx, code, input)
+	for {
+		val, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if err, ok := val.(error); ok {
+			return nil, fmt.Errorf("query error: %w", err)
+		}
+		vals = append(vals, val)
+	}
+	return vals, nil
+}
+
+// jqIdentRe matches jq identifiers, which may be used as object keys in paths
+// and as variable names.
+var jqIdentRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// queryVars is an ordered list of variables bound into queries, such as by
+// -arg. Names include their leading "$".
+type queryVars struct {
+	names  []string
+	values []interface{}
+	// files are the variables whose values are read from files, which are
+	// not read until the handler's working directory is known.
+	files []varFile
+}
+
+// varFile is a query variable whose value is read from the file at path. i is
+// the variable's index in its queryVars. If raw is true, the value is the
+// file's contents as a string, and otherwise an array of its documents.
+type varFile struct {
+	i    int
+	path string
+	raw  bool
+}
+
+func (v *queryVars) add(name string, value interface{}) error {
+	if !jqIdentRe.MatchString(name) {
+		return fmt.Errorf("invalid variable name: %q", name)
+	}
+	v.names = append(v.names, "$"+name)
+	v.values = append(v.values, value)
+	return nil
+}
+
+// addFile adds the variable name, whose value is read from the file at path
+// once the query's files are loaded.
+func (v *queryVars) addFile(name, path string, raw bool) error {
+	if err := v.add(name, nil); err != nil {
+		return err
+	}
+	v.files = append(v.files, varFile{i: len(v.values) - 1, path: path, raw: raw})
+	return nil
+}
+
+// argFlag is a pairFlag that binds a query variable to a string.
+type argFlag struct {
+	vars *queryVars
+}
+
+func (argFlag) pair() {}
+
+func (argFlag) String() string { return "" }
+
+func (a argFlag) Set(v string) error {
+	i := strings.IndexByte(v, '=')
+	if i == -1 {
+		return errors.New("expected a name and value")
+	}
+	return a.vars.add(v[:i], v[i+1:])
+}
+
+// argJSONFlag is a pairFlag that binds a query variable to a value parsed from
+// JSON.
+type argJSONFlag struct {
+	vars *queryVars
+}
+
+func (argJSONFlag) pair() {}
+
+func (argJSONFlag) String() string { return "" }
+
+func (a argJSONFlag) Set(v string) error {
+	i := strings.IndexByte(v, '=')
+	if i == -1 {
+		return errors.New("expected a name and JSON value")
+	}
+	var val interface{}
+	if err := json.Unmarshal([]byte(v[i+1:]), &val); err != nil {
+		return fmt.Errorf("invalid JSON for $%s: %w", v[:i], err)
+	}
+	return a.vars.add(v[:i], val)
+}
+
+// slurpFileFlag is a pairFlag that binds a query variable to an array of the
+// JSON or YAML documents in a file.
+type slurpFileFlag struct {
+	vars *queryVars
+}
+
+func (slurpFileFlag) pair() {}
+
+func (slurpFileFlag) String() string { return "" }
+
+func (s slurpFileFlag) Set(v string) error {
+	i := strings.IndexByte(v, '=')
+	if i == -1 {
+		return errors.New("expected a name and file")
+	}
+	return s.vars.addFile(v[:i], v[i+1:], false)
+}
+
+// rawFileFlag is a pairFlag that binds a query variable to the contents of a
+// file as a string.
+type rawFileFlag struct {
+	vars *queryVars
+}
+
+func (rawFileFlag) pair() {}
+
+func (rawFileFlag) String() string { return "" }
+
+func (r rawFileFlag) Set(v string) error {
+	i := strings.IndexByte(v, '=')
+	if i == -1 {
+		return errors.New("expected a name and file")
+	}
+	return r.vars.addFile(v[:i], v[i+1:], true)
+}
+
+// stringsFlag is a flag.Value that accumulates each occurrence of a flag.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	if s == nil {
+		return ""
+	}
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// toFloat returns val as a float64 if it is a number.
+func toFloat(val interface{}) (float64, bool) {
+	switch val := val.(type) {
+	case float64:
+		return val, true
+	case int:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case uint64:
+		return float64(val), true
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(val).Float64()
+		return f, true
+	case json.Number:
+		f, err := val.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// handlerPath resolves path relative to the handler's working directory.
+func handlerPath(h interp.HandlerContext, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(h.Dir, path)
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory as
+// path and renames it over path, so that readers never observe a partially
+// written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// mergeObjects returns the deep merge of src into dst. Objects present in both
+// are merged recursively, while any other value in src replaces the value in
+// dst. Neither dst nor src is modified.
+func mergeObjects(dst, src map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(dst)+len(src))
+	for k, v := range dst {
+		merged[k] = v
+	}
+	for k, v := range src {
+		sobj, sok := v.(map[string]interface{})
+		dobj, dok := merged[k].(map[string]interface{})
+		if sok && dok {
+			merged[k] = mergeObjects(dobj, sobj)
+			continue
+		}
+		merged[k] = v
+	}
+	return merged
+}
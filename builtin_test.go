@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQueryEnv(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{name: "unexported", script: "host=web-2\nevent -n env.host", want: "web-2"},
+		{name: "exported", script: "export HOST_ENV=a\nevent -n env.HOST_ENV", want: "a"},
+		{name: "changed", script: "export START=b\nevent -n env.START", want: "b"},
+		{name: "$ENV exported", script: "export START=b\nevent -n '$ENV.START'", want: "b"},
+		{name: "$ENV process", script: "event -n '$ENV.PATH == \"'\"$PATH\"'\"'", want: "true"},
+		{name: "$ENV unexported", script: "host=web-2\nevent -n '$ENV.host'", want: ""},
+		{name: "$ENV export later", script: "host=web-2\nexport host\nevent -n '$ENV.host'", want: "web-2"},
+		{name: "$ENV unset", script: "unset START\nevent -n '$ENV | has(\"START\")'", want: "false"},
+		{name: "$ENV subshell", script: "(export SUB=c; event -n '$ENV.SUB')\nevent -n '$ENV.SUB'", want: "c"},
+		{name: "$ENV and env", script: "export A=1\nb=2\nevent -n -ndjson '[$ENV.A == env.A, ($ENV | has(\"b\")), (env | has(\"b\"))]'", want: "[true,false,true]\n"},
+		{name: "$ENV and env builtin", script: "export A=1\nb=2\nunset START\n[ \"$(event -n -ndjson '$ENV')\" = \"$(env -ndjson)\" ] && env -ndjson '[.A, .b, .START]'", want: "[\"1\",null,null]\n"},
+		{name: "env unset", script: "unset START\nevent -n 'env | has(\"START\")'", want: "false"},
+		{name: "array", script: "arr=(1 2)\nevent -n 'env | has(\"arr\")'", want: "false"},
+	}, nil, "START=a")
+}
+
+// TestQueryEnvEvent checks that $ENV holds the variables set from the event.
+func TestQueryEnvEvent(t *testing.T) {
+	dir := tempDir(t)
+	out := filepath.Join(dir, "out")
+	script := `event -n '[$ENV.SENSU_ENTITY_NAME, $ENV.SENSU_CHECK_NAME, env.SENSU_CHECK_NAME]' > ` + out
+	if status, logged := runMain(context.Background(), t, "-E", testEventFile(t), "-R", script); status != 0 {
+		t.Fatalf("status = %d; logged: %s", status, logged)
+	}
+	got, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `["web-1","disk","disk"]`; strings.Join(strings.Fields(string(got)), "") != want {
+		t.Errorf("output = %s; want %s", got, want)
+	}
+}
+
+func TestQueryTimeFunctions(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{name: "now", script: `event -n 'now | type'`, want: "number"},
+		{name: "strftime", script: `event '.timestamp | strftime("%Y-%m-%dT%H:%M:%SZ")'`, want: "2020-09-13T12:26:40Z"},
+		{name: "todate", script: `event '.timestamp | todate'`, want: "2020-09-13T12:26:40Z"},
+		{name: "fromdate", script: `event -n '"2020-09-13T12:26:40Z" | fromdate'`, want: "1600000000"},
+		{name: "strptime", script: `event -n '"2020-09-13 12:26:40" | strptime("%Y-%m-%d %H:%M:%S") | mktime'`, want: "1600000000"},
+		{name: "gmtime", script: `event -ndjson '.timestamp | gmtime'`, want: "[2020,8,13,12,26,40,0,256]\n"},
+	}, nil)
+}
+
+func TestMergeObjects(t *testing.T) {
+	type obj = map[string]interface{}
+	cases := []struct {
+		name     string
+		dst, src obj
+		want     obj
+	}{
+		{"empty", obj{}, obj{}, obj{}},
+		{"add", obj{"a": 1}, obj{"b": 2}, obj{"a": 1, "b": 2}},
+		{"override", obj{"a": 1}, obj{"a": 2}, obj{"a": 2}},
+		{"nested", obj{"a": obj{"x": 1, "y": 1}}, obj{"a": obj{"y": 2, "z": 2}}, obj{"a": obj{"x": 1, "y": 2, "z": 2}}},
+		{"arrays replaced", obj{"a": []interface{}{1, 2}}, obj{"a": []interface{}{3}}, obj{"a": []interface{}{3}}},
+		{"object replaces scalar", obj{"a": 1}, obj{"a": obj{"b": 1}}, obj{"a": obj{"b": 1}}},
+		{"scalar replaces object", obj{"a": obj{"b": 1}}, obj{"a": "x"}, obj{"a": "x"}},
+		{"null replaces", obj{"a": obj{"b": 1}}, obj{"a": nil}, obj{"a": nil}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dst, src := fmt.Sprint(c.dst), fmt.Sprint(c.src)
+			if got := mergeObjects(c.dst, c.src); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("mergeObjects(%v, %v) = %v; want %v", c.dst, c.src, got, c.want)
+			}
+			if fmt.Sprint(c.dst) != dst || fmt.Sprint(c.src) != src {
+				t.Errorf("mergeObjects modified its arguments: %v, %v", c.dst, c.src)
+			}
+		})
+	}
+}
+
+// TestMergeEventFiles checks that events given by repeated -E flags are
+// merged in order.
+func TestMergeEventFiles(t *testing.T) {
+	dir := tempDir(t)
+	base := writeFile(t, dir, "base.json", `{"entity": {"metadata": {"name": "web-1", "labels": {"a": "1", "b": "1"}}, "subscriptions": ["x", "y"]}, "check": {"status": 0}}`)
+	overlay := writeFile(t, dir, "overlay.yaml", "entity:\n  metadata:\n    labels:\n      b: \"2\"\n  subscriptions: [z]\ncheck:\n  status: 2\n")
+	last := writeFile(t, dir, "last.json", `{"check": {"status": 1, "output": "ok"}}`)
+	out := filepath.Join(dir, "out.json")
+
+	status, logged := runMain(context.Background(), t, "-E", base, "-E", overlay, "-E", last, "-write-event", out, "-R", "true")
+	if status != 0 {
+		t.Fatalf("status = %d; logged: %s", status, logged)
+	}
+	got, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"check":{"output":"ok","status":1},"entity":{"metadata":{"labels":{"a":"1","b":"2"},"name":"web-1"},"subscriptions":["z"]}}` + "\n"
+	if string(got) != want {
+		t.Errorf("merged event = %s; want %s", got, want)
+	}
+}
+
+func TestSlurpFile(t *testing.T) {
+	dir := tempDir(t)
+	thresholds := writeFile(t, dir, "t.yaml", "max: 90\n---\nmax: 95\n")
+	keys := writeFile(t, dir, "keys.yaml", "1: one\ntrue: yes\n")
+	empty := writeFile(t, dir, "empty.json", "")
+	bad := writeFile(t, dir, "bad.json", "{")
+
+	runScriptCases(t, []scriptCase{
+		{name: "documents", script: `event -slurpfile t ` + thresholds + ` '$t | length'`, want: "2"},
+		{name: "compare", script: `event -slurpfile t ` + thresholds + ` '.check.status < $t[0].max'`, want: "true"},
+		{name: "json documents", script: `event -ndjson -slurpfile t ` + thresholds + ` '$t'`, want: `[{"max":90},{"max":95}]` + "\n"},
+		{name: "normalized", script: `event -ndjson -slurpfile k ` + keys + ` '$k[0] | keys'`, want: `["1","true"]` + "\n"},
+		{name: "empty", script: `event -ndjson -slurpfile e ` + empty + ` '$e'`, want: "[]\n"},
+		{name: "stdin", script: `printf 'a: 1\n---\na: 2\n' | event -slurpfile s - '$s | map(.a) | add'`, want: "3"},
+		{name: "with arg", script: `event -arg x y -slurpfile t ` + thresholds + ` -argjson z 3 '[$x, ($t | length), $z] | join(",")'`, want: "y,2,3"},
+		{name: "query command", script: `query -slurpfile t ` + thresholds + ` '. + $t[1].max' n`, want: "100"},
+		{name: "missing", script: `event -slurpfile t ` + filepath.Join(dir, "missing") + ` '$t'`, status: 1, wantErr: "t: "},
+		{name: "parse error", script: `event -slurpfile t ` + bad + ` '$t'`, status: 1, wantErr: "t: error decoding [" + bad + "]"},
+		{name: "no file", script: `event -slurpfile t`, status: 1},
+	}, nil, "n=5")
+}
+
+func TestRawFile(t *testing.T) {
+	dir := tempDir(t)
+	cert := writeFile(t, dir, "cert.pem", "-----BEGIN-----\nabc\n-----END-----\n")
+	docs := writeFile(t, dir, "docs.yaml", "a: 1\n---\na: 2\n")
+
+	runScriptCases(t, []scriptCase{
+		{name: "length", script: `event -rawfile c ` + cert + ` '$c | length'`, want: "34"},
+		{name: "lines", script: `event -rawfile c ` + cert + ` '$c | split("\n") | .[1]'`, want: "abc"},
+		{name: "verbatim", script: `event -ndjson -rawfile c ` + cert + ` '$c'`, want: `"-----BEGIN-----\nabc\n-----END-----\n"` + "\n"},
+		{name: "not decoded", script: `event -rawfile d ` + docs + ` '$d | type'`, want: "string"},
+		{name: "stdin", script: `printf 'x\ny' | event -rawfile s - '$s | length'`, want: "3"},
+		{
+			name:   "order",
+			script: `event -ndjson -arg a 1 -rawfile b ` + docs + ` -slurpfile c ` + docs + ` -argjson d 4 '[$a, ($b | length), ($c | length), $d]'`,
+			want:   `["1",14,2,4]` + "\n",
+		},
+		{name: "later binding", script: `event -arg c 1 -rawfile c ` + cert + ` '$c | length'`, want: "34"},
+		{name: "missing", script: `event -rawfile c ` + filepath.Join(dir, "missing") + ` '$c'`, status: 1, wantErr: "c: "},
+	}, nil)
+}
+
+func TestChainQueries(t *testing.T) {
+	cases := []struct {
+		name      string
+		args      []string
+		want      string
+		wantRest  []string
+		wantError string
+	}{
+		{"single", []string{".a", "src"}, ".a", []string{"src"}, ""},
+		{"alternative operator", []string{`.a // "x"`}, `.a // "x"`, []string{}, ""},
+		{"chain", []string{".a", "//", ".b", "//", ".c", "src"}, "(.a\n) | (.b\n) | (.c\n)", []string{"src"}, ""},
+		{"comment", []string{".a # x", "//", ".b"}, "(.a # x\n) | (.b\n)", []string{}, ""},
+		{"trailing separator", []string{".a", "//"}, "", nil, "expected a query after //"},
+		{"parse error", []string{".a", "//", ".["}, "", nil, "unable to parse query 2"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, rest, err := chainQueries(c.args)
+			if c.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), c.wantError) {
+					t.Fatalf("chainQueries(%q) error = %v; want %q", c.args, err, c.wantError)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("chainQueries(%q): %v", c.args, err)
+			}
+			if got != c.want {
+				t.Errorf("chainQueries(%q) = %q; want %q", c.args, got, c.want)
+			}
+			if !reflect.DeepEqual(rest, c.wantRest) {
+				t.Errorf("chainQueries(%q) rest = %q; want %q", c.args, rest, c.wantRest)
+			}
+		})
+	}
+}
+
+func TestChainedQueries(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{name: "event", script: `event .check // .metadata // .name`, want: "disk"},
+		{name: "each result", script: `event -n '1, 2' // '. * 10, . + 1'`, want: "10\n2\n20\n3"},
+		{name: "alternative operator", script: `event '.missing // "none"'`, want: "none"},
+		{name: "query source", script: `checks='[{"status": 1}, {"status": 2}]'` + "\n" + `query '.[]' // .status checks`, want: "1\n2"},
+		{name: "error stops chain", script: `event .check // 'error("bad")' // .name`, status: 1, wantErr: "bad"},
+		{name: "trailing separator", script: `event .check //`, status: 1, wantErr: "expected a query after //"},
+		{name: "parse error", script: `event .check // '.['`, status: 1, wantErr: "unable to parse query 2"},
+		{name: "too many", script: `event .check // .status extra`, status: 1, wantErr: "too many arguments to event"},
+	}, nil)
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestCBORDecoder(t *testing.T) {
+	// Most cases are the examples of RFC 8949, appendix A.
+	cases := []struct {
+		in string
+		// want is the decoded value as JSON.
+		want string
+		err  string
+	}{
+		{"00", `0`, ""},
+		{"17", `23`, ""},
+		{"1818", `24`, ""},
+		{"1903e8", `1000`, ""},
+		{"1a000f4240", `1000000`, ""},
+		{"1b000000e8d4a51000", `1000000000000`, ""},
+		{"1bffffffffffffffff", `18446744073709551615`, ""},
+		{"c249010000000000000000", `18446744073709551616`, ""},
+		{"3bffffffffffffffff", `-18446744073709551616`, ""},
+		{"c349010000000000000000", `-18446744073709551617`, ""},
+		{"20", `-1`, ""},
+		{"3863", `-100`, ""},
+		{"3903e7", `-1000`, ""},
+		{"f90000", `0`, ""},
+		{"f93c00", `1`, ""},
+		{"fb3ff199999999999a", `1.1`, ""},
+		{"f93e00", `1.5`, ""},
+		{"f97bff", `65504`, ""},
+		{"fa47c35000", `100000`, ""},
+		{"fa7f7fffff", `3.4028234663852886e+38`, ""},
+		{"fb7e37e43c8800759c", `1e+300`, ""},
+		{"f90001", `5.960464477539063e-8`, ""},
+		{"f9c400", `-4`, ""},
+		{"f4", `false`, ""},
+		{"f5", `true`, ""},
+		{"f6", `null`, ""},
+		{"f7", `null`, ""},
+		{"40", `""`, ""},
+		{"4401020304", `"\u0001\u0002\u0003\u0004"`, ""},
+		{"60", `""`, ""},
+		{"6449455446", `"IETF"`, ""},
+		{"62c3bc", `"ü"`, ""},
+		{"80", `[]`, ""},
+		{"8301820203820405", `[1,[2,3],[4,5]]`, ""},
+		{"a0", `{}`, ""},
+		{"a201020304", `{"1":2,"3":4}`, ""},
+		{"a26161016162820203", `{"a":1,"b":[2,3]}`, ""},
+		{"c074323031332d30332d32315432303a30343a30305a", `"2013-03-21T20:04:00Z"`, ""},
+		{"d82076687474703a2f2f7777772e6578616d706c652e636f6d", `"http://www.example.com"`, ""},
+		{"c24100", `0`, ""},
+		{"5f42010243030405ff", `"\u0001\u0002\u0003\u0004\u0005"`, ""},
+		{"7f657374726561646d696e67ff", `"streaming"`, ""},
+		{"9fff", `[]`, ""},
+		{"9f018202039f0405ffff", `[1,[2,3],[4,5]]`, ""},
+		{"bf61610161629f0203ffff", `{"a":1,"b":[2,3]}`, ""},
+		{"ff", "", "unexpected CBOR break"},
+		{"bf6161ff", "", "CBOR map is missing a value"},
+		{"1c", "", "invalid CBOR additional information 28"},
+		{"f820", "", "unsupported CBOR simple value 24"},
+		{"5f6161ff", "", "invalid chunk in indefinite-length CBOR string"},
+		{"c201", "", "CBOR bignum holds number instead of a byte string"},
+		{"a18001", "", "unsupported CBOR map key: array"},
+		{"6261", "", io.ErrUnexpectedEOF.Error()},
+		{"8201", "", io.ErrUnexpectedEOF.Error()},
+		{"19 03", "", io.ErrUnexpectedEOF.Error()},
+	}
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			var doc interface{}
+			err := newCBORDecoder(strings.NewReader(fromHex(t, c.in))).Decode(&doc)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("Decode(%s) = %v, %v; want an error containing %q", c.in, doc, err, c.err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("Decode(%s): %v", c.in, err)
+			}
+			var want interface{}
+			if err := json.Unmarshal([]byte(c.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			got, _ := json.Marshal(doc)
+			wantJSON, _ := json.Marshal(want)
+			if c.want[0] != '"' && c.want[0] != '[' && c.want[0] != '{' {
+				// Compare numbers as written, since large integers lose
+				// precision as float64s.
+				wantJSON = []byte(c.want)
+			}
+			if string(got) != string(wantJSON) {
+				t.Errorf("Decode(%s) = %s; want %s", c.in, got, wantJSON)
+			}
+		})
+	}
+}
+
+func TestCBORDecoderSequence(t *testing.T) {
+	dec := newCBORDecoder(strings.NewReader(fromHex(t, "01 6161 a1616102")))
+	var docs []interface{}
+	for {
+		var doc interface{}
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, doc)
+	}
+	got, _ := json.Marshal(docs)
+	if want := `[1,"a",{"a":2}]`; string(got) != want {
+		t.Errorf("decoded %s; want %s", got, want)
+	}
+}
+
+func TestCBOREncoder(t *testing.T) {
+	bigInt := func(s string) *big.Int {
+		n, _ := new(big.Int).SetString(s, 10)
+		return n
+	}
+	cases := []struct {
+		name string
+		val  interface{}
+		want string
+		err  string
+	}{
+		{"null", nil, "f6", ""},
+		{"false", false, "f4", ""},
+		{"true", true, "f5", ""},
+		{"zero", 0, "00", ""},
+		{"small", 23, "17", ""},
+		{"uint8", 24, "1818", ""},
+		{"uint16", 1000, "1903e8", ""},
+		{"uint32", 1000000, "1a000f4240", ""},
+		{"uint64", int64(1000000000000), "1b000000e8d4a51000", ""},
+		{"negative", -1, "20", ""},
+		{"negative uint16", -1000, "3903e7", ""},
+		{"integral float", 2.0, "02", ""},
+		{"negative integral float", -100.0, "3863", ""},
+		{"float", 1.1, "fb3ff199999999999a", ""},
+		{"max uint64", uint64(18446744073709551615), "1bffffffffffffffff", ""},
+		{"bignum", bigInt("18446744073709551616"), "c249010000000000000000", ""},
+		{"negative bignum", bigInt("-18446744073709551617"), "c349010000000000000000", ""},
+		{"small big.Int", big.NewInt(-1), "20", ""},
+		{"number", json.Number("12345678901234567890"), "1bab54a98ceb1f0ad2", ""},
+		{"number bignum", json.Number("-18446744073709551617"), "c349010000000000000000", ""},
+		{"number float", json.Number("1.1"), "fb3ff199999999999a", ""},
+		{"string", "IETF", "6449455446", ""},
+		{"utf-8", "ü", "62c3bc", ""},
+		{"array", []interface{}{1, []interface{}{2, 3}}, "8201820203", ""},
+		{"sorted keys", map[string]interface{}{"b": []interface{}{2, 3}, "a": 1}, "a26161016162820203", ""},
+		{"unsupported", struct{}{}, "", "cannot encode struct {} as CBOR"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var buf strings.Builder
+			err := newCBOREncoder(&buf).Encode(c.val)
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Fatalf("Encode(%v) = %v; want %q", c.val, err, c.err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("Encode(%v): %v", c.val, err)
+			}
+			if got := hex.EncodeToString([]byte(buf.String())); got != c.want {
+				t.Errorf("Encode(%v) = %s; want %s", c.val, got, c.want)
+			}
+		})
+	}
+}
+
+func TestCBORRoundTrip(t *testing.T) {
+	dir := tempDir(t)
+	writeFile(t, dir, "items.cbor", fromHex(t, "a26161016162820203 9f01ff"))
+	cases := []scriptCase{
+		{name: "round trip", script: `event -cbor -o check.cbor .check && query -cbor-input -files -ndjson . check.cbor`,
+			want: `{"metadata":{"name":"disk"},"status":1}` + "\n"},
+		{name: "sequence", script: `event -n -cbor -o seq.cbor '1, "a", [null]' && query -cbor-input -files -ndjson -s . seq.cbor`,
+			want: `[1,"a",[null]]` + "\n"},
+		{name: "fixture", script: `query -cbor-input -files -ndjson . items.cbor`, want: "{\"a\":1,\"b\":[2,3]}\n[1]\n"},
+		{name: "cbor command", script: `cbor event '.check.status'`, want: "\x01"},
+		{name: "cbor base64", script: `cbor -base64 event '{a: 1}'`, want: "oWFhAQ==\n"},
+		{name: "strict numbers", script: `query -cbor-input -strict-numbers -files . items.cbor`, status: 1, wantErr: "-cbor-input cannot be combined"},
+		{name: "xml input", script: `query -cbor-input -xml-input -files . items.cbor`, status: 1, wantErr: "-cbor-input cannot be combined"},
+		{name: "format conflict", script: `event -cbor -json .`, status: 1, wantErr: "-cbor"},
+	}
+	for i := range cases {
+		cases[i].script = "cd " + dir + "\n" + cases[i].script
+	}
+	runScriptCases(t, cases, nil)
+}
+
+func TestCBORRandomRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		want := randomValues{}.randomValue(r, 4)
+		var buf bytes.Buffer
+		if err := newCBOREncoder(&buf).Encode(want); err != nil {
+			t.Fatalf("Encode(%#v): %v", want, err)
+		}
+		var got interface{}
+		dec := newCBORDecoder(bytes.NewReader(buf.Bytes()))
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("Decode(%x) of %#v: %v", buf.Bytes(), want, err)
+		} else if !sameValue(got, want) {
+			t.Fatalf("Decode(%x) = %#v; want %#v", buf.Bytes(), got, want)
+		}
+		if err := dec.Decode(&got); err != io.EOF {
+			t.Fatalf("Decode after %x = %v; want EOF", buf.Bytes(), err)
+		}
+	}
+}
+
+// TestCBORDecodeCorrupt decodes truncated, mutated, and random input, which
+// must fail without panicking or allocating more than the input holds.
+func TestCBORDecodeCorrupt(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	decode := func(p []byte) error {
+		var doc interface{}
+		dec := newCBORDecoder(bytes.NewReader(p))
+		for {
+			if err := dec.Decode(&doc); err != nil {
+				return err
+			}
+		}
+	}
+	for i := 0; i < 2000; i++ {
+		var buf bytes.Buffer
+		if err := newCBOREncoder(&buf).Encode(randomValues{}.randomValue(r, 3)); err != nil {
+			t.Fatal(err)
+		}
+		p := buf.Bytes()
+		for n := 1; n < len(p); n++ {
+			if err := decode(p[:n]); err == io.EOF {
+				t.Fatalf("Decode(%x), a prefix of %x, succeeded", p[:n], p)
+			}
+		}
+		for j := 0; j < 4; j++ {
+			q := append([]byte(nil), p...)
+			q[r.Intn(len(q))] = byte(r.Intn(256))
+			decode(q)
+		}
+		q := make([]byte, r.Intn(32))
+		r.Read(q)
+		decode(q)
+	}
+
+	// Lengths far beyond the input fail once the input runs out.
+	for _, c := range []struct{ in, err string }{
+		{"5a ffffffff 00", io.ErrUnexpectedEOF.Error()},
+		{"5b ffffffffffffffff 00", "CBOR string length 18446744073709551615 is too long"},
+		{"7b 8000000000000000 00", "CBOR string length 9223372036854775808 is too long"},
+		{"9b ffffffffffffffff 00", io.ErrUnexpectedEOF.Error()},
+		{"bb ffffffffffffffff 0000", io.ErrUnexpectedEOF.Error()},
+	} {
+		if err := decode([]byte(fromHex(t, c.in))); err == nil || err.Error() != c.err {
+			t.Errorf("Decode(%s) = %v; want %q", c.in, err, c.err)
+		}
+	}
+}
+
+func TestCBORDecodeDepth(t *testing.T) {
+	for _, head := range []string{"81", "9f", "a1 00", "c6"} {
+		p := bytes.Repeat([]byte(fromHex(t, head)), maxDecodeDepth+1)
+		var doc interface{}
+		err := newCBORDecoder(bytes.NewReader(p)).Decode(&doc)
+		if err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+			t.Errorf("Decode(%s × %d) = %v; want an error for nesting too deeply", head, maxDecodeDepth+1, err)
+		}
+	}
+
+	p := append(bytes.Repeat([]byte{0x81}, maxDecodeDepth-1), 0x01)
+	var doc interface{}
+	if err := newCBORDecoder(bytes.NewReader(p)).Decode(&doc); err != nil {
+		t.Errorf("Decode of %d nested arrays: %v", maxDecodeDepth-1, err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"time"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// certExpiry implements the cert-expiry builtin. It queries the event for a
+// PEM-encoded certificate, prints the number of days until it expires, and
+// exits with a warning or critical status if it expires within the given
+// number of days:
+//
+//	cert-expiry [options] QUERY
+func (p *Prog) certExpiry(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "cert-expiry")
+	f := flag.NewFlagSet("cert-expiry", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	warn, crit := 30, 7
+	f.IntVar(&warn, "warn", warn, "Exit with a warning status if the certificate expires within `days`.")
+	f.IntVar(&crit, "crit", crit, "Exit with a critical status if the certificate expires within `days`.")
+
+	pos, err := parseArgs(f, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(statusUnknown)
+	}
+
+	if len(pos) != 1 {
+		logger.Printf("expected a query")
+		return interp.NewExitStatus(statusUnknown)
+	}
+
+	vals, err := p.evalUserQuery(ctx, pos[0], p.currentEvent())
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(statusUnknown)
+	} else if len(vals) != 1 {
+		logger.Printf("query must produce exactly one value, got %d", len(vals))
+		return interp.NewExitStatus(statusUnknown)
+	}
+
+	str, ok := vals[0].(string)
+	if !ok {
+		logger.Printf("query result is not a string: %T", vals[0])
+		return interp.NewExitStatus(statusUnknown)
+	}
+
+	cert, err := parseCertificate([]byte(str))
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(statusUnknown)
+	}
+
+	days := int(math.Floor(time.Until(cert.NotAfter).Hours() / 24))
+	if _, err := fmt.Fprintln(h.Stdout, days); err != nil {
+		logger.Printf("error writing days: %v", err)
+		return interp.NewExitStatus(statusUnknown)
+	}
+
+	switch {
+	case days <= crit:
+		return interp.NewExitStatus(statusCritical)
+	case days <= warn:
+		return interp.NewExitStatus(statusWarning)
+	}
+	return nil
+}
+
+// parseCertificate parses the first certificate in PEM-encoded data.
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no PEM certificate found")
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate: %w", err)
+		}
+		return cert, nil
+	}
+}
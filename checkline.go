@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+const (
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+	ansiReset = "\x1b[0m"
+)
+
+// checkLine implements the check-line builtin. It prints a line reporting
+// whether a named check passed or failed. Any failure causes the script to
+// exit with a critical status once it completes:
+//
+//	check-line [-color WHEN] NAME pass|fail
+//
+// As with the -color option of event and query, the label is only colored by
+// default if standard output is a terminal and NO_COLOR is not set.
+func (p *Prog) checkLine(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "check-line")
+	f := flag.NewFlagSet("check-line", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	color := "auto"
+	// -color WHEN
+	f.Var(colorFlag{&color}, "color", "Color the label `when` auto, always, or never. Auto colors it only if standard output is a terminal and NO_COLOR is not set.")
+
+	if err := f.Parse(args[1:]); errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	if f.NArg() != 2 {
+		logger.Printf("expected a name and a status")
+		return interp.NewExitStatus(1)
+	}
+
+	name, status := f.Arg(0), f.Arg(1)
+	label, sgr := "", ""
+	switch status {
+	case "pass":
+		label, sgr = "PASS", ansiGreen
+	case "fail":
+		label, sgr = "FAIL", ansiRed
+		p.failed = true
+	default:
+		logger.Printf("invalid status %q: expected pass or fail", status)
+		return interp.NewExitStatus(1)
+	}
+
+	line := fmt.Sprintf("[%s] %s\n", label, name)
+	if color == "always" || color == "auto" && autoColor(h.Stdout, h.Env) {
+		line = fmt.Sprintf("%s[%s]%s %s\n", sgr, label, ansiReset, name)
+	}
+	if _, err := io.WriteString(h.Stdout, line); err != nil {
+		logger.Printf("error writing output: %v", err)
+		return interp.NewExitStatus(1)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestCheckLine(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{name: "pass", script: `check-line disk pass`, want: "[PASS] disk\n"},
+		{name: "fail", script: `check-line disk fail; echo $?`, want: "[FAIL] disk\n0\n"},
+		{name: "always", script: `check-line -color always disk pass; check-line -color always load fail`, want: "\x1b[32m[PASS]\x1b[0m disk\n\x1b[31m[FAIL]\x1b[0m load\n"},
+		{name: "always with NO_COLOR", script: `NO_COLOR=1 check-line -color always disk pass`, want: "\x1b[32m[PASS]\x1b[0m disk\n"},
+		{name: "never", script: `check-line -color never disk pass`, want: "[PASS] disk\n"},
+		{name: "pipe", script: `check-line disk pass | cat`, want: "[PASS] disk\n"},
+		{name: "invalid color", script: `check-line -color sometimes disk pass`, status: 1, wantErr: "must be one of auto, always, or never"},
+		{name: "invalid status", script: `check-line disk maybe`, status: 1, wantErr: `invalid status "maybe"`},
+		{name: "missing status", script: `check-line disk`, status: 1, wantErr: "expected a name and a status"},
+		{name: "write error", script: `check-line disk pass >/dev/full`, status: 1, wantErr: "check-line: error writing output"},
+	}, nil)
+}
+
+func TestCheckLineFailure(t *testing.T) {
+	p := &Prog{event: testEvent()}
+	if _, _, status := runScript(t, p, "check-line a pass\ncheck-line b fail\ncheck-line c pass"); status != 0 {
+		t.Fatalf("status = %d; want 0", status)
+	}
+	if !p.failed {
+		t.Error("failed = false after a failed check; want true")
+	}
+}
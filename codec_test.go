@@ -0,0 +1,153 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"math/rand"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// randomValues controls the values generated by randomValue for the
+// randomized tests of the binary codecs, which have no fuzzing of their own
+// since go.mod predates native fuzzing.
+type randomValues struct {
+	// noNull omits null, which TOML cannot represent.
+	noNull bool
+	// noBigInts omits integers outside of the int64 range.
+	noBigInts bool
+	// text generates only valid UTF-8 strings.
+	text bool
+}
+
+// randomValue returns a random value of the types that decoders produce after
+// normalizeValue. Integral numbers are ints or *big.Ints, never float64s.
+func (o randomValues) randomValue(r *rand.Rand, depth int) interface{} {
+	n := 7
+	if depth <= 0 {
+		n = 5
+	}
+	switch r.Intn(n) {
+	case 0:
+		if o.noNull {
+			return r.Intn(2) == 0
+		}
+		return nil
+	case 1:
+		return r.Intn(2) == 0
+	case 2:
+		return o.randomInt(r)
+	case 3:
+		return o.randomFloat(r)
+	case 4:
+		return o.randomString(r)
+	case 5:
+		list := make([]interface{}, r.Intn(5))
+		for i := range list {
+			list[i] = o.randomValue(r, depth-1)
+		}
+		return list
+	default:
+		return o.randomObject(r, depth-1)
+	}
+}
+
+// randomObject returns a random object with up to four entries.
+func (o randomValues) randomObject(r *rand.Rand, depth int) map[string]interface{} {
+	obj := map[string]interface{}{}
+	for i := r.Intn(5); i > 0; i-- {
+		obj[o.randomString(r)] = o.randomValue(r, depth)
+	}
+	return obj
+}
+
+func (o randomValues) randomInt(r *rand.Rand) interface{} {
+	switch r.Intn(6) {
+	case 0:
+		return r.Intn(48) - 24
+	case 1:
+		return int(r.Int63() >> uint(r.Intn(63)))
+	case 2:
+		return -int(r.Int63()>>uint(r.Intn(63))) - 1
+	case 3:
+		return []int{math.MaxInt64, math.MinInt64, math.MaxInt32 + 1, math.MinInt32 - 1, 1 << 53, 255, 256, 65535, 65536}[r.Intn(9)]
+	}
+	if o.noBigInts {
+		return int(r.Int63())
+	}
+	n := new(big.Int).Lsh(big.NewInt(r.Int63()+1), uint(64+r.Intn(64)))
+	if r.Intn(2) == 0 {
+		n.Neg(n)
+	}
+	return n
+}
+
+func (o randomValues) randomFloat(r *rand.Rand) float64 {
+	var f float64
+	switch r.Intn(4) {
+	case 0:
+		f = r.Float64()
+	case 1:
+		f = r.NormFloat64() * 1e6
+	case 2:
+		f = math.Float64frombits(r.Uint64())
+	default:
+		f = []float64{0.5, -0.5, 1.5, 65504.5, 1e-300, 5e-324, math.MaxFloat64, math.Inf(1), math.Inf(-1)}[r.Intn(9)]
+	}
+	if math.IsNaN(f) || f == math.Trunc(f) {
+		// Integral floats decode as integers, and NaN is never equal.
+		return 0.25
+	}
+	return f
+}
+
+func (o randomValues) randomString(r *rand.Rand) string {
+	const alphabet = "ab_-. \"'\\\n\t\x00\x1f\x7fé€😀[]{}=#"
+	var sb strings.Builder
+	for i := r.Intn(8); i > 0; i-- {
+		if !o.text && r.Intn(8) == 0 {
+			sb.WriteByte(byte(r.Intn(256)))
+			continue
+		}
+		c, _ := utf8.DecodeRuneInString(alphabet[r.Intn(len(alphabet)):])
+		if c == utf8.RuneError {
+			c = 'x'
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+// sameValue reports whether a and b are deeply equal, comparing *big.Ints by
+// value.
+func sameValue(a, b interface{}) bool {
+	switch a := a.(type) {
+	case *big.Int:
+		b, ok := b.(*big.Int)
+		return ok && a.Cmp(b) == 0
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !sameValue(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			if bv, ok := b[k]; !ok || !sameValue(v, bv) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"io"
+
+	"mvdan.cc/sh/v3/expand"
+)
+
+// Colors of JSON output, as SGR parameters. These are jq's default colors.
+const (
+	colorNull   = "1;30"
+	colorFalse  = "0;39"
+	colorTrue   = "0;39"
+	colorNumber = "0;39"
+	colorString = "0;32"
+	colorArray  = "1;39"
+	colorObject = "1;39"
+	colorKey    = "34;1"
+)
+
+// colorWriter is a Writer that colorizes the JSON written to it with terminal
+// escape sequences. Like asciiWriter, it is only used to wrap JSON encoders,
+// which write each value in a single call, so that no token is split across
+// writes.
+type colorWriter struct {
+	w io.Writer
+}
+
+func (c colorWriter) Write(p []byte) (int, error) {
+	buf := make([]byte, 0, 2*len(p))
+	color := func(sgr string, tok []byte) {
+		buf = append(buf, "\x1b["...)
+		buf = append(buf, sgr...)
+		buf = append(buf, 'm')
+		buf = append(buf, tok...)
+		buf = append(buf, ansiReset...)
+	}
+
+	for i := 0; i < len(p); {
+		switch b := p[i]; {
+		case b == '"':
+			end := i + 1
+			for end < len(p) && p[end] != '"' {
+				if p[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end < len(p) {
+				end++
+			}
+			sgr := colorString
+			if isKey(p[end:]) {
+				sgr = colorKey
+			}
+			color(sgr, p[i:end])
+			i = end
+		case b == '{' || b == '}':
+			color(colorObject, p[i:i+1])
+			i++
+		case b == '[' || b == ']':
+			color(colorArray, p[i:i+1])
+			i++
+		case b == 't' || b == 'f' || b == 'n':
+			end := i
+			for end < len(p) && p[end] >= 'a' && p[end] <= 'z' {
+				end++
+			}
+			sgr := colorNull
+			if b == 't' {
+				sgr = colorTrue
+			} else if b == 'f' {
+				sgr = colorFalse
+			}
+			color(sgr, p[i:end])
+			i = end
+		case b == '-' || b >= '0' && b <= '9':
+			end := i + 1
+			for end < len(p) && isNumberByte(p[end]) {
+				end++
+			}
+			color(colorNumber, p[i:end])
+			i = end
+		default:
+			buf = append(buf, b)
+			i++
+		}
+	}
+
+	if _, err := c.w.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// isKey returns whether the JSON following a string, rest, begins with a
+// colon, making the string an object key.
+func isKey(rest []byte) bool {
+	for _, b := range rest {
+		switch b {
+		case ' ', '\t', '\n', '\r':
+			continue
+		}
+		return b == ':'
+	}
+	return false
+}
+
+func isNumberByte(b byte) bool {
+	return b >= '0' && b <= '9' || b == '.' || b == 'e' || b == 'E' || b == '+' || b == '-'
+}
+
+// colorFlag is a flag.Value for -color, which must be one of auto, always, or
+// never.
+type colorFlag struct {
+	mode *string
+}
+
+func (c colorFlag) String() string {
+	if c.mode == nil {
+		return ""
+	}
+	return *c.mode
+}
+
+func (c colorFlag) Set(v string) error {
+	switch v {
+	case "auto", "always", "never":
+		*c.mode = v
+		return nil
+	}
+	return errors.New("must be one of auto, always, or never")
+}
+
+// autoColor reports whether -color=auto colorizes output written to w, which
+// it does only if w is a terminal and the NO_COLOR variable is not set in env.
+func autoColor(w io.Writer, env expand.Environ) bool {
+	return isTerminal(w) && !env.Get("NO_COLOR").IsSet()
+}
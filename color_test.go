@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestColorWriter(t *testing.T) {
+	// c wraps tok in the escape sequences for sgr.
+	c := func(sgr, tok string) string { return "\x1b[" + sgr + "m" + tok + ansiReset }
+	cases := []struct {
+		name, in, want string
+	}{
+		{"null", "null", c(colorNull, "null")},
+		{"bools", "[true,false]", c(colorArray, "[") + c(colorTrue, "true") + "," + c(colorFalse, "false") + c(colorArray, "]")},
+		{"numbers", "[-1.5e3,0]", c(colorArray, "[") + c(colorNumber, "-1.5e3") + "," + c(colorNumber, "0") + c(colorArray, "]")},
+		{"string", `"a"`, c(colorString, `"a"`)},
+		{"escaped quote", `"a\"b"`, c(colorString, `"a\"b"`)},
+		{"escaped backslash", `"a\\"`, c(colorString, `"a\\"`)},
+		{"key", `{"a":"b"}`, c(colorObject, "{") + c(colorKey, `"a"`) + ":" + c(colorString, `"b"`) + c(colorObject, "}")},
+		{"pretty key", "{\n  \"a\": 1\n}\n", c(colorObject, "{") + "\n  " + c(colorKey, `"a"`) + ": " + c(colorNumber, "1") + "\n" + c(colorObject, "}") + "\n"},
+		{"string like key", `["a", ":"]`, c(colorArray, "[") + c(colorString, `"a"`) + ", " + c(colorString, `":"`) + c(colorArray, "]")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := colorWriter{&buf}.Write([]byte(tc.in))
+			if err != nil {
+				t.Fatal(err)
+			} else if n != len(tc.in) {
+				t.Errorf("Write(%q) = %d; want %d", tc.in, n, len(tc.in))
+			}
+			if got := buf.String(); got != tc.want {
+				t.Errorf("Write(%q) wrote %q; want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestColorOutput(t *testing.T) {
+	const query = `'{a: [1, "s", true, null]}'`
+	plain := `{"a":[1,"s",true,null]}` + "\n"
+	cases := []struct {
+		name, script string
+		env          []string
+		color        bool
+	}{
+		{"default piped", "event -json -n " + query, nil, false},
+		{"auto piped", "event -json -color auto -n " + query, nil, false},
+		{"never", "event -json -color never -n " + query, nil, false},
+		{"always", "event -json -color always -n " + query, nil, true},
+		{"always ndjson", "event -ndjson -color always -n " + query, nil, true},
+		{"always with NO_COLOR", "event -json -color always -n " + query, []string{"NO_COLOR=1"}, true},
+		{"auto with NO_COLOR", "event -json -n " + query, []string{"NO_COLOR=1"}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := &Prog{event: testEvent()}
+			stdout, stderr, status := runScript(t, p, c.script, c.env...)
+			if status != 0 {
+				t.Fatalf("status = %d; stderr: %s", status, stderr)
+			}
+			if colored := strings.Contains(stdout, "\x1b["); colored != c.color {
+				t.Errorf("stdout = %q; want colored = %v", stdout, c.color)
+			}
+			if !c.color && stdout != plain {
+				t.Errorf("stdout = %q; want %q", stdout, plain)
+			}
+			if c.color && stripANSI(stdout) != plain {
+				t.Errorf("stdout without colors = %q; want %q", stripANSI(stdout), plain)
+			}
+		})
+	}
+}
+
+// stripANSI removes the escape sequences written by colorWriter from s.
+func stripANSI(s string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "\x1b[")
+		if i < 0 {
+			return b.String() + s
+		}
+		b.WriteString(s[:i])
+		s = s[i+strings.IndexByte(s[i:], 'm')+1:]
+	}
+}
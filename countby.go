@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/itchyny/gojq"
+)
+
+// countEncoder is an Encoder that tallies the values produced by a key query
+// against each value encoded instead of writing them.
+type countEncoder struct {
+	ctx    context.Context
+	key    *gojq.Code
+	vars   []interface{}
+	counts map[string]interface{}
+}
+
+// newCountEncoder returns a countEncoder for the key query, which is run with
+// the values of the variables it was compiled with.
+func newCountEncoder(ctx context.Context, key *gojq.Code, vars []interface{}) *countEncoder {
+	return &countEncoder{ctx: ctx, key: key, vars: vars, counts: map[string]interface{}{}}
+}
+
+// Encode counts each value produced by the key query against val. String
+// values are counted as-is, while other values are counted by their JSON
+// encoding.
+func (c *countEncoder) Encode(val interface{}) error {
+	iter := runQuery(c.ctx, c.key, val, c.vars...)
+	for {
+		key, ok := iter.Next()
+		if !ok {
+			return nil
+		}
+		if err, ok := key.(error); ok {
+			return fmt.Errorf("count key error: %w", err)
+		}
+
+		str, ok := key.(string)
+		if !ok {
+			p, err := json.Marshal(key)
+			if err != nil {
+				return fmt.Errorf("count key error: %w", err)
+			}
+			str = string(p)
+		}
+		n, _ := c.counts[str].(int)
+		c.counts[str] = n + 1
+	}
+}
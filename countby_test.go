@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestCountBy(t *testing.T) {
+	const logs = `logs=$'{"level":"info","n":1}\n{"level":"error","n":1}\n{"level":"info"}'` + "\n"
+	runScriptCases(t, []scriptCase{
+		{name: "ndjson", script: logs + `printf '%s\n' "$logs" | query -strict-numbers -count-by .level . -`, want: `{"error":1,"info":2}`},
+		{name: "ndjson without strict numbers", script: logs + `printf '%s\n' "$logs" | query -count-by .level . -`, status: 1, wantErr: "did not find expected <document start>"},
+		{name: "yaml", script: "docs=$'level: info\\n---\\nlevel: error\\n---\\nlevel: info'\nquery -count-by .level . docs", want: `{"error":1,"info":2}`},
+		{name: "non-string keys", script: logs + `printf '%s\n' "$logs" | query -strict-numbers -count-by .n . -`, want: `{"1":2,"null":1}`},
+		{name: "several keys", script: `query -n -count-by '.[]' '[1, 2], [2]'`, want: `{"1":1,"2":2}`},
+		{name: "arg", script: logs + `printf '%s\n' "$logs" | query -strict-numbers -arg lvl info -count-by '.level == $lvl' . -`, want: `{"false":1,"true":2}`},
+		{name: "argjson", script: logs + `printf '%s\n' "$logs" | query -strict-numbers -argjson n 1 -count-by '.n == $n' . -`, want: `{"false":1,"true":2}`},
+		{name: "results", script: logs + `printf '%s\n' "$logs" | query -strict-numbers -count-by . '.level | ascii_upcase' -`, want: `{"ERROR":1,"INFO":2}`},
+		{name: "no results", script: logs + `printf '%s\n' "$logs" | query -strict-numbers -count-by .level empty -`, want: `{}`},
+		{name: "key error", script: `query -n -count-by 'error("bad")' 1`, status: 1, wantErr: "count key error"},
+		{name: "undefined variable", script: `query -n -count-by '$lvl' 1`, status: 1, wantErr: "count key"},
+		{name: "split-by", script: `query -count-by . -split-by . -split-dir . . doc`, status: 1, wantErr: "-count-by cannot be combined with -split-by"},
+	}, nil)
+}
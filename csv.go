@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+)
+
+// csvEncoder is an Encoder that writes arrays as CSV records. An array of
+// scalars is written as a single record, while an array of arrays of scalars
+// is written as one record per element.
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func newCSVEncoder(w io.Writer, comma rune) *csvEncoder {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	return &csvEncoder{w: cw}
+}
+
+func (c *csvEncoder) Encode(val interface{}) error {
+	rows, ok := val.([]interface{})
+	if !ok {
+		return fmt.Errorf("CSV output requires an array, got %s", typeName(val))
+	}
+
+	if len(rows) > 0 {
+		if _, nested := rows[0].([]interface{}); !nested {
+			rows = []interface{}{rows}
+		}
+	}
+
+	for _, row := range rows {
+		fields, ok := row.([]interface{})
+		if !ok {
+			return fmt.Errorf("CSV output requires an array of arrays or scalars, got a mix")
+		}
+		record := make([]string, len(fields))
+		for i, field := range fields {
+			switch field.(type) {
+			case []interface{}, map[string]interface{}:
+				return fmt.Errorf("CSV fields must be scalars, got %s", typeName(field))
+			}
+			str, err := plainString(field)
+			if err != nil {
+				return err
+			}
+			record[i] = str
+		}
+		if err := c.w.Write(record); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
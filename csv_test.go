@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+func TestCSVEncoder(t *testing.T) {
+	cases := []struct {
+		name  string
+		comma rune
+		in    interface{}
+		want  string
+		err   bool
+	}{
+		{"record", ',', []interface{}{"web-1", 0, true, nil}, "web-1,0,true,\n", false},
+		{"records", ',', []interface{}{[]interface{}{"host", "status"}, []interface{}{"web-1", 1.5}}, "host,status\nweb-1,1.5\n", false},
+		{"comma", ',', []interface{}{"a,b", "c"}, "\"a,b\",c\n", false},
+		{"newline", ',', []interface{}{"a\nb", "c"}, "\"a\nb\",c\n", false},
+		{"quote", ',', []interface{}{`say "hi"`}, "\"say \"\"hi\"\"\"\n", false},
+		{"big integer", ',', []interface{}{new(big.Int).Lsh(big.NewInt(1), 70)}, "1180591620717411303424\n", false},
+		{"large float", ',', []interface{}{1e21}, "1000000000000000000000\n", false},
+		{"tsv", '\t', []interface{}{"a b", "c,d"}, "a b\tc,d\n", false},
+		{"tsv tab", '\t', []interface{}{"a\tb", "c"}, "\"a\tb\"\tc\n", false},
+		{"empty", ',', []interface{}{}, "", false},
+		{"not an array", ',', map[string]interface{}{"a": 1}, "", true},
+		{"nested field", ',', []interface{}{[]interface{}{[]interface{}{1}}}, "", true},
+		{"object field", ',', []interface{}{"a", map[string]interface{}{}}, "", true},
+		{"mixed rows", ',', []interface{}{[]interface{}{1}, 2}, "", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := newCSVEncoder(&buf, c.comma).Encode(c.in)
+			if c.err {
+				if err == nil {
+					t.Fatalf("Encode(%v) = %q; want an error", c.in, buf.String())
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("Encode(%v): %v", c.in, err)
+			}
+			if got := buf.String(); got != c.want {
+				t.Errorf("Encode(%v) = %q; want %q", c.in, got, c.want)
+			}
+		})
+	}
+}
+
+func TestCSVOutput(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{name: "csv", script: `event -csv '[.entity.metadata.name, .check.status]'`, want: "web-1,1\n"},
+		{name: "tsv", script: `event -tsv '[["host", "status"], [.entity.metadata.name, .check.status]]'`, want: "host\tstatus\nweb-1\t1\n"},
+		{name: "not an array", script: `event -csv .check`, status: 1, wantErr: "CSV output requires an array"},
+	}, nil)
+}
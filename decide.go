@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// decide implements the decide builtin. It evaluates critical and then warning
+// conditions against a source and exits with the status of the first condition
+// that holds:
+//
+//	decide [options] [source]
+func (p *Prog) decide(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "decide")
+	f := flag.NewFlagSet("decide", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	var crit, warn stringsFlag
+	f.Var(&crit, "crit", "Exit with a critical status if `query` is true. May be repeated.")
+	f.Var(&warn, "warn", "Exit with a warning status if `query` is true. May be repeated.")
+
+	pos, err := parseArgs(f, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(statusUnknown)
+	}
+
+	source := "event"
+	switch len(pos) {
+	case 1:
+		source = pos[0]
+	case 0:
+	default:
+		logger.Printf("expected at most one source")
+		return interp.NewExitStatus(statusUnknown)
+	}
+
+	if len(crit) == 0 && len(warn) == 0 {
+		logger.Printf("no condition given: expected -crit or -warn")
+		return interp.NewExitStatus(statusUnknown)
+	}
+
+	docs, err := p.inputs(ctx, source)
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(statusUnknown)
+	}
+
+	rules := []struct {
+		label  string
+		status int
+		conds  []string
+	}{
+		{"CRITICAL", statusCritical, crit},
+		{"WARNING", statusWarning, warn},
+	}
+	for _, rule := range rules {
+		for _, cond := range rule.conds {
+			ok, err := p.holds(ctx, cond, docs)
+			if err != nil {
+				logger.Printf("%s: %v", cond, err)
+				return interp.NewExitStatus(statusUnknown)
+			} else if ok {
+				fmt.Fprintf(h.Stdout, "%s: %s\n", rule.label, cond)
+				return interp.NewExitStatus(uint8(rule.status))
+			}
+		}
+	}
+
+	fmt.Fprintln(h.Stdout, "OK")
+	return nil
+}
+
+// holds returns whether the query cond produces a truthy value for any of
+// docs. As in jq, every value other than false and null is truthy.
+func (p *Prog) holds(ctx context.Context, cond string, docs []interface{}) (bool, error) {
+	code, err := p.compileUserQuery(ctx, cond)
+	if err != nil {
+		return false, err
+	}
+	for _, doc := range docs {
+		iter := runQuery(ctx, code, doc)
+		for {
+			val, ok := iter.Next()
+			if !ok {
+				break
+			}
+			if err, ok := val.(error); ok {
+				return false, fmt.Errorf("query error: %w", err)
+			}
+			if val != nil && val != false {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// drift implements the drift builtin. It compares a source to a baseline
+// stored in a JSON file, prints the paths that differ, and exits with status
+// 1 if any do:
+//
+//	drift [options] BASELINE [source]
+func (p *Prog) drift(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "drift")
+	f := flag.NewFlagSet("drift", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	var ignore stringsFlag
+	f.Var(&ignore, "ignore", "Ignore the values at `path` when comparing. May be repeated.")
+	update := false
+	f.BoolVar(&update, "update", update, "Replace the baseline with the source after comparing.")
+
+	pos, err := parseArgs(f, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(2)
+	}
+
+	source := "event"
+	switch len(pos) {
+	case 2:
+		source = pos[1]
+	case 1:
+	default:
+		logger.Printf("expected a baseline file and optional source")
+		return interp.NewExitStatus(2)
+	}
+
+	docs, err := p.inputs(ctx, source)
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(2)
+	} else if len(docs) != 1 {
+		logger.Printf("source must hold exactly one document, got %d", len(docs))
+		return interp.NewExitStatus(2)
+	}
+	current := docs[0]
+
+	path, err := p.root.resolveTarget(handlerPath(h, pos[0]))
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(2)
+	}
+	baseline, found, err := readBaseline(path)
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(2)
+	} else if !found && !update {
+		logger.Printf("baseline [%s] does not exist: use -update to create it", path)
+		return interp.NewExitStatus(2)
+	}
+
+	drifted := false
+	if found {
+		a, b := baseline, current
+		if len(ignore) > 0 {
+			del := "del(" + strings.Join(ignore, ", ") + ")"
+			if a, err = evalOne(ctx, del, a); err != nil {
+				logger.Printf("error ignoring paths in baseline: %v", err)
+				return interp.NewExitStatus(2)
+			}
+			if b, err = evalOne(ctx, del, b); err != nil {
+				logger.Printf("error ignoring paths in source: %v", err)
+				return interp.NewExitStatus(2)
+			}
+		}
+
+		diffValues("", a, b, func(change, path string) {
+			drifted = true
+			fmt.Fprintf(h.Stdout, "%s: %s\n", change, path)
+		})
+	}
+
+	if update {
+		data, err := json.Marshal(current)
+		if err != nil {
+			logger.Printf("error encoding baseline: %v", err)
+			return interp.NewExitStatus(2)
+		}
+		if err := writeFileAtomic(path, data, 0644); err != nil {
+			logger.Printf("error writing baseline [%s]: %v", path, err)
+			return interp.NewExitStatus(2)
+		}
+	}
+
+	if drifted {
+		return interp.NewExitStatus(1)
+	}
+	return nil
+}
+
+// readBaseline reads the JSON baseline at path, which has been resolved by
+// resolveTarget. If the baseline does not exist, found is false.
+func readBaseline(path string) (baseline interface{}, found bool, err error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("error reading baseline [%s]: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return nil, false, fmt.Errorf("error parsing baseline [%s]: %w", path, err)
+	}
+	return baseline, true, nil
+}
+
+// evalOne runs queryStr against input and returns its only result.
+func evalOne(ctx context.Context, queryStr string, input interface{}) (interface{}, error) {
+	vals, err := evalQuery(ctx, queryStr, input)
+	if err != nil {
+		return nil, err
+	} else if len(vals) != 1 {
+		return nil, fmt.Errorf("query must produce exactly one value, got %d", len(vals))
+	}
+	return vals[0], nil
+}
+
+// diffValues calls report with the jq path of each difference between a and b.
+// The change is "added" or "removed" for object keys and array elements
+// present in only one of a and b, and "changed" for any other difference.
+// Numbers are equal if they have the same value, regardless of type.
+func diffValues(path string, a, b interface{}, report func(change, path string)) {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(a)+len(b))
+		for k := range a {
+			keys = append(keys, k)
+		}
+		for k := range b {
+			if _, ok := a[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			av, aok := a[k]
+			bv, bok := b[k]
+			switch {
+			case !aok:
+				report("added", jqPathRoot(jqPathKey(path, k)))
+			case !bok:
+				report("removed", jqPathRoot(jqPathKey(path, k)))
+			default:
+				diffValues(jqPathKey(path, k), av, bv, report)
+			}
+		}
+		return
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(a) || i < len(b); i++ {
+			elem := path + "[" + strconv.Itoa(i) + "]"
+			switch {
+			case i >= len(a):
+				report("added", jqPathRoot(elem))
+			case i >= len(b):
+				report("removed", jqPathRoot(elem))
+			default:
+				diffValues(elem, a[i], b[i], report)
+			}
+		}
+		return
+	default:
+		if af, ok := toFloat(a); ok {
+			if bf, ok := toFloat(b); ok && af == bf {
+				return
+			}
+		} else if reflect.DeepEqual(a, b) {
+			return
+		}
+	}
+	report("changed", jqPathRoot(path))
+}
+
+// jqPathKey returns the path of the object key k under path.
+func jqPathKey(path, k string) string {
+	if jqIdentRe.MatchString(k) {
+		return path + "." + k
+	}
+	quoted, _ := json.Marshal(k)
+	return jqPathRoot(path) + "[" + string(quoted) + "]"
+}
+
+// jqPathRoot returns path, or "." if it is empty. Paths built by diffValues
+// omit the leading "." when they begin with an index.
+func jqPathRoot(path string) string {
+	if path == "" || path[0] != '.' {
+		return "." + path
+	}
+	return path
+}
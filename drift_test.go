@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDrift(t *testing.T) {
+	dir := tempDir(t)
+	writeFile(t, dir, "bad.json", "{")
+	const change = "event set '.check.status = 2 | .new = 1 | del(.timestamp)'\n"
+	runScriptCases(t, []scriptCase{
+		{name: "missing", script: "cd " + dir + "\ndrift missing.json", status: 2, wantErr: "baseline [" + filepath.Join(dir, "missing.json") + "] does not exist"},
+		{name: "unchanged", script: "cd " + dir + "\ndrift -update same.json && drift same.json; echo $?", want: "0\n"},
+		{name: "changed", script: "cd " + dir + "\ndrift -update changed.json\n" + change + "drift changed.json", want: "changed: .check.status\nadded: .new\nremoved: .timestamp\n", status: 1},
+		{name: "ignore", script: "cd " + dir + "\ndrift -update ignore.json\n" + change + "drift -ignore .check.status -ignore .new -ignore .timestamp ignore.json", want: ""},
+		{name: "update", script: "cd " + dir + "\ndrift -update update.json\n" + change + "drift -update update.json >/dev/null; drift update.json; echo $?", want: "0\n"},
+		{name: "bad baseline", script: "cd " + dir + "\ndrift bad.json", status: 2, wantErr: "error parsing baseline"},
+	}, nil)
+}
+
+func TestDriftSymlink(t *testing.T) {
+	dir := tempDir(t)
+	target := writeFile(t, dir, "state/baseline.json", `{"a":1}`)
+	link := filepath.Join(dir, "baseline.json")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+	runScriptCases(t, []scriptCase{
+		{name: "through link", script: "doc='{\"a\": 2}'\ndrift -update " + link + " doc", want: "changed: .a\n", status: 1},
+	}, nil)
+
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("baseline symlink replaced: %v, %v", fi, err)
+	}
+	if data, err := ioutil.ReadFile(target); err != nil || string(data) != `{"a":2}` {
+		t.Errorf("symlink target = %q, %v; want {\"a\":2}", data, err)
+	}
+}
+
+func TestDriftRoot(t *testing.T) {
+	dir := tempDir(t)
+	outside := tempDir(t)
+	secret := writeFile(t, outside, "secret.json", `{"a":1}`)
+	if err := os.Symlink(secret, filepath.Join(dir, "secret.json")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "pwned.json"), filepath.Join(dir, "dangling.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	const doc = "doc='{\"a\": 2}'\n"
+	runScriptCases(t, []scriptCase{
+		{name: "inside", script: "cd " + dir + "\n" + doc + "drift -update new.json doc && drift new.json doc; echo $?", want: "0\n"},
+		{name: "absolute", script: doc + "drift -update " + secret + " doc", status: 2, wantErr: "outside of the root"},
+		{name: "link outside", script: "cd " + dir + "\n" + doc + "drift -update secret.json doc", status: 2, wantErr: "outside of the root"},
+		{name: "dangling link", script: "cd " + dir + "\n" + doc + "drift -update dangling.json doc", status: 2, wantErr: "outside of the root"},
+	}, func(p *Prog) {
+		root, err := newFSRoot(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.root = root
+	})
+
+	if data, err := ioutil.ReadFile(secret); err != nil || string(data) != `{"a":1}` {
+		t.Errorf("baseline outside of the root = %q, %v; want it unchanged", data, err)
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "pwned.json")); !os.IsNotExist(err) {
+		t.Errorf("baseline created outside of the root: %v", err)
+	}
+}
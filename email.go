@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// validEmail implements the valid-email builtin. It queries the event for
+// email addresses and prints each in a canonical form, with its domain in
+// lowercase. If any address is invalid, it exits with status 1:
+//
+//	valid-email [query]
+func (p *Prog) validEmail(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "valid-email")
+	f := flag.NewFlagSet("valid-email", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	if err := f.Parse(args[1:]); errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	if f.NArg() != 1 {
+		logger.Printf("expected a query")
+		return interp.NewExitStatus(1)
+	}
+
+	vals, err := p.evalUserQuery(ctx, f.Arg(0), p.currentEvent())
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	} else if len(vals) == 0 {
+		logger.Printf("query produced no addresses")
+		return interp.NewExitStatus(1)
+	}
+
+	var invalid bool
+	for _, val := range vals {
+		str, ok := val.(string)
+		if !ok {
+			logger.Printf("query result is not a string: %v", val)
+			invalid = true
+			continue
+		}
+		addr, err := canonicalEmail(str)
+		if err != nil {
+			logger.Printf("invalid address %q: %v", str, err)
+			invalid = true
+			continue
+		}
+		if _, err := fmt.Fprintln(h.Stdout, addr); err != nil {
+			logger.Printf("error writing address: %v", err)
+			return interp.NewExitStatus(1)
+		}
+	}
+
+	if invalid {
+		return interp.NewExitStatus(1)
+	}
+	return nil
+}
+
+// canonicalEmail parses str as an RFC 5322 address and returns the address
+// alone, with its domain in lowercase.
+func canonicalEmail(str string) (string, error) {
+	addr, err := mail.ParseAddress(str)
+	if err != nil {
+		return "", err
+	}
+	at := strings.LastIndexByte(addr.Address, '@')
+	if at == -1 {
+		return "", errors.New("missing domain")
+	}
+	return addr.Address[:at] + strings.ToLower(addr.Address[at:]), nil
+}
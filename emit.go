@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// emit implements the emit builtin. It prints check output and exits with the
+// given check status. If no message is given, it is read from standard input:
+//
+//	emit [options] [message...]
+func (p *Prog) emit(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "emit")
+	f := flag.NewFlagSet("emit", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	status := statusOK
+	// -s, -status
+	f.IntVar(&status, "s", status, "Exit with the check `status`, from 0 to 3. (long: -status)")
+	f.IntVar(&status, "status", status, "Exit with the check `status`, from 0 to 3. (short: -s)")
+
+	if err := f.Parse(args[1:]); errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(statusUnknown)
+	}
+
+	if status < statusOK || status > statusUnknown {
+		logger.Printf("invalid status %d: expected 0 to 3", status)
+		return interp.NewExitStatus(statusUnknown)
+	}
+
+	msg := strings.Join(f.Args(), " ")
+	if f.NArg() == 0 {
+		data, err := ioutil.ReadAll(h.Stdin)
+		if err != nil {
+			logger.Printf("error reading message: %v", err)
+			return interp.NewExitStatus(statusUnknown)
+		}
+		msg = strings.TrimSuffix(string(data), "\n")
+	}
+
+	if _, err := fmt.Fprintln(h.Stdout, msg); err != nil {
+		logger.Printf("error writing message: %v", err)
+		return interp.NewExitStatus(statusUnknown)
+	}
+	if status != statusOK {
+		return interp.NewExitStatus(uint8(status))
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestEmit(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{name: "ok", script: `emit OK: fine`, want: "OK: fine\n"},
+		{name: "warning", script: `emit -s 1 "WARNING: slow"`, want: "WARNING: slow\n", status: 1},
+		{name: "critical", script: `emit -status 2 CRITICAL: disk full`, want: "CRITICAL: disk full\n", status: 2},
+		{name: "unknown", script: `emit -s 3 ?`, want: "?\n", status: 3},
+		{name: "status variable", script: "emit -s 2 bad\necho $?", want: "bad\n2\n"},
+		{name: "exit with status", script: "emit -s 1 warn || exit", want: "warn\n", status: 1},
+		{name: "stdin", script: `printf 'line 1\nline 2\n' | emit -s 2`, want: "line 1\nline 2\n", status: 2},
+		{name: "empty stdin", script: `emit`, want: "\n"},
+		{name: "invalid status", script: `emit -s 4 x`, status: 3, wantErr: "invalid status 4"},
+		{name: "negative status", script: `emit -s -1 x`, status: 3, wantErr: "invalid status -1"},
+		{name: "bad flag", script: `emit -x`, status: 3},
+	}, nil)
+}
+
+// TestEmitExitStatus checks that the status given to emit becomes the exit
+// status of sensu-sh when emit is the last command.
+func TestEmitExitStatus(t *testing.T) {
+	event := testEventFile(t)
+	for _, c := range []struct {
+		script string
+		status int
+	}{
+		{"emit -s 0 ok >/dev/null", 0},
+		{"emit -s 1 warn >/dev/null", 1},
+		{"emit -s 2 crit >/dev/null", 2},
+		{"emit -s 2 crit >/dev/null; true", 0},
+	} {
+		if status, logged := runMain(context.Background(), t, "-E", event, "-R", c.script); status != c.status {
+			t.Errorf("%s: status = %d; want %d\nlogged: %s", c.script, status, c.status, logged)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"regexp"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// envAssignRe matches the NAME=VALUE arguments of env(1).
+var envAssignRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// isEnvCommand returns whether args are for env(1), rather than the env
+// builtin, because they begin with an assignment or one of env's options.
+func isEnvCommand(args []string) bool {
+	if len(args) < 2 {
+		return false
+	}
+	switch args[1] {
+	case "-", "-i", "-u", "--ignore-environment", "--unset":
+		return true
+	}
+	return envAssignRe.MatchString(args[1])
+}
+
+// envCmd implements the env builtin. It queries an object of the exported
+// variables of the script's environment, including those exported by the
+// script itself, which is the same object as a query's $ENV:
+//
+//	env [options] [query]
+func (p *Prog) envCmd(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "env")
+	f := flag.NewFlagSet("env", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	filter := p.newJSONFilter(logger)
+	filter.bind(f)
+	defer filter.close()
+
+	pos, err := parseArgs(f, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	queryStr := "."
+	switch len(pos) {
+	case 1:
+		queryStr = pos[0]
+	case 0:
+	default:
+		logger.Printf("expected at most one query")
+		return interp.NewExitStatus(1)
+	}
+	queryStr, err = filter.loadQuery(h, queryStr, len(pos) == 1)
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	env := envObject(h.Env)
+	query, err := filter.compile(ctx, queryStr)
+	if err != nil {
+		return err
+	}
+	var input interface{} = env
+	if filter.slurp {
+		input = []interface{}{env}
+	}
+	if err := filter.run(ctx, query, input); err != nil {
+		return err
+	}
+	return filter.finish(ctx)
+}
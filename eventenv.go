@@ -0,0 +1,55 @@
+package main
+
+import "strings"
+
+// eventEnvFields are the event fields exported to the script as environment
+// variables, unless -no-event-env is given.
+var eventEnvFields = []struct {
+	name string
+	path []string
+}{
+	{"SENSU_ENTITY_NAME", []string{"entity", "metadata", "name"}},
+	{"SENSU_ENTITY_CLASS", []string{"entity", "entity_class"}},
+	{"SENSU_NAMESPACE", []string{"entity", "metadata", "namespace"}},
+	{"SENSU_CHECK_NAME", []string{"check", "metadata", "name"}},
+	{"SENSU_CHECK_STATUS", []string{"check", "status"}},
+	{"SENSU_CHECK_STATE", []string{"check", "state"}},
+	{"SENSU_EVENT_ID", []string{"id"}},
+}
+
+// eventEnv returns environ with the eventEnvFields present in event added as
+// NAME=VALUE strings, replacing any variables of the same name. Fields that are
+// missing, null, or not scalars are omitted.
+func eventEnv(environ []string, event map[string]interface{}) []string {
+	vars := map[string]string{}
+	for _, field := range eventEnvFields {
+		var val interface{} = event
+		for _, key := range field.path {
+			obj, _ := val.(map[string]interface{})
+			val = obj[key]
+		}
+		switch val.(type) {
+		case nil, map[string]interface{}, []interface{}:
+			continue
+		}
+		if str, err := plainString(val); err == nil {
+			vars[field.name] = str
+		}
+	}
+
+	env := make([]string, 0, len(environ)+len(vars))
+	for _, kv := range environ {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			if _, ok := vars[kv[:i]]; ok {
+				continue
+			}
+		}
+		env = append(env, kv)
+	}
+	for _, field := range eventEnvFields {
+		if str, ok := vars[field.name]; ok {
+			env = append(env, field.name+"="+str)
+		}
+	}
+	return env
+}
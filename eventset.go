@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+)
+
+// setEvent implements the event set builtin. It runs a query against the
+// event and replaces the event with the single object the query produces, so
+// that subsequent commands see the change:
+//
+//	event set [options] query
+//
+// Like assign, event set fails in subshells, command substitutions, and
+// pipeline stages other than the last.
+func (p *Prog) setEvent(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "event set")
+	f := flag.NewFlagSet("event set", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	var vars queryVars
+	// -arg NAME VALUE, -argjson NAME JSON
+	f.Var(argFlag{&vars}, "arg", "Bind the query variable $`name` to the string value given by the following argument.")
+	f.Var(argJSONFlag{&vars}, "argjson", "Bind the query variable $`name` to the JSON value given by the following argument.")
+
+	pos, err := parseArgs(f, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	} else if len(pos) != 1 {
+		logger.Printf("expected a query")
+		return interp.NewExitStatus(1)
+	}
+
+	event, err := p.updateEvent(ctx, pos[0], vars)
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+	if err := p.replaceEvent(h.Env, event); err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+	return nil
+}
+
+// currentEvent returns the event as it is when called.
+func (p *Prog) currentEvent() map[string]interface{} {
+	p.eventMu.RLock()
+	defer p.eventMu.RUnlock()
+	return p.event
+}
+
+// replaceEvent replaces the event for subsequent commands. As with variables,
+// the event can only be replaced by the script's top-level shell, where env is
+// the environment of the builtin replacing it, so that a subshell cannot
+// change the event seen by the rest of the script.
+func (p *Prog) replaceEvent(env expand.Environ, event map[string]interface{}) error {
+	if !p.topLevel(env) {
+		return errors.New("cannot replace the event outside the top-level shell, such as in a subshell or pipeline")
+	}
+	p.eventMu.Lock()
+	defer p.eventMu.Unlock()
+	p.event = event
+	return nil
+}
+
+// updateEvent returns the result of queryStr run against the event, which
+// must be exactly one object.
+func (p *Prog) updateEvent(ctx context.Context, queryStr string, vars queryVars) (map[string]interface{}, error) {
+	code, err := p.compileUserQuery(ctx, queryStr, vars.names...)
+	if err != nil {
+		return nil, err
+	}
+
+	var event map[string]interface{}
+	iter := runQuery(ctx, code, p.currentEvent(), vars.values...)
+	for n := 0; ; n++ {
+		val, ok := iter.Next()
+		if !ok {
+			if n == 0 {
+				return nil, errors.New("query produced no event")
+			}
+			return event, nil
+		}
+		if err, ok := val.(error); ok {
+			return nil, fmt.Errorf("query error: %w", err)
+		} else if n > 0 {
+			return nil, errors.New("query produced more than one event")
+		}
+		if event, ok = val.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("query produced %s, not an object", typeName(val))
+		}
+	}
+}
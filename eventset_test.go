@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestEventSet(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{name: "set", script: "event set '.annotations.processed = \"true\"'\nevent .annotations.processed", want: "true"},
+		{
+			name:   "sequential",
+			script: "event set '.check.status = 2'\nevent set '.check.status += 1'\nevent set 'del(.timestamp)'\nevent -ndjson '[.check.status, has(\"timestamp\")]'",
+			want:   "[3,false]\n",
+		},
+		{name: "arg", script: `event set -arg who ops '.check.owner = $who'; event .check.owner`, want: "ops"},
+		{name: "argjson", script: `event set -argjson n 5 '.check.status = $n'; event .check.status`, want: "5"},
+		{name: "no results", script: "event set empty", status: 1, wantErr: "query produced no event"},
+		{name: "multiple results", script: "event set '., .'", status: 1, wantErr: "query produced more than one event"},
+		{name: "not an object", script: "event set .check.status", status: 1, wantErr: "query produced number, not an object"},
+		{name: "query error", script: "event set 'error(\"bad\")'", status: 1, wantErr: "query error: error: bad"},
+		{name: "unchanged after error", script: "event set '.check.status = 9, 1' || event .check.status", want: "1"},
+		{name: "no query", script: "event set", status: 1, wantErr: "expected a query"},
+		{name: "subshell", script: "( event set '.check.status = 2'; echo \"inner=$?\" )\nevent .check.status", want: "inner=1\n1", wantErr: "cannot replace the event outside the top-level shell"},
+		{name: "command substitution", script: "st=$(event set '.check.status = 2'; echo $?)\necho $st\nevent .check.status", want: "1\n1", wantErr: "cannot replace the event outside the top-level shell"},
+		{name: "pipeline", script: "event set '.check.status = 2' | event .check.status | event set '.check.status = 3'\nevent .check.status", want: "3", wantErr: "cannot replace the event outside the top-level shell"},
+		{name: "persist", script: "echo '{\"check\": {\"status\": 2}}' | event -merge-event -persist -ndjson .check.status\nevent .check.status", want: "2\n2"},
+		{name: "persist subshell", script: "( echo '{\"check\": {\"status\": 2}}' | event -merge-event -persist -ndjson .check.status )\nevent .check.status", want: "1", wantErr: "cannot replace the event outside the top-level shell"},
+		{name: "pipeline readers", script: "for i in 1 2 3; do event -ndjson .check.status | event set \"if .check.status < 9 then .check.status += $i else . end\"; done\nevent .check.status", want: "7"},
+	}, nil)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// runFilterCmd runs the receiver's filter command with val, encoded as JSON,
+// as its standard input. The command's standard output is decoded as a stream
+// of JSON values, which replace val. The command is split into words as the
+// shell would, and it runs with the handler's environment and directory. It is
+// killed if it runs for longer than the receiver's execTimeout.
+func (j *jsonFilter) runFilterCmd(ctx context.Context, val interface{}) ([]interface{}, error) {
+	h := interp.HandlerCtx(ctx)
+
+	argv, err := commandFields(h, j.filterCmd)
+	if err != nil {
+		return nil, err
+	}
+	if j.checkExec != nil {
+		if err := j.checkExec(argv[0]); err != nil {
+			return nil, err
+		}
+	}
+	path, err := interp.LookPath(h.Env, argv[0])
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := json.Marshal(val)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding input: %w", err)
+	}
+
+	cmdCtx := ctx
+	if j.execTimeout > 0 {
+		var cancel context.CancelFunc
+		cmdCtx, cancel = context.WithTimeout(ctx, j.execTimeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(cmdCtx, path, argv[1:]...)
+	cmd.Env = environ(h.Env)
+	cmd.Dir = h.Dir
+	cmd.Stdin = bytes.NewReader(append(input, '\n'))
+	cmd.Stderr = h.Stderr
+	output, err := cmd.Output()
+	if ctx.Err() == nil && errors.Is(cmdCtx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("%s: timed out after %v", argv[0], j.execTimeout)
+	} else if err != nil {
+		return nil, fmt.Errorf("%s: %w", argv[0], err)
+	}
+
+	var vals []interface{}
+	dec := json.NewDecoder(bytes.NewReader(output))
+	for {
+		var v interface{}
+		if err := dec.Decode(&v); errors.Is(err, io.EOF) {
+			return vals, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: error decoding output: %w", argv[0], err)
+		}
+		vals = append(vals, v)
+	}
+}
+
+// commandFields splits cmd into words as the shell would split a simple
+// command, removing quotes and expanding variables from the handler's
+// environment. Anything other than a single simple command, such as a pipeline
+// or redirection, is an error.
+func commandFields(h interp.HandlerContext, cmd string) ([]string, error) {
+	file, err := syntax.NewParser().Parse(strings.NewReader(cmd), "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid command: %w", err)
+	}
+	if len(file.Stmts) == 0 {
+		return nil, errors.New("empty command")
+	}
+	stmt := file.Stmts[0]
+	call, ok := stmt.Cmd.(*syntax.CallExpr)
+	if len(file.Stmts) > 1 || !ok || len(stmt.Redirs) > 0 || len(call.Assigns) > 0 ||
+		stmt.Negated || stmt.Background || stmt.Coprocess {
+		return nil, fmt.Errorf("invalid command: %q is not a simple command", cmd)
+	}
+
+	fields, err := expand.Fields(&expand.Config{Env: h.Env}, call.Args...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid command: %w", err)
+	} else if len(fields) == 0 {
+		return nil, errors.New("empty command")
+	}
+	return fields, nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFilterCmd(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{name: "cat", script: `event -ndjson -filter-cmd cat .check.status`, want: "1\n"},
+		{name: "quoted", script: `event -ndjson -filter-cmd "sh -c 'cat; echo 2'" .check.status`, want: "1\n2\n"},
+		{name: "variable", script: `event -ndjson -filter-cmd 'sh -c "$CMD"' .check.status`, want: "3\n"},
+		{name: "not simple", script: `event -filter-cmd 'cat | cat' .check.status`, status: 1, wantErr: "not a simple command"},
+		{name: "empty", script: `event -filter-cmd ' ' .check.status`, status: 1, wantErr: "empty command"},
+	}, nil, "CMD=echo 3")
+}
+
+func TestFilterCmdTimeout(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{name: "timeout", script: `event -filter-cmd 'sleep 5' .check.status`, status: 1, wantErr: "sleep: timed out after 100ms"},
+		{name: "fast", script: `event -ndjson -filter-cmd cat .check.status`, want: "1\n"},
+	}, func(p *Prog) { p.execTimeout = 100 * time.Millisecond })
+}
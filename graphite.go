@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// graphiteEncoder is an Encoder that writes metric objects as lines of the
+// Graphite plaintext protocol. Each value must be an object with a non-empty
+// "path" and numeric "value", and may have a numeric "timestamp" in seconds,
+// or an array of such objects. Points without a timestamp use the current
+// time.
+type graphiteEncoder struct {
+	w io.Writer
+}
+
+func newGraphiteEncoder(w io.Writer) *graphiteEncoder {
+	return &graphiteEncoder{w: w}
+}
+
+func (g *graphiteEncoder) Encode(val interface{}) error {
+	points, ok := val.([]interface{})
+	if !ok {
+		points = []interface{}{val}
+	}
+
+	var buf bytes.Buffer
+	for _, point := range points {
+		if err := g.line(&buf, point); err != nil {
+			return fmt.Errorf("%w: %s", err, compactJSON(point))
+		}
+	}
+	_, err := g.w.Write(buf.Bytes())
+	return err
+}
+
+func (g *graphiteEncoder) line(buf *bytes.Buffer, val interface{}) error {
+	point, ok := val.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("metric must be an object, got %s", typeName(val))
+	}
+
+	path, _ := point["path"].(string)
+	if path == "" || strings.ContainsAny(path, " \t\r\n") {
+		return fmt.Errorf("metric path must be a non-empty string without whitespace")
+	}
+	value, ok := metricNumber(point["value"])
+	if !ok {
+		return fmt.Errorf("metric value must be a number")
+	}
+
+	ts := time.Now().Unix()
+	if v, ok := point["timestamp"]; ok && v != nil {
+		f, ok := toFloat(v)
+		if !ok {
+			return fmt.Errorf("metric timestamp must be a number, got %s", typeName(v))
+		}
+		ts = int64(f)
+	}
+
+	buf.WriteString(path)
+	buf.WriteByte(' ')
+	buf.WriteString(value)
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatInt(ts, 10))
+	buf.WriteByte('\n')
+	return nil
+}
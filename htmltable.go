@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"html/template"
+	"sort"
+	"strings"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// htmlTableTemplate renders a table from its columns and rows of cells.
+var htmlTableTemplate = template.Must(template.New("table").Parse(
+	`<table style="border-collapse: collapse; font-family: sans-serif;">
+<thead>
+<tr>{{range .Columns}}<th style="border: 1px solid #ccc; padding: 4px 8px; text-align: left; background: #f0f0f0;">{{.}}</th>{{end}}</tr>
+</thead>
+<tbody>
+{{range .Rows}}<tr>{{range .}}<td style="border: 1px solid #ccc; padding: 4px 8px;">{{.}}</td>{{end}}</tr>
+{{end}}</tbody>
+</table>
+`))
+
+// htmlTable implements the html-table builtin. It queries a source for an
+// array of objects and prints an HTML table with a row per object:
+//
+//	html-table [options] SOURCE QUERY
+func (p *Prog) htmlTable(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "html-table")
+	f := flag.NewFlagSet("html-table", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	columns := ""
+	// -c, -columns
+	f.StringVar(&columns, "c", columns, "Comma-separated `list` of columns to include, in order. (long: -columns)")
+	f.StringVar(&columns, "columns", columns, "Comma-separated `list` of columns to include, in order. (short: -c)")
+
+	pos, err := parseArgs(f, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	if len(pos) != 2 {
+		logger.Printf("expected a source and a query")
+		return interp.NewExitStatus(1)
+	}
+
+	docs, err := p.inputs(ctx, pos[0])
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	query, err := p.compileUserQuery(ctx, pos[1])
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	var rows []map[string]interface{}
+	for _, doc := range docs {
+		vals, err := evalCode(ctx, query, doc)
+		if err != nil {
+			logger.Print(err)
+			return interp.NewExitStatus(1)
+		}
+		for _, val := range vals {
+			arr, ok := val.([]interface{})
+			if !ok {
+				logger.Printf("query result is not an array: %T", val)
+				return interp.NewExitStatus(1)
+			}
+			for _, elem := range arr {
+				row, ok := elem.(map[string]interface{})
+				if !ok {
+					logger.Printf("array element is not an object: %T", elem)
+					return interp.NewExitStatus(1)
+				}
+				rows = append(rows, row)
+			}
+		}
+	}
+
+	var cols []string
+	if columns != "" {
+		cols = strings.Split(columns, ",")
+	} else {
+		seen := map[string]bool{}
+		for _, row := range rows {
+			for k := range row {
+				if !seen[k] {
+					seen[k] = true
+					cols = append(cols, k)
+				}
+			}
+		}
+		sort.Strings(cols)
+	}
+
+	cells := make([][]string, len(rows))
+	for i, row := range rows {
+		cells[i] = make([]string, len(cols))
+		for k, col := range cols {
+			v, ok := row[col]
+			if !ok {
+				continue
+			}
+			if cells[i][k], err = plainString(v); err != nil {
+				logger.Printf("error formatting cell: %v", err)
+				return interp.NewExitStatus(1)
+			}
+		}
+	}
+
+	data := struct {
+		Columns []string
+		Rows    [][]string
+	}{cols, cells}
+	if err := htmlTableTemplate.Execute(h.Stdout, data); err != nil {
+		logger.Printf("error writing table: %v", err)
+		return interp.NewExitStatus(1)
+	}
+	return nil
+}
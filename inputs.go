@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/itchyny/gojq"
+)
+
+// inputIter is a gojq.Iter over the documents decoded from a list of sources,
+// in order. Each source is opened only once the documents before it have been
+// read, so that a query that never reads its input never opens a source. The
+// iterator is shared by the query command's loop over its inputs and the
+// input and inputs functions of the query, so that documents read by either
+// are not seen by the other.
+//
+// Errors opening or decoding a source are returned as values and end the
+// iteration.
+type inputIter struct {
+	sources []string
+	open    func(source string) (io.ReadCloser, error)
+	decoder func(r io.Reader) Decoder
+
+	r   io.ReadCloser
+	or  *offsetReader
+	dec Decoder
+	err error
+}
+
+func (it *inputIter) Next() (interface{}, bool) {
+	if it.err != nil {
+		return nil, false
+	}
+	for {
+		if it.dec == nil {
+			if len(it.sources) == 0 {
+				return nil, false
+			}
+			r, err := it.open(it.sources[0])
+			it.sources = it.sources[1:]
+			if err != nil {
+				it.err = err
+				return err, true
+			}
+			it.r, it.or = r, newOffsetReader(r)
+			it.dec = it.decoder(it.or)
+		}
+
+		var doc interface{}
+		err := it.dec.Decode(&doc)
+		if err == nil {
+			return doc, true
+		}
+		or := it.or
+		it.close()
+		if !errors.Is(err, io.EOF) {
+			it.err = fmt.Errorf("error decoding input: %w", or.annotate(err))
+			return it.err, true
+		}
+	}
+}
+
+// errNoMoreInputs is the error raised by a query's input function once every
+// document has been read, as it is in jq.
+var errNoMoreInputs = errors.New("No more inputs")
+
+// inputsDef replaces the inputs function of gojq, which ends the iteration by
+// catching the error raised by input. In gojq, that try also catches breaks
+// raised after inputs has produced a value, so label and break (and with them
+// first and limit) can't be used with it.
+const inputsDef = `def inputs: label $__inputs | foreach repeat(null) as $__x (null; try input catch (if . == "No more inputs" then break $__inputs else error end));`
+
+// queryInputs is the gojq.Iter passed to a query for its input and inputs
+// functions. Once the documents of it are exhausted, it produces
+// errNoMoreInputs, where gojq would otherwise raise a bare "break".
+type queryInputs struct {
+	it gojq.Iter
+}
+
+func (q queryInputs) Next() (interface{}, bool) {
+	v, ok := q.it.Next()
+	if !ok {
+		return errNoMoreInputs, true
+	}
+	return v, true
+}
+
+// close closes the source currently being read, if any.
+func (it *inputIter) close() {
+	if it.r != nil {
+		it.r.Close()
+	}
+	it.r, it.or, it.dec = nil, nil, nil
+}
+
+// arrayStreamDecoder is a Decoder for the elements of a stream of JSON arrays.
+// Elements are decoded one at a time, so only a single element of an array is
+// held in memory at once.
+type arrayStreamDecoder struct {
+	dec     *json.Decoder
+	inArray bool
+}
+
+func newArrayStreamDecoder(r io.Reader, strictNumbers bool) *arrayStreamDecoder {
+	dec := json.NewDecoder(r)
+	if strictNumbers {
+		dec.UseNumber()
+	}
+	return &arrayStreamDecoder{dec: dec}
+}
+
+func (a *arrayStreamDecoder) Decode(v interface{}) error {
+	for {
+		if !a.inArray {
+			tok, err := a.dec.Token()
+			if err != nil {
+				return err
+			} else if tok != json.Delim('[') {
+				return fmt.Errorf("expected an array, got %v", tok)
+			}
+			a.inArray = true
+		}
+
+		if a.dec.More() {
+			return a.dec.Decode(v)
+		}
+
+		// Consume the closing bracket.
+		if _, err := a.dec.Token(); errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		} else if err != nil {
+			return err
+		}
+		a.inArray = false
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQueryInputs(t *testing.T) {
+	const docs = `docs=$'a: 1\n---\na: 2\n---\na: 3'` + "\n"
+	runScriptCases(t, []scriptCase{
+		{name: "input", script: `docs=$'1\n---\n2\n---\n3\n---\n4'` + "\n" + `query -ndjson '[., input]' docs`, want: "[1,2]\n[3,4]\n"},
+		{name: "inputs", script: docs + `query -n -ndjson '[inputs]' docs`, want: "[{\"a\":1},{\"a\":2},{\"a\":3}]\n"},
+		{name: "inputs after input", script: docs + `query -ndjson '[., inputs]' docs`, want: "[{\"a\":1},{\"a\":2},{\"a\":3}]\n"},
+		{name: "no inputs", script: `query -n -ndjson '[inputs]' docs`, want: "[]\n"},
+		{name: "null documents", script: `docs=$'null\n---\n1'` + "\n" + `query -n -ndjson '[inputs]' docs`, want: "[null,1]\n"},
+		{name: "first", script: docs + `query -n 'first(inputs)' docs`, want: `{"a":1}`},
+		{name: "limit", script: docs + `query -n -ndjson '[limit(2; inputs)]' docs`, want: "[{\"a\":1},{\"a\":2}]\n"},
+		{name: "limit leaves inputs", script: docs + `query -ndjson '[limit(1; inputs)]' docs`, want: "[{\"a\":2}]\n[]\n"},
+		{name: "label", script: docs + `query -n 'label $f | inputs | .a | if . == 2 then ., break $f else empty end' docs`, want: "2"},
+		{name: "reduce", script: docs + `query -n 'reduce inputs as $x (0; . + $x.a)' docs`, want: "6"},
+		{name: "no more inputs", script: docs + `query -ndjson '[., input]' docs`, want: "[{\"a\":1},{\"a\":2}]\n", status: 1, wantErr: "No more inputs"},
+		{name: "try input", script: docs + `query -ndjson '[., input?]' docs`, want: "[{\"a\":1},{\"a\":2}]\n[{\"a\":3}]\n"},
+		{name: "decode error", script: `docs=$'a: 1\n---\n: : ['` + "\n" + `query -n -ndjson '[inputs]' docs`, status: 1, wantErr: "error decoding input"},
+	}, nil)
+}
+
+func TestInputIterOpensLazily(t *testing.T) {
+	var opened []string
+	it := &inputIter{
+		sources: []string{"a", "b"},
+		open: func(source string) (io.ReadCloser, error) {
+			opened = append(opened, source)
+			return ioutil.NopCloser(strings.NewReader("1 2")), nil
+		},
+		decoder: func(r io.Reader) Decoder { return newArrayStreamDecoder(strings.NewReader("[1, 2]"), false) },
+	}
+	defer it.close()
+	if len(opened) != 0 {
+		t.Fatalf("opened = %q before Next; want none", opened)
+	}
+	var got []interface{}
+	for {
+		v, ok := it.Next()
+		if !ok {
+			break
+		}
+		got = append(got, v)
+		if len(got) == 1 && !reflect.DeepEqual(opened, []string{"a"}) {
+			t.Errorf("opened = %q after the first document; want [a]", opened)
+		}
+	}
+	if want := []interface{}{1.0, 2.0, 1.0, 2.0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("documents = %v; want %v", got, want)
+	}
+	if v, ok := (queryInputs{it}).Next(); !ok || !errors.Is(v.(error), errNoMoreInputs) {
+		t.Errorf("queryInputs.Next() = %v, %v; want %v", v, ok, errNoMoreInputs)
+	}
+}
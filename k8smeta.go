@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+var (
+	// k8sNameRe matches the name of a Kubernetes label key and label values.
+	k8sNameRe = regexp.MustCompile(`^(?:[A-Za-z0-9](?:[-A-Za-z0-9_.]*[A-Za-z0-9])?)?$`)
+	// k8sPrefixRe matches a DNS subdomain used as a label key prefix.
+	k8sPrefixRe = regexp.MustCompile(`^[a-z0-9](?:[-a-z0-9]*[a-z0-9])?(?:\.[a-z0-9](?:[-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+// k8sMeta implements the k8s-meta builtin. It maps the event entity's labels
+// and annotations to a Kubernetes metadata object and runs an optional query
+// against it:
+//
+//	k8s-meta [options] [query]
+func (p *Prog) k8sMeta(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "k8s-meta")
+	f := flag.NewFlagSet("k8s-meta", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	skip := false
+	// -skip-invalid
+	f.BoolVar(&skip, "skip-invalid", skip, "Omit invalid labels and annotations instead of failing.")
+
+	filter := p.newJSONFilter(logger)
+	filter.bind(f)
+	defer filter.close()
+
+	if err := f.Parse(pairArgs(f, args[1:])); errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	queryStr := "."
+	if f.NArg() == 1 {
+		queryStr = f.Arg(0)
+	} else if f.NArg() > 1 {
+		logger.Printf("too many arguments to k8s-meta: expected 0..1")
+		return interp.NewExitStatus(1)
+	}
+	queryStr, err := filter.loadQuery(h, queryStr, f.NArg() == 1)
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	vals, err := evalQuery(ctx, ".entity.metadata | {labels, annotations}", p.currentEvent())
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+	src, _ := vals[0].(map[string]interface{})
+
+	invalid := false
+	meta := map[string]interface{}{}
+	for _, kind := range []string{"labels", "annotations"} {
+		fields, _ := src[kind].(map[string]interface{})
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		out := map[string]interface{}{}
+		for _, k := range keys {
+			v, err := plainString(fields[k])
+			if err == nil {
+				err = validK8sKey(k)
+			}
+			if err == nil && kind == "labels" && (len(v) > 63 || !k8sNameRe.MatchString(v)) {
+				err = fmt.Errorf("invalid value %q", v)
+			}
+			if err != nil {
+				logger.Printf("%s: %s: %v", kind, k, err)
+				invalid = true
+				continue
+			}
+			out[k] = v
+		}
+		meta[kind] = out
+	}
+
+	if invalid && !skip {
+		return interp.NewExitStatus(1)
+	}
+
+	query, err := filter.compile(ctx, queryStr)
+	if err != nil {
+		return err
+	}
+	if err := filter.run(ctx, query, map[string]interface{}{"metadata": meta}); err != nil {
+		return err
+	}
+	return filter.finish(ctx)
+}
+
+// validK8sKey returns an error if key is not a valid Kubernetes label or
+// annotation key.
+func validK8sKey(key string) error {
+	name := key
+	if i := strings.IndexByte(key, '/'); i != -1 {
+		prefix := key[:i]
+		name = key[i+1:]
+		if len(prefix) > 253 || !k8sPrefixRe.MatchString(prefix) {
+			return fmt.Errorf("invalid key prefix %q", prefix)
+		}
+	}
+	if name == "" || len(name) > 63 || !k8sNameRe.MatchString(name) {
+		return fmt.Errorf("invalid key name %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"strings"
+)
+
+// newLogger returns a logger for the named component, such as a builtin, that
+// writes to w. Messages are prefixed by the component's name, or written as
+// JSON objects with -log-json.
+func (p *Prog) newLogger(w io.Writer, component string) *log.Logger {
+	if p.logJSON {
+		return log.New(jsonLogWriter{w: w, component: component}, "", 0)
+	}
+	return log.New(w, component+": ", 0)
+}
+
+// jsonLogWriter is a Writer for a log.Logger that writes each message as a
+// line of JSON: {"level":"error","component":"query","msg":"..."}. The logger
+// must have no prefix or flags, so that each write is only a message.
+type jsonLogWriter struct {
+	w         io.Writer
+	component string
+}
+
+func (j jsonLogWriter) Write(p []byte) (int, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(struct {
+		Level     string `json:"level"`
+		Component string `json:"component"`
+		Msg       string `json:"msg"`
+	}{"error", j.component, strings.TrimSuffix(string(p), "\n")})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := j.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
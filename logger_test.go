@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	cases := []struct {
+		name    string
+		logJSON bool
+		want    string
+	}{
+		{"plain", false, "query: bad <input>\n"},
+		{"json", true, `{"level":"error","component":"query","msg":"bad <input>"}` + "\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			p := &Prog{logJSON: c.logJSON}
+			p.newLogger(&buf, "query").Printf("bad %s", "<input>")
+			if got := buf.String(); got != c.want {
+				t.Errorf("logged %q; want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestJSONLogWriterMultiline(t *testing.T) {
+	var buf bytes.Buffer
+	msg := "line 1\nline \"2\"\n"
+	n, err := jsonLogWriter{w: &buf, component: "x"}.Write([]byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	} else if n != len(msg) {
+		t.Errorf("Write = %d; want %d", n, len(msg))
+	}
+	if strings.Count(buf.String(), "\n") != 1 {
+		t.Fatalf("logged %q; want a single line", buf.String())
+	}
+	var got map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["msg"] != "line 1\nline \"2\"" {
+		t.Errorf("msg = %q; want %q", got["msg"], "line 1\nline \"2\"")
+	}
+}
+
+func TestLogJSON(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{name: "builtin", script: `event 'error("bad")'`, status: 1,
+			wantErr: `{"level":"error","component":"event","msg":"query error: error: bad"}` + "\n"},
+		{name: "invalid option", script: `query -bad`, status: 1,
+			wantErr: `{"level":"error","component":"query","msg":"flag provided but not defined: -bad"}`},
+		{name: "denied command", script: `ls`, status: 126,
+			wantErr: `{"level":"error","component":"ls","msg":`},
+	}, func(p *Prog) {
+		p.logJSON = true
+		p.noExec = true
+	})
+}
+
+func TestMainLogJSON(t *testing.T) {
+	status, logged := runMain(context.Background(), t, "-log-json", "-E", "missing.json", "-R", ":")
+	if status != 1 {
+		t.Errorf("status = %d; want 1", status)
+	}
+	var msg map[string]string
+	if err := json.Unmarshal([]byte(logged), &msg); err != nil {
+		t.Fatalf("logged %q: %v", logged, err)
+	}
+	if msg["level"] != "error" || msg["component"] != "sensu-sh" || !strings.Contains(msg["msg"], "missing.json") {
+		t.Errorf("logged %q; want an error from sensu-sh about missing.json", logged)
+	}
+}
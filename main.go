@@ -3,6 +3,9 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
@@ -11,9 +14,15 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/itchyny/gojq"
@@ -24,16 +33,59 @@ import (
 )
 
 func main() {
+	// Cancel the script on the first interrupt or termination signal, which
+	// also interrupts any external command. A second signal is not caught.
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		signal.Stop(sigs)
+		cancel()
+	}()
+
 	prog := &Prog{}
-	os.Exit(prog.Main(context.Background(), os.Args[1:]))
+	status := prog.Main(ctx, os.Args[1:])
+	cancel()
+	os.Exit(status)
 }
 
 type Prog struct {
 	event map[string]interface{}
+	// eventMu guards event once the script is running, since a pipeline's
+	// last stage may replace the event while its other stages read it.
+	eventMu sync.RWMutex
+
+	// failed is set if any check-line reported a failure.
+	failed bool
+	// failOnWarning escalates a warning exit status to critical.
+	failOnWarning bool
+	// execTimeout, if positive, limits how long external commands may run.
+	execTimeout time.Duration
+	// killTimeout is how long an interrupted external command has to exit
+	// before it is killed.
+	killTimeout time.Duration
+	// noExec disables external commands, including -filter-cmd commands.
+	noExec bool
+	// allowed, if not nil, holds the base names of the only external commands
+	// that may be run.
+	allowed map[string]bool
+	// root restricts the files that may be read to a directory.
+	root fsRoot
+	// httpTimeout limits how long fetching an event from a URL may take.
+	httpTimeout time.Duration
+	// logJSON writes log messages as lines of JSON.
+	logJSON bool
+	// eventFormat is the format of event files: auto, yaml, or msgpack.
+	eventFormat string
+	// noRCFile disables the rc files of every command's queries.
+	noRCFile bool
 
 	defaultExec interp.ExecHandlerFunc
 	defaultEnv  expand.Environ
 	runner      *interp.Runner
+	// shellMark is the element of shellMarkVar held by the top-level shell.
+	shellMark *string
 }
 
 func (p *Prog) Main(ctx context.Context, args []string) int {
@@ -42,13 +94,64 @@ func (p *Prog) Main(ctx context.Context, args []string) int {
 
 	flags := flag.NewFlagSet("sensu-sh", flag.ContinueOnError)
 	// -event FILE
-	eventFile := "-"
-	flags.StringVar(&eventFile, "E", eventFile, "The event file to expose to the script. (long: -event)")
-	flags.StringVar(&eventFile, "event", eventFile, "The event file to expose to the script. (short: -e)")
+	var eventFiles stringsFlag
+	flags.Var(&eventFiles, "E", "The event file to expose to the script. May be repeated to merge events. (long: -event)")
+	flags.Var(&eventFiles, "event", "The event file to expose to the script. May be repeated to merge events. (short: -e)")
 	// -raw
 	rawScript := false
 	flags.BoolVar(&rawScript, "R", rawScript, "Whether to treat all subsequent arguments as command strings. (long: -raw)")
 	flags.BoolVar(&rawScript, "raw", rawScript, "Whether to treat all subsequent arguments as command strings. (short: -r)")
+	// -strict-numbers
+	strictNumbers := false
+	flags.BoolVar(&strictNumbers, "strict-numbers", strictNumbers, "Decode the event as JSON, preserving the precision of numbers.")
+	// -event-format FORMAT
+	p.eventFormat = "auto"
+	flags.Var(eventFormatFlag{&p.eventFormat}, "event-format", "The `format` of event files: auto, yaml, or msgpack. Auto detects MessagePack and otherwise decodes YAML or JSON.")
+	// -args-file FILE
+	argsFile := ""
+	flags.StringVar(&argsFile, "args-file", argsFile, "A file of additional positional arguments to the script, one per line.")
+	// -fail-on-warning
+	flags.BoolVar(&p.failOnWarning, "fail-on-warning", p.failOnWarning, "Exit with a critical status instead of a warning status.")
+	// -exec-timeout DURATION
+	flags.DurationVar(&p.execTimeout, "t", p.execTimeout, "Kill external commands that run longer than `duration`. Zero disables the timeout. (long: -exec-timeout)")
+	flags.DurationVar(&p.execTimeout, "exec-timeout", p.execTimeout, "Kill external commands that run longer than `duration`. Zero disables the timeout. (short: -t)")
+	// -kill-timeout DURATION
+	p.killTimeout = 5 * time.Second
+	flags.DurationVar(&p.killTimeout, "kill-timeout", p.killTimeout, "Wait `duration` for an interrupted external command to exit before killing it. Zero kills it immediately.")
+	// -write-event FILE, -write-event-yaml
+	writeEvent, writeEventYAML := "", false
+	flags.StringVar(&writeEvent, "write-event", writeEvent, "Write the event to `file` as JSON if the script succeeds. Use - for standard output.")
+	flags.BoolVar(&writeEventYAML, "write-event-yaml", writeEventYAML, "Write the event as YAML with -write-event.")
+	// -validate-event
+	validate := false
+	flags.BoolVar(&validate, "validate-event", validate, "Fail if the event does not have the shape of a Sensu event.")
+	// -no-event-env
+	noEventEnv := false
+	flags.BoolVar(&noEventEnv, "no-event-env", noEventEnv, "Do not export event fields, such as SENSU_CHECK_NAME, as environment variables.")
+	// -deadline DURATION
+	deadline := time.Duration(0)
+	flags.DurationVar(&deadline, "deadline", deadline, "Stop the script if it runs longer than `duration`. Zero disables the deadline.")
+	// -http-timeout DURATION
+	p.httpTimeout = 30 * time.Second
+	flags.DurationVar(&p.httpTimeout, "http-timeout", p.httpTimeout, "Give up fetching an event from a URL after `duration`. Zero disables the timeout.")
+	// -no-exec
+	flags.BoolVar(&p.noExec, "no-exec", p.noExec, "Disable external commands, permitting only builtins.")
+	// -allow CMD, -allow-file FILE
+	var allow stringsFlag
+	flags.Var(&allow, "allow", "Permit the external `command` to run, disallowing any command not permitted. May be repeated.")
+	allowFile := ""
+	flags.StringVar(&allowFile, "allow-file", allowFile, "Permit the external commands listed in `file`, one per line, as -allow does.")
+	// -root DIR
+	rootDir := ""
+	flags.StringVar(&rootDir, "root", rootDir, "Restrict the files that the script and its commands may open to `dir`.")
+	// -log-json
+	flags.BoolVar(&p.logJSON, "log-json", p.logJSON, "Write log messages to standard error as lines of JSON.")
+	// -no-rcfile
+	flags.BoolVar(&p.noRCFile, "no-rcfile", p.noRCFile, "Do not prepend the jq definitions in "+rcFileName+" in $HOME and the working directory to any query.")
+	// -V, -version
+	showVersion := false
+	flags.BoolVar(&showVersion, "V", showVersion, "Print version information and exit. (long: -version)")
+	flags.BoolVar(&showVersion, "version", showVersion, "Print version information and exit. (short: -V)")
 
 	if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
 		return 2
@@ -57,6 +160,75 @@ func (p *Prog) Main(ctx context.Context, args []string) int {
 		return 1
 	}
 
+	if showVersion {
+		printVersion(os.Stdout)
+		return 0
+	}
+
+	if strictNumbers && p.eventFormat == "msgpack" {
+		log.Printf("-strict-numbers cannot be combined with -event-format msgpack")
+		return 1
+	}
+
+	if p.logJSON {
+		log.SetPrefix("")
+		log.SetOutput(jsonLogWriter{w: log.Writer(), component: "sensu-sh"})
+	}
+
+	eventSet, eventStdin := len(eventFiles) > 0, false
+	if !eventSet {
+		eventFiles = stringsFlag{"-"}
+	}
+	for _, path := range eventFiles {
+		if path == "-" && eventStdin {
+			log.Printf("the event can only be read from standard input once")
+			return 1
+		}
+		eventStdin = eventStdin || path == "-"
+	}
+
+	if p.execTimeout < 0 {
+		log.Printf("invalid exec timeout: %v", p.execTimeout)
+		return 1
+	} else if p.killTimeout < 0 {
+		log.Printf("invalid kill timeout: %v", p.killTimeout)
+		return 1
+	} else if p.httpTimeout < 0 {
+		log.Printf("invalid HTTP timeout: %v", p.httpTimeout)
+		return 1
+	} else if deadline < 0 {
+		log.Printf("invalid deadline: %v", deadline)
+		return 1
+	}
+
+	if rootDir != "" {
+		root, err := newFSRoot(rootDir)
+		if err != nil {
+			log.Printf("invalid root: %v", err)
+			return 1
+		}
+		p.root = root
+	}
+
+	if allowFile != "" {
+		cmds, err := readLines(p.root, allowFile)
+		if err != nil {
+			log.Printf("error reading allow file: %v", err)
+			return 1
+		}
+		for _, cmd := range cmds {
+			if cmd = strings.TrimSpace(cmd); cmd != "" && !strings.HasPrefix(cmd, "#") {
+				allow = append(allow, cmd)
+			}
+		}
+	}
+	if len(allow) > 0 || allowFile != "" {
+		p.allowed = map[string]bool{}
+		for _, cmd := range allow {
+			p.allowed[filepath.Base(cmd)] = true
+		}
+	}
+
 	if rawScript && flags.NArg() == 0 {
 		log.Printf("no commands given")
 		return 1
@@ -66,8 +238,8 @@ func (p *Prog) Main(ctx context.Context, args []string) int {
 	}
 
 	var (
-		prog   string
-		params interp.RunnerOption
+		prog      string
+		paramArgs []string
 	)
 
 	if rawScript {
@@ -79,22 +251,87 @@ func (p *Prog) Main(ctx context.Context, args []string) int {
 			}
 		}
 		prog = "#!sensu-sh\n" + strings.Join(srcArgs, "\n")
-		params = interp.Params(parArgs...)
+		paramArgs = parArgs
 	} else {
 		prog = flags.Arg(0)
-		params = interp.Params(flags.Args()[1:]...)
-		if prog == "-" && eventFile == "-" {
+		paramArgs = flags.Args()[1:]
+		if prog == "-" && eventStdin && !eventSet {
+			log.Printf("the script is read from standard input, so the event must be given with -event")
+			return 1
+		} else if prog == "-" && eventStdin {
 			log.Printf("both --event and program and stdin: only one can be read from standard input")
 			return 1
 		}
 	}
 
-	p.defaultExec = interp.DefaultExecHandler(time.Second * 5)
-	p.defaultEnv = expand.ListEnviron(os.Environ()...)
+	// Sensu pipes the event to standard input, so only refuse to read it from
+	// there if it was not requested and nothing can have been piped.
+	if eventStdin && !eventSet && isTerminal(os.Stdin) {
+		log.Printf("no event given: pipe the event to standard input or use -event")
+		return 1
+	}
+
+	if argsFile != "" {
+		if argsFile == "-" && (eventStdin || prog == "-") {
+			log.Printf("both --args-file and --event or program are stdin: only one can be read from standard input")
+			return 1
+		}
+		fileArgs, err := readLines(p.root, argsFile)
+		if err != nil {
+			log.Printf("error reading args file: %v", err)
+			return 1
+		}
+		if onlyShellOpts(paramArgs) {
+			// Ensure arguments from the file are never parsed as options.
+			paramArgs = append(paramArgs, "--")
+		}
+		paramArgs = append(paramArgs, fileArgs...)
+	}
+	params := interp.Params(paramArgs...)
+
+	// The deadline includes the time taken to fetch events.
+	if deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, deadline)
+		defer cancel()
+	}
+
+	// Later events are deep-merged over earlier ones.
+	for _, path := range eventFiles {
+		event, err := p.readEvent(ctx, path, strictNumbers)
+		if err != nil {
+			log.Printf("error reading event file: %v", err)
+			switch ctx.Err() {
+			case context.DeadlineExceeded:
+				return 124
+			case context.Canceled:
+				return 130
+			}
+			return 1
+		}
+		if p.event == nil {
+			p.event = event
+		} else if event != nil {
+			p.event = mergeObjects(p.event, event)
+		}
+	}
+	if validate {
+		if err := validateEvent(p.event); err != nil {
+			log.Printf("invalid event: %v", err)
+			return 1
+		}
+	}
+
+	environ := os.Environ()
+	if !noEventEnv {
+		environ = eventEnv(environ, p.event)
+	}
+
+	p.defaultExec = interp.DefaultExecHandler(p.killTimeout)
+	p.defaultEnv = expand.ListEnviron(environ...)
 	var err error
-	p.runner, err = interp.New(
+	p.runner, err = p.newRunner(
 		interp.StdIO(nullStream{}, os.Stdout, os.Stderr),
-		interp.ExecHandler(p.exec),
 		params,
 	)
 	if err != nil {
@@ -102,24 +339,75 @@ func (p *Prog) Main(ctx context.Context, args []string) int {
 		return 1
 	}
 
-	p.event, err = readEvent(eventFile)
+	script, err := readScript(p.root, prog)
 	if err != nil {
-		log.Printf("error reading event file: %v", err)
+		log.Printf("error reading script file: %v", err)
 		return 1
 	}
 
-	script, err := readScript(prog)
+	status := 0
+	err = p.runner.Run(ctx, script)
+	// A cancelled script may still exit normally, such as when a builtin
+	// reports the cancellation of its query as a failure.
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		log.Print("script deadline exceeded")
+		return 124
+	case context.Canceled:
+		log.Print("script interrupted")
+		return 130
+	}
 	if err != nil {
-		log.Printf("error reading script file: %v", err)
-		return 1
+		// Exit with the script's status so that builtins such as rollup and
+		// cert-expiry can report a check status.
+		s, ok := interp.IsExitStatus(err)
+		if !ok {
+			log.Printf("script error: %v", err)
+			return 1
+		}
+		status = int(s)
+	} else if p.failed {
+		status = statusCritical
 	}
 
-	if err := p.runner.Run(context.Background(), script); err != nil {
-		log.Printf("script error: %v", err)
-		return 1
+	if p.failOnWarning && status == statusWarning {
+		status = statusCritical
+	}
+
+	if writeEvent != "" && status == 0 {
+		if err := p.writeEvent(writeEvent, writeEventYAML); err != nil {
+			log.Printf("error writing event: %v", err)
+			return 1
+		}
+	}
+	return status
+}
+
+// writeEvent writes the event to the file at path, or to standard output if
+// path is "-", as JSON or YAML.
+func (p *Prog) writeEvent(path string, asYAML bool) error {
+	var buf bytes.Buffer
+	if asYAML {
+		enc := yaml.NewEncoder(&buf)
+		if err := enc.Encode(p.event); err != nil {
+			return err
+		}
+		if err := enc.Close(); err != nil {
+			return err
+		}
+	} else {
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(p.event); err != nil {
+			return err
+		}
 	}
 
-	return 0
+	if path == "-" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes(), 0644)
 }
 
 func (p *Prog) exec(ctx context.Context, args []string) error {
@@ -129,28 +417,136 @@ func (p *Prog) exec(ctx context.Context, args []string) error {
 		return p.filterJSON(ctx, nil, args)
 	case "event":
 		return p.filterEvent(ctx, args)
-	default: // @VAR [opt] [query]
+	case "bash-assoc":
+		return p.bashAssoc(ctx, args)
+	case "redact-secrets":
+		return p.redactSecrets(ctx, args)
+	case "assert-count":
+		return p.assertCount(ctx, args)
+	case "perfdata":
+		return p.perfdata(ctx, args)
+	case "moving-avg":
+		return p.movingAvg(ctx, args)
+	case "check-line":
+		return p.checkLine(ctx, args)
+	case "parse-kv":
+		return p.parseKV(ctx, args)
+	case "valid-email":
+		return p.validEmail(ctx, args)
+	case "cert-expiry":
+		return p.certExpiry(ctx, args)
+	case "html-table":
+		return p.htmlTable(ctx, args)
+	case "uuid":
+		return p.uuidCmd(ctx, args)
+	case "k8s-meta":
+		return p.k8sMeta(ctx, args)
+	case "reachable":
+		return p.reachable(ctx, args)
+	case "summarize":
+		return p.summarize(ctx, args)
+	case "rollup":
+		return p.rollup(ctx, args)
+	case "decide":
+		return p.decide(ctx, args)
+	case "cbor":
+		return p.cborCmd(ctx, args)
+	case "rename-keys":
+		return p.renameKeys(ctx, args)
+	case "drift":
+		return p.drift(ctx, args)
+	case "emit":
+		return p.emit(ctx, args)
+	case "metric":
+		return p.metric(ctx, args)
+	case "readfile":
+		return p.readFileCmd(ctx, args)
+	case "assign":
+		return p.assign(ctx, args)
+	case "env":
+		if !isEnvCommand(args) {
+			return p.envCmd(ctx, args)
+		}
+	default: // @VAR[[N]] [opt] [query], @VAR[[N]]= [opt] query
 		name := args[0]
 		if name == "@" || !strings.HasPrefix(args[0], "@") {
 			break
 		}
 
 		name = strings.TrimPrefix(name, "@")
+		name, set := strings.TrimSuffix(name, "="), strings.HasSuffix(name, "=")
+		index := ""
+		if i := strings.IndexByte(name, '['); i > 0 && strings.HasSuffix(name, "]") {
+			name, index = name[:i], name[i+1:len(name)-1]
+		}
 		h := interp.HandlerCtx(ctx)
 		v := h.Env.Get(name)
 		if v.Kind != expand.String && v.Kind != expand.Indexed {
 			break
 		}
-		return p.filterJSON(ctx, &name, append([]string{"query"}, args[1:]...))
+
+		// @VAR[*] is @VAR, whose elements are already queried separately.
+		elem := -1
+		if index != "" && index != "*" {
+			var err error
+			if elem, err = varElement(v, index); err != nil {
+				p.newLogger(h.Stderr, args[0]).Print(err)
+				return interp.NewExitStatus(1)
+			}
+		}
+		if set {
+			return p.setVar(ctx, name, elem, args)
+		}
+		source := name
+		if elem >= 0 {
+			source = name + "[" + strconv.Itoa(elem) + "]"
+		}
+		return p.filterJSON(ctx, &source, append([]string{"query"}, args[1:]...))
 	}
 
-	return p.defaultExec(ctx, args)
+	if err := p.checkExec(args[0]); err != nil {
+		h := interp.HandlerCtx(ctx)
+		p.newLogger(h.Stderr, args[0]).Print(err)
+		return interp.NewExitStatus(126)
+	}
+	return p.execTimed(ctx, args)
+}
+
+// checkExec returns an error if the external command name may not be run.
+// Disabling external commands with -no-exec takes precedence over -allow.
+func (p *Prog) checkExec(name string) error {
+	if p.noExec {
+		return errors.New("external commands disabled in sandbox mode")
+	}
+	if p.allowed != nil && !p.allowed[filepath.Base(name)] {
+		return errors.New("command not allowed")
+	}
+	return nil
+}
+
+// execTimed runs args as an external command, killing it if it runs for longer
+// than the exec timeout. A command that times out exits with status 124, as it
+// would under timeout(1).
+func (p *Prog) execTimed(ctx context.Context, args []string) error {
+	if p.execTimeout <= 0 {
+		return p.defaultExec(ctx, args)
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, p.execTimeout)
+	defer cancel()
+	err := p.defaultExec(tctx, args)
+	if ctx.Err() == nil && errors.Is(tctx.Err(), context.DeadlineExceeded) {
+		h := interp.HandlerCtx(ctx)
+		p.newLogger(h.Stderr, args[0]).Printf("timed out after %v", p.execTimeout)
+		return interp.NewExitStatus(124)
+	}
+	return err
 }
 
 func (p *Prog) filterJSON(ctx context.Context, forceVar *string, args []string) error {
 
 	h := interp.HandlerCtx(ctx)
-	logger := log.New(h.Stderr, "query: ", 0)
+	logger := p.newLogger(h.Stderr, "query")
 	f := flag.NewFlagSet("query", flag.ContinueOnError)
 	f.SetOutput(h.Stderr)
 
@@ -159,87 +555,290 @@ func (p *Prog) filterJSON(ctx context.Context, forceVar *string, args []string)
 	f.BoolVar(&rawInput, "R", rawInput, "Read raw input as a string. (long: -raw-input)")
 	f.BoolVar(&rawInput, "raw-input", rawInput, "Read raw input as a string. (short: -R)")
 
-	filter := &jsonFilter{logger: logger}
+	files := false
+	// -files
+	f.BoolVar(&files, "files", files, "Read input from the files named by the remaining arguments.")
+
+	countBy := ""
+	// -count-by
+	f.StringVar(&countBy, "count-by", countBy, "Print an object counting the values of `query` against each result instead of the results.")
+
+	arrayStream := false
+	// -array-stream
+	f.BoolVar(&arrayStream, "array-stream", arrayStream, "Query each element of top-level JSON arrays in the input without reading the whole array.")
+
+	stream := false
+	// -stream
+	f.BoolVar(&stream, "stream", stream, "Query the [path, leaf] events of jq's streaming form of each input instead of the input.")
+
+	filter := p.newJSONFilter(logger)
 	filter.bind(f)
+	// -strict-numbers
+	f.BoolVar(&filter.strictNumbers, "strict-numbers", filter.strictNumbers, "Decode input as JSON, preserving the precision of numbers.")
+	// -xml-input
+	f.BoolVar(&filter.xmlInput, "xml-input", filter.xmlInput, "Decode input as XML.")
+	// -cbor-input
+	f.BoolVar(&filter.cborInput, "cbor-input", filter.cborInput, "Decode input as a sequence of CBOR data items.")
+	defer filter.close()
 
-	if err := f.Parse(args[1:]); errors.Is(err, flag.ErrHelp) {
+	if err := f.Parse(pairArgs(f, args[1:])); errors.Is(err, flag.ErrHelp) {
 		return interp.NewExitStatus(2)
 	} else if err != nil {
 		logger.Print(err)
 		return interp.NewExitStatus(1)
 	}
 
-	args = f.Args()
-	if len(args) == 0 {
-		args = []string{"."}
+	if files && forceVar != nil {
+		logger.Printf("-files cannot be used when querying a variable")
+		return interp.NewExitStatus(1)
+	} else if arrayStream && rawInput {
+		logger.Printf("-array-stream cannot be combined with -raw-input")
+		return interp.NewExitStatus(1)
+	} else if stream && (rawInput || arrayStream) {
+		logger.Printf("-stream cannot be combined with -raw-input or -array-stream")
+		return interp.NewExitStatus(1)
+	} else if filter.xmlInput && (rawInput || arrayStream || filter.strictNumbers) {
+		logger.Printf("-xml-input cannot be combined with -raw-input, -array-stream, or -strict-numbers")
+		return interp.NewExitStatus(1)
+	} else if filter.cborInput && (rawInput || arrayStream || filter.strictNumbers || filter.xmlInput) {
+		logger.Printf("-cbor-input cannot be combined with -raw-input, -array-stream, -strict-numbers, or -xml-input")
+		return interp.NewExitStatus(1)
+	} else if countBy != "" && filter.splitBy != "" {
+		logger.Printf("-count-by cannot be combined with -split-by")
+		return interp.NewExitStatus(1)
+	}
+
+	// With -from-file, every argument is a source. Sources are read in order,
+	// and a document cannot span two sources.
+	queryStr, sources := ".", f.Args()
+	if filter.fromFile == "" && len(sources) > 0 {
+		var err error
+		if queryStr, sources, err = chainQueries(sources); err != nil {
+			logger.Print(err)
+			return interp.NewExitStatus(1)
+		}
 	}
 	if forceVar != nil {
-		args = append([]string(nil), args...)
-		args = append(args, *forceVar)
+		sources = append(sources[:len(sources):len(sources)], *forceVar)
 	}
 
-	queryStr := "."
-	source := "-"
-	switch len(args) {
-	case 2:
-		source = args[1]
-		fallthrough
-	case 1:
-		queryStr = args[0]
-	case 0:
-	default:
+	queryStr, err := filter.loadQuery(h, queryStr, false)
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	if len(sources) == 0 {
+		sources = []string{"-"}
+	} else if len(sources) > 1 && forceVar != nil {
 		logger.Printf("too many argument to query: expected 0..2")
 		return interp.NewExitStatus(1)
 	}
 
-	var r io.Reader = h.Stdin
-	if source != "-" {
-		str := ""
-		v := h.Env.Get(source)
-		switch v.Kind {
-		case expand.String:
-			str = v.Str
-		case expand.Indexed:
-			str = strings.Join(v.List, "\n")
-		default:
+	// Documents are decoded as they are needed, either by the loop over
+	// inputs below or by the query's input and inputs functions.
+	var inputs *inputIter
+	if !rawInput {
+		if !files {
+			sources = elementSources(h, sources)
 		}
-		r = strings.NewReader(str)
+		inputs = &inputIter{
+			sources: sources,
+			open: func(source string) (io.ReadCloser, error) {
+				return openSource(h, p.root, source, files)
+			},
+			decoder: func(r io.Reader) Decoder {
+				if arrayStream {
+					return newArrayStreamDecoder(r, filter.strictNumbers)
+				} else if stream {
+					return newStreamDecoder(filter.decoder(r))
+				}
+				return filter.decoder(r)
+			},
+		}
+		defer inputs.close()
+		filter.inputs = inputs
 	}
 
-	if rawInput {
-		data, err := ioutil.ReadAll(r)
+	query, err := filter.compile(ctx, queryStr)
+	if err != nil {
+		return err
+	}
+
+	var counter *countEncoder
+	if countBy != "" {
+		key, err := compileQuery(ctx, prependDefs(filter.prelude, countBy), filter.vars.names...)
 		if err != nil {
-			logger.Printf("error reading input: %v", err)
+			logger.Printf("count key: %v", err)
 			return interp.NewExitStatus(1)
 		}
-		return filter.run(ctx, queryStr, string(data))
+		// Results are counted by the encoder, and the tally is printed by
+		// finishCount once every input has been read.
+		counter = newCountEncoder(ctx, key, filter.vars.values)
+		filter.enc = counter
 	}
 
-	dec := yaml.NewDecoder(r)
-	for {
-		var input interface{}
-		if err := dec.Decode(&input); errors.Is(err, io.EOF) {
+	if filter.nullInput {
+		if err := filter.run(ctx, query, nil); err != nil {
+			return err
+		}
+		return filter.finishCount(ctx, counter)
+	}
+
+	if rawInput {
+		var data []byte
+		for _, source := range sources {
+			r, err := openSource(h, p.root, source, files)
+			if err != nil {
+				logger.Print(err)
+				return interp.NewExitStatus(1)
+			}
+			b, err := ioutil.ReadAll(r)
+			r.Close()
+			if err != nil {
+				logger.Printf("error reading input: %v", err)
+				return interp.NewExitStatus(1)
+			}
+			data = append(data, b...)
+		}
+		if err := filter.run(ctx, query, string(data)); err != nil {
+			return err
+		}
+		return filter.finishCount(ctx, counter)
+	}
+
+	handle := func(input interface{}) error {
+		return filter.run(ctx, query, input)
+	}
+	slurped := []interface{}{}
+	if filter.slurp {
+		handle = func(input interface{}) error {
+			slurped = append(slurped, input)
 			return nil
-		} else if err != nil {
-			logger.Printf("error decoding input: %v", err)
+		}
+	}
+
+	for {
+		input, ok := inputs.Next()
+		if !ok {
+			break
+		} else if err, ok := input.(error); ok {
+			logger.Print(err)
 			return interp.NewExitStatus(1)
 		}
-		if err := filter.run(ctx, queryStr, input); err != nil {
+		if err := handle(input); err != nil {
 			return err
 		}
 	}
+
+	if filter.slurp {
+		if err := filter.run(ctx, query, slurped); err != nil {
+			return err
+		}
+	}
+	return filter.finishCount(ctx, counter)
+}
+
+// openSource opens the named query source. If files is true, the source is
+// a path to a file, relative to the handler's working directory. Otherwise,
+// it is a source as understood by sourceReader. In either case, "-" is the
+// handler's standard input.
+func openSource(h interp.HandlerContext, root fsRoot, source string, files bool) (io.ReadCloser, error) {
+	if !files || source == "-" {
+		return ioutil.NopCloser(sourceReader(h, source)), nil
+	}
+	f, err := root.open(handlerPath(h, source))
+	if err != nil {
+		return nil, fmt.Errorf("error opening input: %w", err)
+	}
+	return f, nil
+}
+
+// sourceReader returns a reader for the named query source. The source "-" is
+// the handler's standard input; a source of the form NAME[N] is element N of
+// the variable NAME, where a string variable has a single element; and any
+// other source is the named variable, with the elements of indexed variables
+// separated by newlines.
+func sourceReader(h interp.HandlerContext, source string) io.Reader {
+	if source == "-" {
+		return h.Stdin
+	}
+	if name, i, ok := splitElementSource(source); ok {
+		switch v := h.Env.Get(name); {
+		case v.Kind == expand.Indexed && i < len(v.List):
+			return strings.NewReader(v.List[i])
+		case v.Kind == expand.String && i == 0:
+			return strings.NewReader(v.Str)
+		}
+		return strings.NewReader("")
+	}
+	str := ""
+	v := h.Env.Get(source)
+	switch v.Kind {
+	case expand.String:
+		str = v.Str
+	case expand.Indexed:
+		str = strings.Join(v.List, "\n")
+	default:
+	}
+	return strings.NewReader(str)
+}
+
+// elementSources returns sources with each indexed variable replaced by a
+// NAME[N] source for each of its elements, so that each element is decoded
+// separately.
+func elementSources(h interp.HandlerContext, sources []string) []string {
+	var expanded []string
+	for _, source := range sources {
+		v := h.Env.Get(source)
+		if source == "-" || v.Kind != expand.Indexed {
+			expanded = append(expanded, source)
+			continue
+		}
+		for i := range v.List {
+			expanded = append(expanded, source+"["+strconv.Itoa(i)+"]")
+		}
+	}
+	return expanded
+}
+
+// splitElementSource returns the variable name and index of a NAME[N] source.
+func splitElementSource(source string) (name string, i int, ok bool) {
+	open := strings.IndexByte(source, '[')
+	if open <= 0 || !strings.HasSuffix(source, "]") {
+		return "", 0, false
+	}
+	i, err := strconv.Atoi(source[open+1 : len(source)-1])
+	if err != nil || i < 0 {
+		return "", 0, false
+	}
+	return source[:open], i, true
 }
 
 func (p *Prog) filterEvent(ctx context.Context, args []string) error {
+	if len(args) > 1 && args[1] == "set" {
+		return p.setEvent(ctx, args[1:])
+	}
+
 	h := interp.HandlerCtx(ctx)
-	logger := log.New(h.Stderr, "event: ", 0)
+	logger := p.newLogger(h.Stderr, "event")
 	f := flag.NewFlagSet("event", flag.ContinueOnError)
 	f.SetOutput(h.Stderr)
 
-	filter := &jsonFilter{logger: logger}
+	merge, persist := false, false
+	// -merge-event
+	f.BoolVar(&merge, "merge-event", merge, "Deep-merge documents read from standard input into the event before querying it.")
+	// -persist
+	f.BoolVar(&persist, "persist", persist, "Keep the merged event for subsequent commands.")
+	// -base
+	base := ""
+	f.StringVar(&base, "base", base, "Run the query against the result of the `query` given.")
+
+	filter := p.newJSONFilter(logger)
 	filter.bind(f)
+	defer filter.close()
 
-	if err := f.Parse(args[1:]); errors.Is(err, flag.ErrHelp) {
+	if err := f.Parse(pairArgs(f, args[1:])); errors.Is(err, flag.ErrHelp) {
 		return interp.NewExitStatus(2)
 	} else if err != nil {
 		logger.Print(err)
@@ -247,25 +846,75 @@ func (p *Prog) filterEvent(ctx context.Context, args []string) error {
 	}
 
 	queryStr := "."
-	if f.NArg() == 1 {
-		queryStr = f.Arg(0)
-	} else if f.NArg() > 1 {
-		logger.Printf("too many arguments to event: expected 0..1")
+	if f.NArg() > 0 {
+		var rest []string
+		var err error
+		if queryStr, rest, err = chainQueries(f.Args()); err != nil {
+			logger.Print(err)
+			return interp.NewExitStatus(1)
+		} else if len(rest) > 0 {
+			logger.Printf("too many arguments to event: expected 0..1")
+			return interp.NewExitStatus(1)
+		}
+	}
+	queryStr, err := filter.loadQuery(h, queryStr, f.NArg() > 0)
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+	if base != "" {
+		queryStr = "(" + base + ") | (" + queryStr + ")"
+	}
+
+	event := p.currentEvent()
+	if merge {
+		docs, err := p.inputs(ctx, "-")
+		if err != nil {
+			logger.Print(err)
+			return interp.NewExitStatus(1)
+		}
+		for _, doc := range docs {
+			obj, ok := doc.(map[string]interface{})
+			if !ok {
+				logger.Printf("cannot merge non-object into event: %T", doc)
+				return interp.NewExitStatus(1)
+			}
+			event = mergeObjects(event, obj)
+		}
+		if persist {
+			if err := p.replaceEvent(h.Env, event); err != nil {
+				logger.Print(err)
+				return interp.NewExitStatus(1)
+			}
+		}
+	} else if persist {
+		logger.Printf("-persist requires -merge-event")
 		return interp.NewExitStatus(1)
 	}
 
-	return filter.run(ctx, queryStr, p.event)
+	query, err := filter.compile(ctx, queryStr)
+	if err != nil {
+		return err
+	}
+	var input interface{} = event
+	if filter.slurp {
+		input = []interface{}{event}
+	}
+	if err := filter.run(ctx, query, input); err != nil {
+		return err
+	}
+	return filter.finish(ctx)
 }
 
 var errIncomplete = errors.New("attempt to parse incomplete script")
 
-func readScript(path string) (*syntax.File, error) {
+func readScript(root fsRoot, path string) (*syntax.File, error) {
 	var f io.ReadCloser
 	if strings.HasPrefix(path, "#!sensu-sh\n") {
 		f = ioutil.NopCloser(strings.NewReader(path))
 	} else {
 		var err error
-		f, err = openFile(path)
+		f, err = openFile(root, path)
 		if err != nil {
 			return nil, fmt.Errorf("error opening script [%s]: %w", path, err)
 		}
@@ -283,87 +932,351 @@ func readScript(path string) (*syntax.File, error) {
 	return file, nil
 }
 
-func openFile(path string) (io.ReadCloser, error) {
+// openFile opens the file at path for reading if it is inside of root. The
+// path "-" is standard input.
+func openFile(root fsRoot, path string) (io.ReadCloser, error) {
 	if path == "-" {
 		return ioutil.NopCloser(os.Stdin), nil
 	}
-	return os.Open(path)
+	return root.open(path)
 }
 
-func readEvent(path string) (map[string]interface{}, error) {
-	var event map[string]interface{}
-	f, err := openFile(path)
+// openEvent opens the event at path. If path is of the form env:NAME, the
+// event is read from the environment variable NAME. If path is an HTTP or
+// HTTPS URL, the event is fetched from it, as long as ctx is not done, unless
+// external access is disabled by -no-exec or its scheme is not permitted by
+// -allow. Otherwise, path is a file.
+func (p *Prog) openEvent(ctx context.Context, path string) (io.ReadCloser, error) {
+	if name := strings.TrimPrefix(path, "env:"); name != path {
+		data, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("event variable %s is not set", name)
+		}
+		return ioutil.NopCloser(strings.NewReader(data)), nil
+	}
+
+	if scheme := strings.SplitN(path, "://", 2)[0]; scheme == "http" || scheme == "https" {
+		// Fetching an event is permitted as though it were an external
+		// command named by the URL's scheme, so -allow https permits it.
+		if p.noExec {
+			return nil, errors.New("fetching events disabled in sandbox mode")
+		} else if err := p.checkExec(scheme); err != nil {
+			return nil, fmt.Errorf("fetching events from %s URLs not allowed (use -allow %s)", scheme, scheme)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+		if err != nil {
+			return nil, err
+		}
+		client := &http.Client{Timeout: p.httpTimeout}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			resp.Body.Close()
+			return nil, fmt.Errorf("error fetching event [%s]: %s", path, resp.Status)
+		}
+		return resp.Body, nil
+	}
+
+	f, err := openFile(p.root, path)
 	if err != nil {
 		return nil, fmt.Errorf("error opening event [%s]: %w", path, err)
 	}
+	return f, nil
+}
+
+// readEvent reads the event at path, as opened by openEvent. The event is
+// decompressed if it is gzipped, and decoded according to p.eventFormat.
+func (p *Prog) readEvent(ctx context.Context, path string, strictNumbers bool) (map[string]interface{}, error) {
+	var event map[string]interface{}
+	f, err := p.openEvent(ctx, path)
+	if err != nil {
+		return nil, err
+	}
 	defer f.Close()
 
-	data, err := ioutil.ReadAll(f)
+	r, err := maybeGunzip(f)
 	if err != nil {
-		return nil, fmt.Errorf("error reading event [%s]: %w", path, err)
+		return nil, fmt.Errorf("error decompressing event [%s]: %w", path, err)
+	}
+
+	format := p.eventFormat
+	if format == "auto" {
+		br := bufio.NewReader(r)
+		format = "yaml"
+		if !strictNumbers && isMsgpackMap(br) {
+			format = "msgpack"
+		}
+		r = br
 	}
 
-	if err := yaml.Unmarshal(data, &event); err != nil {
-		return nil, fmt.Errorf("error parsing event [%s]: %w", path, err)
+	or := newOffsetReader(r)
+	var dec Decoder = newDecoder(or, strictNumbers)
+	if format == "msgpack" {
+		dec = newMsgpackDecoder(or)
+	}
+	if err := dec.Decode(&event); errors.Is(err, io.EOF) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error parsing event [%s]: %w", path, or.annotate(err))
 	}
 	return event, nil
 }
 
+// maybeGunzip returns a reader that decompresses r if it begins with the gzip
+// magic number, and otherwise returns the contents of r as-is.
+func maybeGunzip(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		return br, nil
+	}
+	return gzip.NewReader(br)
+}
+
+// readLines reads the lines of the file at path, such as a list of arguments.
+// Lines are taken verbatim, without their line endings.
+func readLines(root fsRoot, path string) ([]string, error) {
+	f, err := openFile(root, path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening [%s]: %w", path, err)
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading [%s]: %w", path, err)
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), nil
+}
+
+// onlyShellOpts returns whether interp.Params would treat every one of args as
+// a shell option, such that any argument added after them could be parsed as
+// an option as well.
+func onlyShellOpts(args []string) bool {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || arg == "" || (arg[0] != '-' && arg[0] != '+') {
+			return false
+		}
+		if arg[1:] == "o" {
+			i++ // Skip the option name
+		}
+	}
+	return true
+}
+
 type Encoder interface {
 	Encode(interface{}) error
 }
 
+type Decoder interface {
+	Decode(interface{}) error
+}
+
+// newDecoder returns a decoder for a stream of documents read from r. If
+// strictNumbers is true, the documents must be JSON, and numbers are decoded
+// as json.Numbers to preserve their precision. Otherwise, the documents may be
+// JSON or YAML, and non-string keys of YAML mappings are converted to strings.
+func newDecoder(r io.Reader, strictNumbers bool) Decoder {
+	if strictNumbers {
+		dec := json.NewDecoder(r)
+		dec.UseNumber()
+		return dec
+	}
+	return newYAMLDecoder(r)
+}
+
 // plainEncoder is an encoder that writes string values values as raw strings to
 // its output. All other values are formatted in some way. In particular, maps
-// and slices are always encoded as compact JSON.
+// and slices are always encoded as compact JSON. Values are separated by sep,
+// which is a newline unless changed.
 type plainEncoder struct {
 	w       io.Writer
+	sep     string
 	written bool
 }
 
 func newPlainEncoder(w io.Writer) *plainEncoder {
-	return &plainEncoder{w: w}
+	return &plainEncoder{w: w, sep: "\n"}
 }
 
 func (p *plainEncoder) Encode(val interface{}) error {
-	var str string
-
-	if p.written {
-		if _, err := io.WriteString(p.w, "\n"); err != nil {
+	if p.written && p.sep != "" {
+		if _, err := io.WriteString(p.w, p.sep); err != nil {
 			return err
 		}
 	}
 	p.written = true
 
+	str, err := plainString(val)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(p.w, str); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// plainString formats val the way plainEncoder writes it: strings are returned
+// as-is, null is an empty string, numbers are formatted without exponents, and
+// maps and slices are encoded as compact JSON.
+func plainString(val interface{}) (string, error) {
 	switch val := val.(type) {
+	case nil:
+		return "", nil
 	case map[string]interface{}, []interface{}:
 		p, err := json.Marshal(val)
 		if err != nil {
-			return err
+			return "", err
 		}
-		str = string(p)
+		return string(p), nil
 	case string:
-		str = val
+		return val, nil
 	case float64:
-		str = strconv.FormatFloat(val, 'f', -1, 64)
+		return strconv.FormatFloat(val, 'f', -1, 64), nil
+	case json.Number:
+		return val.String(), nil
 	default:
-		str = fmt.Sprint(val)
+		return fmt.Sprint(val), nil
 	}
+}
 
-	if _, err := io.WriteString(p.w, str); err != nil {
+// yamlFlowEncoder is a YAML encoder that switches to flow style for any
+// mapping or sequence nested at or below a given depth.
+type yamlFlowEncoder struct {
+	enc   *yaml.Encoder
+	depth int
+}
+
+func (y *yamlFlowEncoder) Encode(val interface{}) error {
+	var node yaml.Node
+	if err := node.Encode(val); err != nil {
 		return err
 	}
+	y.setStyle(&node, 0)
+	return y.enc.Encode(&node)
+}
 
-	return nil
+func (y *yamlFlowEncoder) setStyle(node *yaml.Node, depth int) {
+	if depth >= y.depth && (node.Kind == yaml.MappingNode || node.Kind == yaml.SequenceNode) {
+		node.Style |= yaml.FlowStyle
+	}
+	for _, child := range node.Content {
+		y.setStyle(child, depth+1)
+	}
 }
 
 type jsonFilter struct {
-	pretty bool
-	json   bool
-	yaml   bool
+	pretty   bool
+	json     bool
+	yaml     bool
+	toml     bool
+	csv      bool
+	tsv      bool
+	ndjson   bool
+	prom     bool
+	graphite bool
+	xml      bool
+	cbor     bool
+
+	explode    string
+	fromFile   string
+	nullInput  bool
+	slurp      bool
+	exitStatus bool
+	filterCmd  string
+	splitBy    string
+	splitDir   string
+	defs       string
+	noRCFile   bool
+	libDirs    stringsFlag
+	vars       queryVars
+	yamlFlow   int
+	xmlRoot    string
+	xmlItem    string
+	indent     int
+	tab        bool
+	ascii      bool
+	seq        bool
+	color      string
+	rawOutput0 bool
+	join       bool
+	printEmpty bool
+	output     string
+	append     bool
+	gzip       bool
+	bufferSize int
+	unbuffered bool
+
+	decodeTimestamps bool
+	tsFormat         string
+
+	// colorize is whether JSON output is colorized, as decided by
+	// openOutput from -color and the output.
+	colorize bool
+
+	// strictNumbers, xmlInput, and cborInput are set by commands that decode
+	// input for the filter.
+	strictNumbers bool
+	xmlInput      bool
+	cborInput     bool
+	// inputs, if not nil, is the iterator read by the query's input and
+	// inputs functions.
+	inputs gojq.Iter
+	// prelude holds the definitions prepended to the query by compile, from
+	// the rc files and -defs, which are also prepended to the -count-by and
+	// -split-by queries.
+	prelude string
+
+	// transforms are applied, in order, to each query result before it is
+	// passed to the filter command, if any, and encoded.
+	transforms []func(interface{}) (interface{}, error)
+
+	// out and enc are the writer and encoder for all results of the query.
+	// They are created by the first call to run.
+	out     io.Writer
+	enc     Encoder
+	closers []io.Closer
+	// results is the number of results encoded so far, and last is the most
+	// recent of them.
+	results int
+	last    interface{}
 
 	logger *log.Logger
 	runner *interp.Runner
+	// checkExec, if not nil, is called with the name of the filter command
+	// before it is run and returns an error if it may not be run.
+	checkExec func(name string) error
+	// execTimeout, if positive, limits how long the filter command may run.
+	execTimeout time.Duration
+	// root restricts the files that may be read, such as by -from-file.
+	root fsRoot
+}
+
+// newJSONFilter returns a jsonFilter that logs to logger and runs filter
+// commands only if p permits them.
+func (p *Prog) newJSONFilter(logger *log.Logger) *jsonFilter {
+	return &jsonFilter{
+		checkExec:   p.checkExec,
+		execTimeout: p.execTimeout,
+		root:        p.root,
+		noRCFile:    p.noRCFile,
+		logger:      logger,
+		yamlFlow:    -1,
+		indent:      -1,
+		xmlRoot:     "root",
+		xmlItem:     "item",
+		color:       "auto",
+		bufferSize:  64 * 1024,
+		tsFormat:    time.RFC3339,
+	}
 }
 
 // bind attaches jsonFilter's options to a FlagSet.
@@ -374,38 +1287,345 @@ func (j *jsonFilter) bind(f *flag.FlagSet) {
 	// -Y, -yaml
 	f.BoolVar(&j.yaml, "Y", j.yaml, "Output YAML instead of JSON or text. (long: -yaml)")
 	f.BoolVar(&j.yaml, "yaml", j.yaml, "Output YAML instead of JSON or text. (short: -Y)")
+	// -f, -from-file
+	f.StringVar(&j.fromFile, "f", j.fromFile, "Read the query from `file` instead of an argument. (long: -from-file)")
+	f.StringVar(&j.fromFile, "from-file", j.fromFile, "Read the query from `file` instead of an argument. (short: -f)")
+	// -n, -null-input
+	f.BoolVar(&j.nullInput, "n", j.nullInput, "Run the query once with null as its input. (long: -null-input)")
+	f.BoolVar(&j.nullInput, "null-input", j.nullInput, "Run the query once with null as its input. (short: -n)")
+	// -e, -exit-status
+	// Commands that define their own -e only get -exit-status.
+	if f.Lookup("e") == nil {
+		f.BoolVar(&j.exitStatus, "e", j.exitStatus, "Exit with status 1 if the last result is false or null, or 4 if there are no results. (long: -exit-status)")
+	}
+	f.BoolVar(&j.exitStatus, "exit-status", j.exitStatus, "Exit with status 1 if the last result is false or null, or 4 if there are no results. (short: -e)")
+	// -s, -slurp
+	// Commands that define their own -s only get -slurp.
+	if f.Lookup("s") == nil {
+		f.BoolVar(&j.slurp, "s", j.slurp, "Run the query once with an array of all inputs as its input. (long: -slurp)")
+	}
+	f.BoolVar(&j.slurp, "slurp", j.slurp, "Run the query once with an array of all inputs as its input. (short: -s)")
+	// -T, -toml
+	f.BoolVar(&j.toml, "T", j.toml, "Output TOML. Results must be objects. (long: -toml)")
+	f.BoolVar(&j.toml, "toml", j.toml, "Output TOML. Results must be objects. (short: -T)")
+	// -ndjson
+	f.BoolVar(&j.ndjson, "ndjson", j.ndjson, "Output each result as compact JSON on its own line.")
+	// -prom
+	f.BoolVar(&j.prom, "prom", j.prom, "Output metric objects in the Prometheus text format.")
+	// -graphite
+	f.BoolVar(&j.graphite, "graphite", j.graphite, "Output metric objects in the Graphite plaintext format.")
+	// -xml, -xml-root, -xml-item
+	f.BoolVar(&j.xml, "xml", j.xml, "Output XML.")
+	f.StringVar(&j.xmlRoot, "xml-root", j.xmlRoot, "Name the root element of XML output `name` unless the result is an object with one key.")
+	f.StringVar(&j.xmlItem, "xml-item", j.xmlItem, "Name the elements of arrays in XML output `name`.")
+	// -cbor
+	f.BoolVar(&j.cbor, "cbor", j.cbor, "Output each result as a CBOR data item.")
+	// -a, -ascii-output
+	f.BoolVar(&j.ascii, "a", j.ascii, "Escape non-ASCII characters in JSON output. (long: -ascii-output)")
+	f.BoolVar(&j.ascii, "ascii-output", j.ascii, "Escape non-ASCII characters in JSON output. (short: -a)")
+	// -seq
+	f.BoolVar(&j.seq, "seq", j.seq, "Write JSON output as an RFC 7464 JSON text sequence. Implies -json unless -ndjson is given.")
+	// -color
+	f.Var(colorFlag{&j.color}, "color", "Colorize JSON output: `when` is auto, always, or never. Auto colorizes output to a terminal unless NO_COLOR is set.")
+	// -0, -raw-output0
+	f.BoolVar(&j.rawOutput0, "0", j.rawOutput0, "Separate plain output with NUL bytes instead of newlines. (long: -raw-output0)")
+	f.BoolVar(&j.rawOutput0, "raw-output0", j.rawOutput0, "Separate plain output with NUL bytes instead of newlines. (short: -0)")
+	// -join
+	f.BoolVar(&j.join, "join", j.join, "Write plain output without separating newlines.")
+	// -csv, -tsv
+	f.BoolVar(&j.csv, "csv", j.csv, "Output arrays as CSV records.")
+	f.BoolVar(&j.tsv, "tsv", j.tsv, "Output arrays as tab-separated records.")
+	// -S, -sort-keys
+	//
+	// Objects are decoded into maps, which every encoder writes in sorted key
+	// order, so this is accepted only for compatibility with jq.
+	var sortKeys bool
+	f.BoolVar(&sortKeys, "S", sortKeys, "Sort object keys. Keys are always sorted. (long: -sort-keys)")
+	f.BoolVar(&sortKeys, "sort-keys", sortKeys, "Sort object keys. Keys are always sorted. (short: -S)")
 	// -p, -pretty
 	f.BoolVar(&j.pretty, "p", j.pretty, "Pretty-print JSON. (long: -pretty)")
 	f.BoolVar(&j.pretty, "pretty", j.pretty, "Pretty-print JSON. (short: -p)")
+	// -indent
+	f.Var(indentFlag{&j.indent}, "indent", "Indent JSON, YAML, and XML output by `n` spaces. Implies -pretty.")
+	// -tab
+	f.BoolVar(&j.tab, "tab", j.tab, "Indent JSON output with a tab for each level. Implies -pretty.")
+	// -yaml-flow
+	f.IntVar(&j.yamlFlow, "yaml-flow", j.yamlFlow, "Use flow style for YAML collections nested at least `depth` levels deep.")
+	// -o, -output
+	f.StringVar(&j.output, "o", j.output, "Write output to `file` instead of standard output. (long: -output)")
+	f.StringVar(&j.output, "output", j.output, "Write output to `file` instead of standard output. (short: -o)")
+	// -append
+	f.BoolVar(&j.append, "append", j.append, "Append to the -output file, or to -split-by files, instead of truncating it.")
+	// -gzip
+	f.BoolVar(&j.gzip, "gzip", j.gzip, "Compress output with gzip.")
+	// -buffer-size
+	f.IntVar(&j.bufferSize, "buffer-size", j.bufferSize, "Buffer up to `n` bytes of output between writes. Zero disables buffering.")
+	// -unbuffered
+	f.BoolVar(&j.unbuffered, "unbuffered", j.unbuffered, "Flush output after each result.")
+	// -print-empty
+	f.BoolVar(&j.printEmpty, "print-empty", j.printEmpty, "Print an empty line if the query produces no results.")
+	// -decode-timestamps
+	f.BoolVar(&j.decodeTimestamps, "decode-timestamps", j.decodeTimestamps, "Format numeric fields named *_at, *_time, or *timestamp as times.")
+	// -ts-format
+	f.StringVar(&j.tsFormat, "ts-format", j.tsFormat, "Go time `layout` used by -decode-timestamps.")
+	// -filter-cmd
+	f.StringVar(&j.filterCmd, "filter-cmd", j.filterCmd, "Replace each result with the JSON output of `command` given the result as input.")
+	// -split-by, -split-dir
+	f.StringVar(&j.splitBy, "split-by", j.splitBy, "Write each result as JSON to a file in -split-dir named by the result of `query` against it.")
+	f.StringVar(&j.splitDir, "split-dir", j.splitDir, "Directory to write -split-by files to.")
+	// -arg
+	f.Var(argFlag{&j.vars}, "arg", "Bind the query variable $`name` to the string value given by the following argument.")
+	// -argjson
+	f.Var(argJSONFlag{&j.vars}, "argjson", "Bind the query variable $`name` to the JSON value given by the following argument.")
+	// -slurpfile
+	f.Var(slurpFileFlag{&j.vars}, "slurpfile", "Bind the query variable $`name` to an array of the JSON or YAML documents in the file given by the following argument.")
+	// -rawfile
+	f.Var(rawFileFlag{&j.vars}, "rawfile", "Bind the query variable $`name` to the contents of the file given by the following argument.")
+	// -L
+	f.Var(&j.libDirs, "L", "Search `dir` for modules imported or included by the query. May be repeated.")
+	// -defs
+	f.StringVar(&j.defs, "defs", j.defs, "Prepend the jq definitions in `file` to the query.")
+	// -no-rcfile
+	f.BoolVar(&j.noRCFile, "no-rcfile", j.noRCFile, "Do not prepend the jq definitions in "+rcFileName+" in $HOME and the working directory to the query.")
+	// -explode
+	f.StringVar(&j.explode, "explode", j.explode, "Query each copy of the input with the array at `path` replaced by one of its elements.")
 }
 
 // encoder returns an encoder configured for use by the receiver.
 func (j *jsonFilter) encoder(w io.Writer) Encoder {
+	if j.seq && (j.json || j.ndjson) {
+		w = seqWriter{w}
+	}
+	if j.ascii && (j.json || j.ndjson) {
+		w = asciiWriter{w}
+	}
+	if j.colorize && (j.json || j.ndjson) {
+		w = colorWriter{w}
+	}
+
 	if j.json {
 		enc := json.NewEncoder(w)
 		enc.SetEscapeHTML(false)
-		if j.pretty {
+		if j.tab {
+			enc.SetIndent("", "\t")
+		} else if j.indent > 0 {
+			enc.SetIndent("", strings.Repeat(" ", j.indent))
+		} else if j.pretty && j.indent == -1 {
 			enc.SetIndent("", "  ")
 		}
 		return enc
 	} else if j.yaml {
-		return yaml.NewEncoder(w)
+		enc := yaml.NewEncoder(w)
+		if j.indent > 0 {
+			enc.SetIndent(j.indent)
+		}
+		if j.yamlFlow >= 0 {
+			return &yamlFlowEncoder{enc: enc, depth: j.yamlFlow}
+		}
+		return enc
+	} else if j.ndjson {
+		// Each value is written as compact JSON followed by a newline,
+		// regardless of -pretty.
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		return enc
+	} else if j.toml {
+		return newTOMLEncoder(w)
+	} else if j.prom {
+		return newPromEncoder(w)
+	} else if j.graphite {
+		return newGraphiteEncoder(w)
+	} else if j.xml {
+		indent := ""
+		if j.indent > 0 {
+			indent = strings.Repeat(" ", j.indent)
+		}
+		return newXMLEncoder(w, j.xmlRoot, j.xmlItem, indent)
+	} else if j.cbor {
+		return newCBOREncoder(w)
+	} else if j.csv {
+		return newCSVEncoder(w, ',')
+	} else if j.tsv {
+		return newCSVEncoder(w, '\t')
+	}
+	enc := newPlainEncoder(w)
+	if j.rawOutput0 {
+		enc.sep = "\x00"
+	} else if j.join {
+		enc.sep = ""
 	}
-	return newPlainEncoder(w)
+	return enc
 }
 
-func (j *jsonFilter) run(ctx context.Context, queryStr string, input interface{}) error {
-	h := interp.HandlerCtx(ctx)
+// formats returns the flags of the output formats selected, other than plain
+// output.
+func (j *jsonFilter) formats() []string {
+	var names []string
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{
+		{j.json, "-json"},
+		{j.yaml, "-yaml"},
+		{j.toml, "-toml"},
+		{j.ndjson, "-ndjson"},
+		{j.prom, "-prom"},
+		{j.graphite, "-graphite"},
+		{j.xml, "-xml"},
+		{j.cbor, "-cbor"},
+		{j.csv, "-csv"},
+		{j.tsv, "-tsv"},
+	} {
+		if f.set {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// indentFlag is a flag.Value for -indent, which must not be negative.
+type indentFlag struct {
+	n *int
+}
 
-	query, err := gojq.Parse(queryStr)
+func (i indentFlag) String() string {
+	if i.n == nil || *i.n < 0 {
+		return ""
+	}
+	return strconv.Itoa(*i.n)
+}
+
+func (i indentFlag) Set(v string) error {
+	n, err := strconv.Atoi(v)
 	if err != nil {
-		j.logger.Printf("unable to parse query: %v", err)
-		return interp.NewExitStatus(1)
+		return err
+	} else if n < 0 {
+		return errors.New("indent must not be negative")
+	}
+	*i.n = n
+	return nil
+}
+
+// decoder returns a Decoder for the documents read from r: XML documents if
+// the receiver's xmlInput is set, CBOR data items if its cborInput is set, and
+// otherwise JSON or YAML documents.
+func (j *jsonFilter) decoder(r io.Reader) Decoder {
+	if j.xmlInput {
+		return newXMLDecoder(r)
+	} else if j.cborInput {
+		return newCBORDecoder(r)
+	}
+	return newDecoder(r, j.strictNumbers)
+}
+
+// loadQuery returns the query read from the receiver's -from-file file, or
+// queryStr if -from-file was not given. hasArg reports whether queryStr was
+// given as an argument, which conflicts with -from-file. The file "-" is the
+// handler's standard input.
+func (j *jsonFilter) loadQuery(h interp.HandlerContext, queryStr string, hasArg bool) (string, error) {
+	if j.fromFile == "" {
+		return queryStr, nil
+	} else if hasArg {
+		return "", errors.New("cannot use both -from-file and a query argument")
+	}
+
+	r := ioutil.NopCloser(h.Stdin)
+	if j.fromFile != "-" {
+		f, err := openFile(j.root, handlerPath(h, j.fromFile))
+		if err != nil {
+			return "", fmt.Errorf("error opening query file: %w", err)
+		}
+		r = f
+	}
+	defer r.Close()
+
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("error reading query file: %w", err)
+	}
+	return string(p), nil
+}
+
+// compile compiles queryStr for use with run, applying the receiver's -explode
+// and -defs options and any rc files. Errors are logged and returned as an
+// exit status.
+func (j *jsonFilter) compile(ctx context.Context, queryStr string) (*gojq.Code, error) {
+	if j.explode != "" {
+		// Produce one copy of the input per element of the exploded array
+		// and run the query against each copy in turn.
+		queryStr = fmt.Sprintf("(%s)[] as $__explode | (%s) = $__explode | (%s)", j.explode, j.explode, queryStr)
 	}
 
-	enc := j.encoder(h.Stdout)
+	defs, err := j.loadRCFiles(interp.HandlerCtx(ctx))
+	if err != nil {
+		j.logger.Print(err)
+		return nil, interp.NewExitStatus(1)
+	}
+	if j.defs != "" {
+		fileDefs, err := j.loadDefs(interp.HandlerCtx(ctx))
+		if err != nil {
+			j.logger.Print(err)
+			return nil, interp.NewExitStatus(1)
+		}
+		defs += fileDefs
+	}
+	j.prelude = defs
+	if j.inputs != nil {
+		defs = inputsDef + "\n" + defs
+	}
+	queryStr = prependDefs(defs, queryStr)
+
+	if err := j.loadVarFiles(interp.HandlerCtx(ctx)); err != nil {
+		j.logger.Print(err)
+		return nil, interp.NewExitStatus(1)
+	}
+
+	var opts []gojq.CompilerOption
+	if j.inputs != nil {
+		opts = append(opts, gojq.WithInputIter(queryInputs{j.inputs}))
+	}
+	if len(j.libDirs) > 0 {
+		h := interp.HandlerCtx(ctx)
+		dirs := make([]string, len(j.libDirs))
+		for i, dir := range j.libDirs {
+			dirs[i] = handlerPath(h, dir)
+		}
+		opts = append(opts, gojq.WithModuleLoader(&moduleLoader{dirs: dirs, root: j.root}))
+	}
+
+	query, err := compileQueryWith(ctx, queryStr, j.vars.names, opts...)
+	if err != nil {
+		j.logger.Print(err)
+		return nil, interp.NewExitStatus(1)
+	}
+	return query, nil
+}
+
+// run runs a query compiled by compile against input and writes its results.
+func (j *jsonFilter) run(ctx context.Context, query *gojq.Code, input interface{}) error {
+	h := interp.HandlerCtx(ctx)
+
+	if j.nullInput {
+		input = nil
+	}
+
+	if j.enc == nil && j.splitBy != "" {
+		enc, err := j.openSplit(ctx)
+		if err != nil {
+			j.logger.Print(err)
+			return interp.NewExitStatus(1)
+		}
+		j.enc = enc
+	} else if j.enc == nil {
+		w, err := j.openOutput(h)
+		if err != nil {
+			j.logger.Print(err)
+			return interp.NewExitStatus(1)
+		}
+		j.enc = j.encoder(w)
+	}
 
-	iter := query.Run(input)
+	iter := runQuery(ctx, query, input, j.vars.values...)
 	for i := 0; ; i++ {
 		val, ok := iter.Next()
 		if !ok {
@@ -416,15 +1636,367 @@ func (j *jsonFilter) run(ctx context.Context, queryStr string, input interface{}
 			return interp.NewExitStatus(1)
 		}
 
-		if err := enc.Encode(val); err != nil {
+		for _, transform := range j.transforms {
+			var err error
+			if val, err = transform(val); err != nil {
+				j.logger.Printf("transform error: %v", err)
+				return interp.NewExitStatus(1)
+			}
+		}
+
+		vals := []interface{}{val}
+		if j.filterCmd != "" {
+			var err error
+			if vals, err = j.runFilterCmd(ctx, val); err != nil {
+				j.logger.Printf("filter command error: %v", err)
+				return interp.NewExitStatus(1)
+			}
+		}
+
+		for _, val := range vals {
+			if err := j.emit(val); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadDefs returns the contents of the receiver's defs file. The definitions
+// are parsed on their own so that errors in them are not reported as errors in
+// the query.
+func (j *jsonFilter) loadDefs(h interp.HandlerContext) (string, error) {
+	path := handlerPath(h, j.defs)
+	p, err := j.root.readFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading definitions: %w", err)
+	}
+	defs := string(p)
+	if _, err := gojq.Parse(defs + "\n."); err != nil {
+		return "", fmt.Errorf("unable to parse definitions [%s]: %w", path, err)
+	}
+	return defs, nil
+}
+
+// loadVarFiles reads the values of the query variables bound to files, such as
+// by -slurpfile and -rawfile. Paths are relative to the handler's working directory, and
+// "-" is the handler's standard input.
+func (j *jsonFilter) loadVarFiles(h interp.HandlerContext) error {
+	for _, vf := range j.vars.files {
+		name := j.vars.names[vf.i]
+		r, err := openSource(h, j.root, vf.path, true)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if vf.raw {
+			p, err := ioutil.ReadAll(r)
+			r.Close()
+			if err != nil {
+				return fmt.Errorf("%s: error reading [%s]: %w", name, vf.path, err)
+			}
+			j.vars.values[vf.i] = string(p)
+			continue
+		}
+		or := newOffsetReader(r)
+		dec := newDecoder(or, false)
+		docs := []interface{}{}
+		for {
+			var doc interface{}
+			if err = dec.Decode(&doc); err != nil {
+				break
+			}
+			docs = append(docs, doc)
+		}
+		r.Close()
+		if !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: error decoding [%s]: %w", name, vf.path, or.annotate(err))
+		}
+		j.vars.values[vf.i] = docs
+	}
+	j.vars.files = nil
+	return nil
+}
+
+// emit encodes a single result.
+func (j *jsonFilter) emit(val interface{}) error {
+	if j.decodeTimestamps {
+		val = decodeTimestamps(val, j.tsFormat)
+	}
+
+	if err := j.enc.Encode(val); err != nil {
+		j.logger.Printf("encoding error: %v", err)
+		return interp.NewExitStatus(1)
+	}
+	j.results++
+	j.last = val
+
+	if j.unbuffered {
+		if err := j.flush(); err != nil {
+			j.logger.Printf("error writing output: %v", err)
+			return interp.NewExitStatus(1)
+		}
+	}
+	return nil
+}
+
+// openOutput returns the writer that results are encoded to, opening it if
+// necessary. Closers for the writer are added to the receiver's closers.
+func (j *jsonFilter) openOutput(h interp.HandlerContext) (io.Writer, error) {
+	if j.out != nil {
+		return j.out, nil
+	}
+	if formats := j.formats(); len(formats) > 1 {
+		return nil, fmt.Errorf("%s and %s are mutually exclusive", formats[0], formats[1])
+	} else if len(formats) == 1 && formats[0] != "-json" {
+		switch {
+		case j.pretty && formats[0] != "-yaml":
+			// YAML output is always indented, so -pretty is accepted and
+			// has no effect on it.
+			return nil, fmt.Errorf("-pretty cannot be combined with %s", formats[0])
+		case j.tab:
+			return nil, fmt.Errorf("-tab cannot be combined with %s", formats[0])
+		case j.indent >= 0 && formats[0] != "-yaml" && formats[0] != "-xml":
+			return nil, fmt.Errorf("-indent cannot be combined with %s", formats[0])
+		}
+	}
+	if j.tab && j.indent >= 0 {
+		return nil, errors.New("-tab and -indent are mutually exclusive")
+	}
+	if j.seq {
+		formats := j.formats()
+		switch {
+		case len(formats) > 0 && formats[0] != "-json" && formats[0] != "-ndjson":
+			return nil, fmt.Errorf("-seq cannot be combined with %s", formats[0])
+		case j.rawOutput0 || j.join:
+			return nil, errors.New("-seq cannot be combined with plain output options")
+		case len(formats) == 0:
+			j.json = true
+		}
+	}
+	for _, plain := range []struct {
+		set  bool
+		name string
+	}{
+		{j.rawOutput0, "-raw-output0"},
+		{j.join, "-join"},
+	} {
+		if !plain.set {
+			continue
+		} else if formats := j.formats(); len(formats) > 0 {
+			return nil, fmt.Errorf("%s cannot be combined with %s", plain.name, formats[0])
+		} else if j.pretty || j.tab || j.indent >= 0 {
+			return nil, fmt.Errorf("%s cannot be combined with pretty output", plain.name)
+		}
+	}
+	if j.rawOutput0 && j.join {
+		return nil, errors.New("-raw-output0 and -join are mutually exclusive")
+	}
+
+	if j.append && (j.output == "" || j.output == "-") {
+		return nil, errors.New("-append requires -output")
+	}
+
+	w := h.Stdout
+	switch j.color {
+	case "always":
+		j.colorize = true
+	case "auto":
+		j.colorize = (j.output == "" || j.output == "-") && !j.gzip && autoColor(w, h.Env)
+	}
+	if j.output != "" && j.output != "-" {
+		path := handlerPath(h, j.output)
+		flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if j.append {
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		resolved, err := j.root.resolve(path)
+		if err != nil {
+			return nil, fmt.Errorf("error opening output [%s]: %w", path, err)
+		}
+		f, err := os.OpenFile(resolved, flag, 0666)
+		if err != nil {
+			return nil, fmt.Errorf("error opening output [%s]: %w", path, err)
+		}
+		j.closers = append(j.closers, f)
+		w = f
+	} else if j.gzip && isTerminal(w) {
+		return nil, errors.New("refusing to write gzip output to a terminal")
+	} else if j.cbor && isTerminal(w) {
+		return nil, errors.New("refusing to write CBOR output to a terminal")
+	}
+
+	if j.gzip {
+		zw := gzip.NewWriter(w)
+		j.closers = append(j.closers, zw)
+		w = zw
+	}
+
+	if j.bufferSize > 0 {
+		bw := bufio.NewWriterSize(w, j.bufferSize)
+		j.closers = append(j.closers, flushCloser{bw})
+		w = bw
+	}
+
+	j.out = w
+	return w, nil
+}
+
+// openSplit returns a splitEncoder for the receiver's -split-by and -split-dir
+// options. The encoder is added to the receiver's closers.
+func (j *jsonFilter) openSplit(ctx context.Context) (*splitEncoder, error) {
+	switch {
+	case j.splitDir == "":
+		return nil, errors.New("-split-by requires -split-dir")
+	case j.output != "":
+		return nil, errors.New("-split-by cannot be combined with -output")
+	case j.gzip:
+		return nil, errors.New("-split-by cannot be combined with -gzip")
+	}
+
+	key, err := compileQuery(ctx, prependDefs(j.prelude, j.splitBy))
+	if err != nil {
+		return nil, fmt.Errorf("split key: %w", err)
+	}
+	enc, err := newSplitEncoder(ctx, j.root, handlerPath(interp.HandlerCtx(ctx), j.splitDir), key, j.bufferSize, j.append)
+	if err != nil {
+		return nil, err
+	}
+	j.closers = append(j.closers, enc)
+	return enc, nil
+}
+
+// finish completes the receiver's output once every input has been run
+// through its query.
+func (j *jsonFilter) finish(ctx context.Context) error {
+	if j.printEmpty && j.results == 0 && j.splitBy == "" {
+		w, err := j.openOutput(interp.HandlerCtx(ctx))
+		if err != nil {
+			j.logger.Print(err)
+			return interp.NewExitStatus(1)
+		}
+		if _, err := io.WriteString(w, "\n"); err != nil {
 			j.logger.Printf("encoding error: %v", err)
 			return interp.NewExitStatus(1)
 		}
 	}
+	if err := j.close(); err != nil {
+		j.logger.Printf("error closing output: %v", err)
+		return interp.NewExitStatus(1)
+	}
 
+	if j.exitStatus {
+		// As in jq, the exit status reflects the last result.
+		switch {
+		case j.results == 0:
+			return interp.NewExitStatus(4)
+		case j.last == nil || j.last == false:
+			return interp.NewExitStatus(1)
+		}
+	}
 	return nil
 }
 
+// finishCount prints the tally held by counter, if it is not nil, and then
+// completes the receiver's output as finish does.
+func (j *jsonFilter) finishCount(ctx context.Context, counter *countEncoder) error {
+	if counter != nil {
+		w, err := j.openOutput(interp.HandlerCtx(ctx))
+		if err != nil {
+			j.logger.Print(err)
+			return interp.NewExitStatus(1)
+		}
+		j.enc = j.encoder(w)
+		if err := j.enc.Encode(counter.counts); err != nil {
+			j.logger.Printf("encoding error: %v", err)
+			return interp.NewExitStatus(1)
+		}
+		j.results++
+		j.last = counter.counts
+	}
+	return j.finish(ctx)
+}
+
+// flush flushes any buffered output. Output compressed with gzip is flushed as
+// well.
+func (j *jsonFilter) flush() error {
+	for i := len(j.closers) - 1; i >= 0; i-- {
+		if f, ok := j.closers[i].(interface{ Flush() error }); ok {
+			if err := f.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// close closes the receiver's output, if it has any. It is safe to call close
+// more than once.
+func (j *jsonFilter) close() error {
+	var first error
+	for i := len(j.closers) - 1; i >= 0; i-- {
+		if err := j.closers[i].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	j.closers = nil
+	return first
+}
+
+// decodeTimestamps returns a copy of val where numeric fields whose names end
+// in "_at", "_time", or "timestamp" are replaced by the time they represent,
+// as seconds since the Unix epoch, formatted using layout.
+func decodeTimestamps(val interface{}, layout string) interface{} {
+	switch val := val.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, v := range val {
+			if isTimestampKey(k) {
+				if sec, ok := toFloat(v); ok {
+					whole, frac := math.Modf(sec)
+					m[k] = time.Unix(int64(whole), int64(frac*1e9)).UTC().Format(layout)
+					continue
+				}
+			}
+			m[k] = decodeTimestamps(v, layout)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(val))
+		for i, v := range val {
+			s[i] = decodeTimestamps(v, layout)
+		}
+		return s
+	default:
+		return val
+	}
+}
+
+// isTimestampKey returns whether key names a field decoded by
+// decodeTimestamps.
+func isTimestampKey(key string) bool {
+	return strings.HasSuffix(key, "_at") ||
+		strings.HasSuffix(key, "_time") ||
+		strings.HasSuffix(key, "timestamp")
+}
+
+// flushCloser is an io.Closer that flushes a buffered writer when closed.
+type flushCloser struct {
+	*bufio.Writer
+}
+
+func (f flushCloser) Close() error { return f.Flush() }
+
+// isTerminal returns whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
 // nullStream is an io.Reader with no contents.
 type nullStream struct{}
 
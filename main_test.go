@@ -0,0 +1,653 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// testEvent is a minimal Sensu event for scripts run by runScript.
+func testEvent() map[string]interface{} {
+	return map[string]interface{}{
+		"entity": map[string]interface{}{
+			"metadata": map[string]interface{}{"name": "web-1"},
+		},
+		"check": map[string]interface{}{
+			"metadata": map[string]interface{}{"name": "disk"},
+			"status":   1,
+		},
+		"timestamp": 1600000000,
+	}
+}
+
+// runScript runs script with p, as Main does once the event has been read,
+// and returns its standard output, standard error, and exit status. env holds
+// NAME=VALUE pairs added to the script's environment.
+func runScript(t *testing.T, p *Prog, script string, env ...string) (stdout, stderr string, status int) {
+	t.Helper()
+	return runScriptContext(context.Background(), t, p, script, env...)
+}
+
+// runScriptContext is runScript with a context for the script. A script
+// stopped by the context without an exit status has the status -1.
+func runScriptContext(ctx context.Context, t *testing.T, p *Prog, script string, env ...string) (stdout, stderr string, status int) {
+	t.Helper()
+	file, err := syntax.NewParser(syntax.Variant(syntax.LangBash)).Parse(strings.NewReader(script), "test.sh")
+	if err != nil {
+		t.Fatalf("error parsing script: %v", err)
+	}
+
+	var out, errOut syncBuffer
+	p.defaultExec = interp.DefaultExecHandler(time.Second)
+	p.defaultEnv = expand.ListEnviron(append(os.Environ(), env...)...)
+	p.runner, err = p.newRunner(interp.StdIO(nullStream{}, &out, &errOut))
+	if err != nil {
+		t.Fatalf("error creating interpreter: %v", err)
+	}
+
+	if err := p.runner.Run(ctx, file); err != nil {
+		s, ok := interp.IsExitStatus(err)
+		if !ok && ctx.Err() != nil {
+			return out.String(), errOut.String(), -1
+		} else if !ok {
+			t.Fatalf("script error: %v\nstderr: %s", err, errOut.String())
+		}
+		status = int(s)
+	}
+	return out.String(), errOut.String(), status
+}
+
+// tempDir returns a new temporary directory that is removed when the test
+// ends.
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "sensu-sh-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	// Resolve symlinks, such as /tmp on macOS, so that paths compare equal
+	// to those resolved by fsRoot.
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// writeFile writes data to name in dir and returns its path.
+func writeFile(t *testing.T, dir, name, data string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(data), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// scriptCase is a script run against testEvent and its expected output and
+// exit status. If wantErr is set, standard error must contain it.
+type scriptCase struct {
+	name    string
+	script  string
+	want    string
+	status  int
+	wantErr string
+}
+
+// runScriptCases runs each case as a subtest with a new Prog. setup, if not
+// nil, configures each Prog before its script runs.
+func runScriptCases(t *testing.T, cases []scriptCase, setup func(p *Prog), env ...string) {
+	t.Helper()
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			p := &Prog{event: testEvent()}
+			if setup != nil {
+				setup(p)
+			}
+			stdout, stderr, status := runScript(t, p, c.script, env...)
+			if status != c.status {
+				t.Errorf("status = %d; want %d\nstderr: %s", status, c.status, stderr)
+			}
+			if stdout != c.want {
+				t.Errorf("stdout = %q; want %q\nstderr: %s", stdout, c.want, stderr)
+			}
+			if c.wantErr != "" && !strings.Contains(stderr, c.wantErr) {
+				t.Errorf("stderr = %q; want it to contain %q", stderr, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestPrintEmpty(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{name: "no results", script: `event empty`, want: ""},
+		{name: "print-empty", script: `event -print-empty empty`, want: "\n"},
+		{name: "print-empty with results", script: `event -print-empty .check.status`, want: "1"},
+		{name: "separators", script: `event '.check.status, 2'`, want: "1\n2"},
+		{name: "json", script: `event -json -print-empty empty`, want: "\n"},
+	}, nil)
+}
+
+// syncBuffer is a bytes.Buffer that may be written by concurrent pipeline
+// stages.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// withHandlerCtx calls f with a context holding the handler context of a
+// command run by an interpreter, as builtins receive.
+func withHandlerCtx(tb testing.TB, f func(ctx context.Context)) {
+	tb.Helper()
+	file, err := syntax.NewParser().Parse(strings.NewReader("run"), "")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	runner, err := interp.New(
+		interp.StdIO(nullStream{}, ioutil.Discard, ioutil.Discard),
+		interp.ExecHandler(func(ctx context.Context, args []string) error {
+			f(ctx)
+			return nil
+		}),
+	)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := runner.Run(context.Background(), file); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// BenchmarkFilterJSON compares compiling a query once for a stream of
+// documents against parsing and compiling it again for each document.
+func BenchmarkFilterJSON(b *testing.B) {
+	const query = `select(.check.status > 0) | {name: .entity.metadata.name, check: .check.metadata.name, status: .check.status}`
+	docs := make([]interface{}, 1000)
+	for i := range docs {
+		event := testEvent()
+		event["check"].(map[string]interface{})["status"] = i % 3
+		docs[i] = normalizeBench(b, event)
+	}
+
+	drain := func(b *testing.B, iter interface{ Next() (interface{}, bool) }) {
+		for {
+			v, ok := iter.Next()
+			if !ok {
+				return
+			}
+			if err, ok := v.(error); ok {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("compile once", func(b *testing.B) {
+		withHandlerCtx(b, func(ctx context.Context) {
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				code, err := compileQuery(ctx, query)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for _, doc := range docs {
+					drain(b, runQuery(ctx, code, doc))
+				}
+			}
+		})
+	})
+
+	b.Run("compile per document", func(b *testing.B) {
+		withHandlerCtx(b, func(ctx context.Context) {
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				for _, doc := range docs {
+					code, err := compileQuery(ctx, query)
+					if err != nil {
+						b.Fatal(err)
+					}
+					drain(b, runQuery(ctx, code, doc))
+				}
+			}
+		})
+	})
+}
+
+// normalizeBench round-trips v through JSON so that it holds the types a
+// decoded document would.
+func normalizeBench(tb testing.TB, v interface{}) interface{} {
+	tb.Helper()
+	p, err := json.Marshal(v)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var out interface{}
+	if err := json.Unmarshal(p, &out); err != nil {
+		tb.Fatal(err)
+	}
+	return out
+}
+
+func TestSlurp(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{name: "yaml stream", script: "query -ndjson -s . docs", want: `[{"a":1},{"a":2},3]` + "\n"},
+		{name: "add", script: "query -s 'map(.a? // .) | add' docs", want: "6"},
+		{name: "length", script: "query -s length docs", want: "3"},
+		{name: "empty", script: "query -ndjson -s . empty", want: "[]\n"},
+		{name: "multiple variables", script: "query -ndjson -s 'map(.a? // .)' docs docs", want: "[1,2,3,1,2,3]\n"},
+		{name: "raw", script: "query -ndjson -R -s . docs", want: `"a: 1\n---\na: 2\n---\n3\n"` + "\n"},
+		{name: "raw empty", script: "query -ndjson -R -s . empty", want: `""` + "\n"},
+		{name: "json stream", script: `printf '1 2 3' | query -strict-numbers -s length -`, want: "3"},
+	}, nil, "docs=a: 1\n---\na: 2\n---\n3\n", "empty=")
+}
+
+func TestPlainString(t *testing.T) {
+	cases := []struct {
+		name string
+		in   interface{}
+		want string
+	}{
+		{"null", nil, ""},
+		{"true", true, "true"},
+		{"false", false, "false"},
+		{"int", 42, "42"},
+		{"negative", -7, "-7"},
+		{"float", 1.5, "1.5"},
+		{"large float", 1e21, "1000000000000000000000"},
+		{"big", new(big.Int).Lsh(big.NewInt(1), 64), "18446744073709551616"},
+		{"string", "a b", "a b"},
+		{"array", []interface{}{1, nil, "x"}, `[1,null,"x"]`},
+		{"object", map[string]interface{}{"a": nil}, `{"a":null}`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := plainString(c.in)
+			if err != nil {
+				t.Fatalf("plainString(%v): %v", c.in, err)
+			}
+			if got != c.want {
+				t.Errorf("plainString(%v) = %q; want %q", c.in, got, c.want)
+			}
+		})
+	}
+}
+
+func TestPlainOutput(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{name: "null", script: `event .missing`, want: ""},
+		{name: "nulls", script: `event 'null, 1, null'`, want: "\n1\n"},
+		{name: "bools", script: `event 'true, false'`, want: "true\nfalse"},
+		{name: "integer", script: `event '.timestamp'`, want: "1600000000"},
+	}, nil)
+}
+
+// TestSortKeys checks that every output format writes object keys in sorted
+// order, with or without -S. Each case is run several times, since map
+// iteration order varies between runs.
+func TestSortKeys(t *testing.T) {
+	const query = `'{z: 1, a: {y: [{d: 1, b: 2}], c: 3}, m: "x"}'`
+	cases := []struct {
+		name, flags, want string
+	}{
+		{"json", "-json", `{"a":{"c":3,"y":[{"b":2,"d":1}]},"m":"x","z":1}` + "\n"},
+		{"pretty json", "-json -pretty", "{\n  \"a\": {\n    \"c\": 3,\n    \"y\": [\n      {\n        \"b\": 2,\n        \"d\": 1\n      }\n    ]\n  },\n  \"m\": \"x\",\n  \"z\": 1\n}\n"},
+		{"ndjson", "-ndjson", `{"a":{"c":3,"y":[{"b":2,"d":1}]},"m":"x","z":1}` + "\n"},
+		{"plain", "", `{"a":{"c":3,"y":[{"b":2,"d":1}]},"m":"x","z":1}`},
+		{"yaml", "-yaml", "a:\n    c: 3\n    \"y\":\n        - b: 2\n          d: 1\nm: x\nz: 1\n"},
+		// TOML requires tables to follow the keys of their parent table.
+		{"toml", "-toml", "m = \"x\"\nz = 1\n\n[a]\nc = 3\n\n[[a.y]]\nb = 2\nd = 1\n"},
+		{"xml", "-xml", "<root><a><c>3</c><y><b>2</b><d>1</d></y></a><m>x</m><z>1</z></root>\n"},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			for _, sort := range []string{"", "-S "} {
+				for i := 0; i < 10; i++ {
+					p := &Prog{event: testEvent()}
+					stdout, stderr, status := runScript(t, p, "event "+sort+c.flags+" -n "+query)
+					if status != 0 {
+						t.Fatalf("status = %d; stderr: %s", status, stderr)
+					}
+					if stdout != c.want {
+						t.Fatalf("%sstdout = %q; want %q", sort, stdout, c.want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestOutputFormatConflicts(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{name: "json and yaml", script: `event -json -yaml .check.status`, status: 1, wantErr: "-json and -yaml are mutually exclusive"},
+		{name: "yaml and toml", script: `event -yaml -toml .check`, status: 1, wantErr: "are mutually exclusive"},
+		{name: "csv and tsv", script: `event -csv -tsv '[1]'`, status: 1, wantErr: "are mutually exclusive"},
+		{name: "json and ndjson", script: `event -json -ndjson .check.status`, status: 1, wantErr: "are mutually exclusive"},
+		{name: "pretty csv", script: `event -csv -pretty '[1]'`, status: 1, wantErr: "-pretty cannot be combined with -csv"},
+		{name: "tab toml", script: `event -toml -tab .check`, status: 1, wantErr: "-tab cannot be combined with -toml"},
+		{name: "indent csv", script: `event -csv -indent 2 '[1]'`, status: 1, wantErr: "-indent cannot be combined with -csv"},
+		{name: "tab and indent", script: `event -json -tab -indent 2 .check.status`, status: 1, wantErr: "-tab and -indent are mutually exclusive"},
+		{name: "pretty json", script: `event -json -pretty '{a: 1}'`, want: "{\n  \"a\": 1\n}\n"},
+		{name: "pretty yaml", script: `event -Y -p '{a: {b: 1}}'`, want: "a:\n    b: 1\n"},
+		{name: "indent yaml", script: `event -yaml -indent 2 '{a: {b: 1}}'`, want: "a:\n  b: 1\n"},
+	}, nil)
+}
+
+func TestNoExec(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{name: "event", script: `event .check.status`, want: "1"},
+		{name: "query", script: `query .a doc`, want: "2"},
+		{name: "shell builtins", script: `echo hi; printf '%s\n' there`, want: "hi\nthere\n"},
+		{name: "curl", script: `curl http://example.com`, status: 126, wantErr: "curl: external commands disabled in sandbox mode"},
+		{name: "rm", script: `rm -rf /nonexistent`, status: 126, wantErr: "rm: external commands disabled in sandbox mode"},
+		{name: "path", script: `/bin/true`, status: 126, wantErr: "external commands disabled"},
+		{name: "command substitution", script: `x=$(cat /etc/hostname); echo "[$x]"`, want: "[]\n", wantErr: "cat: external commands disabled"},
+		{name: "filter-cmd", script: `event -filter-cmd cat .check.status`, status: 1, wantErr: "external commands disabled"},
+	}, func(p *Prog) { p.noExec = true }, "doc={\"a\": 2}")
+}
+
+// runMain runs Main with args and returns its exit status and the messages
+// it logged.
+func runMain(ctx context.Context, t *testing.T, args ...string) (status int, logged string) {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	status = (&Prog{}).Main(ctx, args)
+	return status, buf.String()
+}
+
+// testEventFile writes testEvent to a file and returns its path.
+func testEventFile(t *testing.T) string {
+	t.Helper()
+	p, err := json.Marshal(testEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeFile(t, tempDir(t), "event.json", string(p))
+}
+
+func TestCancel(t *testing.T) {
+	cases := []struct {
+		name, script string
+	}{
+		{"query", `event -n 'last(range(1e15))'`},
+		{"external command", `sleep 5`},
+		{"loop", `while true; do :; done`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			timer := time.AfterFunc(100*time.Millisecond, cancel)
+			defer timer.Stop()
+
+			start := time.Now()
+			p := &Prog{event: testEvent()}
+			_, stderr, status := runScriptContext(ctx, t, p, c.script)
+			if elapsed := time.Since(start); elapsed > 3*time.Second {
+				t.Errorf("script ran for %v after being cancelled", elapsed)
+			}
+			if status == 0 {
+				t.Errorf("status = 0; want a failure\nstderr: %s", stderr)
+			}
+		})
+	}
+}
+
+func TestMainInterrupt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	timer := time.AfterFunc(100*time.Millisecond, cancel)
+	defer timer.Stop()
+
+	status, logged := runMain(ctx, t, "-E", testEventFile(t), "-R", "sleep 5")
+	if status != 130 {
+		t.Errorf("status = %d; want 130", status)
+	}
+	if !strings.Contains(logged, "script interrupted") {
+		t.Errorf("logged %q; want it to contain %q", logged, "script interrupted")
+	}
+}
+
+func TestKillTimeout(t *testing.T) {
+	event := testEventFile(t)
+	// ignoreInt runs a command that ignores interrupts and must be killed.
+	const ignoreInt = `sh -c 'trap "" INT; exec sleep 5'`
+	cases := []struct {
+		name     string
+		args     []string
+		script   string
+		min, max time.Duration
+	}{
+		{"grace", []string{"-kill-timeout", "500ms"}, ignoreInt, 500 * time.Millisecond, 3 * time.Second},
+		{"immediate", []string{"-kill-timeout", "0"}, ignoreInt, 0, 400 * time.Millisecond},
+		{"interrupted", []string{"-kill-timeout", "5s"}, "sleep 5", 0, time.Second},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			start := time.Now()
+			args := append([]string{"-E", event, "-t", "50ms"}, c.args...)
+			status, logged := runMain(context.Background(), t, append(args, "-R", c.script)...)
+			if elapsed := time.Since(start); elapsed < c.min || elapsed > c.max {
+				t.Errorf("command ran for %v; want between %v and %v", elapsed, c.min, c.max)
+			}
+			if status != 124 {
+				t.Errorf("status = %d; want 124\nlogged: %s", status, logged)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		status, logged := runMain(context.Background(), t, "-E", event, "-kill-timeout", "-1s", "-R", "true")
+		if status != 1 || !strings.Contains(logged, "invalid kill timeout: -1s") {
+			t.Errorf("status = %d, logged %q; want 1 and an invalid kill timeout", status, logged)
+		}
+	})
+}
+
+func TestDeadline(t *testing.T) {
+	event := testEventFile(t)
+	cases := []struct {
+		name   string
+		script string
+		status int
+		logged string
+	}{
+		{"sleep", "sleep 5", 124, "script deadline exceeded"},
+		{"query", `event -n 'last(range(1e15))'`, 124, "script deadline exceeded"},
+		{"loop", "while true; do :; done", 124, "script deadline exceeded"},
+		{"in time", "exit 3", 3, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			start := time.Now()
+			status, logged := runMain(context.Background(), t, "-E", event, "-deadline", "200ms", "-R", c.script)
+			if elapsed := time.Since(start); elapsed > 3*time.Second {
+				t.Errorf("script ran for %v; want it stopped after 200ms", elapsed)
+			}
+			if status != c.status {
+				t.Errorf("status = %d; want %d\nlogged: %s", status, c.status, logged)
+			}
+			if !strings.Contains(logged, c.logged) {
+				t.Errorf("logged %q; want it to contain %q", logged, c.logged)
+			}
+		})
+	}
+}
+
+func TestReadEventGzip(t *testing.T) {
+	dir := tempDir(t)
+	const event = `{"check": {"status": 2}}`
+	gzipped := func(s string) string {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write([]byte(s))
+		w.Close()
+		return buf.String()
+	}
+	cases := []struct {
+		name, file, data string
+		want             interface{}
+		err              string
+	}{
+		{"plain", "event.json", event, 2, ""},
+		{"gzip", "event.json.gz", gzipped(event), 2, ""},
+		{"gzip without suffix", "event.json", gzipped(event), 2, ""},
+		{"yaml gzip", "event.yaml.gz", gzipped("check:\n  status: 2\n"), 2, ""},
+		{"plain with suffix", "plain.json.gz", event, 2, ""},
+		{"one byte", "short.json", "{", nil, "error parsing event"},
+		{"empty", "empty.json", "", nil, ""},
+		{"corrupt gzip", "corrupt.json.gz", gzipped(event)[:12], nil, "error parsing event"},
+		{"bad header", "header.json.gz", "\x1f\x8b\x00", nil, "error decompressing event"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := writeFile(t, dir, c.file, c.data)
+			p := &Prog{eventFormat: "auto"}
+			event, err := p.readEvent(context.Background(), path, false)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("readEvent() = %v, %v; want an error containing %q", event, err, c.err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("readEvent(): %v", err)
+			}
+			var got interface{}
+			if check, ok := event["check"].(map[string]interface{}); ok {
+				got = check["status"]
+			}
+			if got != c.want {
+				t.Errorf("status = %v; want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestFetchEvent(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/event", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"check": {"status": 2}}`)
+	})
+	mux.HandleFunc("/missing", http.NotFound)
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// The script succeeds only if the event was fetched.
+	const script = `test "$SENSU_CHECK_STATUS" = 2`
+	cases := []struct {
+		name   string
+		args   []string
+		status int
+		logged string
+	}{
+		{"fetch", []string{"-E", srv.URL + "/event"}, 0, ""},
+		{"not found", []string{"-E", srv.URL + "/missing"}, 1, "404 Not Found"},
+		{"no-exec", []string{"-no-exec", "-E", srv.URL + "/event"}, 1, "fetching events disabled in sandbox mode"},
+		{"not allowed", []string{"-allow", "cat", "-E", srv.URL + "/event"}, 1, "fetching events from http URLs not allowed (use -allow http)"},
+		{"https allowed", []string{"-allow", "https", "-E", srv.URL + "/event"}, 1, "not allowed"},
+		{"allowed", []string{"-allow", "http", "-E", srv.URL + "/event"}, 0, ""},
+		{"deadline", []string{"-deadline", "100ms", "-E", srv.URL + "/slow"}, 124, "context deadline exceeded"},
+		{"http timeout", []string{"-http-timeout", "100ms", "-E", srv.URL + "/slow"}, 1, "error reading event file"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			start := time.Now()
+			status, logged := runMain(context.Background(), t, append(c.args, "-R", script)...)
+			if elapsed := time.Since(start); elapsed > 3*time.Second {
+				t.Errorf("fetching the event took %v", elapsed)
+			}
+			if status != c.status {
+				t.Errorf("status = %d; want %d\nlogged: %s", status, c.status, logged)
+			}
+			if !strings.Contains(logged, c.logged) {
+				t.Errorf("logged %q; want it to contain %q", logged, c.logged)
+			}
+		})
+	}
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		timer := time.AfterFunc(100*time.Millisecond, cancel)
+		defer timer.Stop()
+		status, logged := runMain(ctx, t, "-E", srv.URL+"/slow", "-R", script)
+		if status != 130 || !strings.Contains(logged, "context canceled") {
+			t.Errorf("status = %d, logged %q; want 130 and a cancelled request", status, logged)
+		}
+	})
+}
+
+func TestQuerySources(t *testing.T) {
+	const vars = `a=$'1\n---\n2'; b=(3 '{"x": 4}'); c=5` + "\n"
+	runScriptCases(t, []scriptCase{
+		{name: "in order", script: vars + `query -ndjson . a b c`, want: "1\n2\n3\n{\"x\":4}\n5\n"},
+		{name: "slurp", script: vars + `query -ndjson -s . c a`, want: "[5,1,2]\n"},
+		{name: "elements", script: vars + `query -ndjson -s . b`, want: "[3,{\"x\":4}]\n"},
+		{name: "element source", script: vars + `query -ndjson . 'b[1]'`, want: "{\"x\":4}\n"},
+		{name: "string element", script: vars + `query -ndjson . 'c[0]' 'c[1]' 'b[2]'`, want: "5\n"},
+		{name: "raw", script: vars + `query -R . c b`, want: "53\n{\"x\": 4}"},
+		{name: "missing", script: vars + `query -ndjson . a missing c`, want: "1\n2\n5\n"},
+		{name: "inputs", script: vars + `query -n -ndjson '[inputs]' a c`, want: "[1,2,5]\n"},
+		{name: "split document", script: `b=('{"x":' '1}')` + "\n" + `query . b`, status: 1, wantErr: "error decoding input"},
+	}, nil)
+}
+
+func TestSplitElementSource(t *testing.T) {
+	cases := []struct {
+		source string
+		name   string
+		i      int
+		ok     bool
+	}{
+		{"b[1]", "b", 1, true},
+		{"b[0]", "b", 0, true},
+		{"b", "", 0, false},
+		{"[1]", "", 0, false},
+		{"b[-1]", "", 0, false},
+		{"b[x]", "", 0, false},
+		{"b[1", "", 0, false},
+	}
+	for _, c := range cases {
+		name, i, ok := splitElementSource(c.source)
+		if name != c.name || i != c.i || ok != c.ok {
+			t.Errorf("splitElementSource(%q) = %q, %d, %v; want %q, %d, %v", c.source, name, i, ok, c.name, c.i, c.ok)
+		}
+	}
+}
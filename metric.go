@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// metric implements the metric builtin. It prints a single metric point in
+// one of the metric formats understood by Sensu:
+//
+//	metric [options] NAME VALUE [TAG=VALUE...]
+func (p *Prog) metric(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "metric")
+	f := flag.NewFlagSet("metric", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	ts := int64(-1)
+	// -t, -timestamp
+	f.Int64Var(&ts, "t", ts, "Use the Unix `timestamp`, in seconds, instead of the current time. (long: -timestamp)")
+	f.Int64Var(&ts, "timestamp", ts, "Use the Unix `timestamp`, in seconds, instead of the current time. (short: -t)")
+	// -format
+	format := "graphite"
+	f.StringVar(&format, "format", format, "Print the metric in `format`: graphite, influx, opentsdb, or prom.")
+
+	if err := f.Parse(args[1:]); errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	if f.NArg() < 2 {
+		logger.Printf("expected a name and value")
+		return interp.NewExitStatus(1)
+	}
+	if ts < 0 {
+		ts = time.Now().Unix()
+	}
+
+	line, err := metricLine(format, f.Arg(0), f.Arg(1), f.Args()[2:], ts)
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+	if _, err := fmt.Fprintln(h.Stdout, line); err != nil {
+		logger.Printf("error writing metric: %v", err)
+		return interp.NewExitStatus(1)
+	}
+	return nil
+}
+
+// metricLine formats a metric point as a line of format, without a trailing
+// newline. Tags are given as TAG=VALUE strings and are written in sorted
+// order.
+func metricLine(format, name, value string, tagArgs []string, ts int64) (string, error) {
+	if name == "" || strings.ContainsAny(name, " \t\r\n,;={}\"") {
+		return "", fmt.Errorf("invalid metric name: %q", name)
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", fmt.Errorf("metric value is not a number: %q", value)
+	}
+	value = strconv.FormatFloat(f, 'f', -1, 64)
+
+	tags := map[string]string{}
+	keys := make([]string, 0, len(tagArgs))
+	for _, arg := range tagArgs {
+		i := strings.IndexByte(arg, '=')
+		if i <= 0 || strings.ContainsAny(arg, " \t\r\n,;\"") || strings.Contains(arg[i+1:], "=") {
+			return "", fmt.Errorf("invalid tag: %q: expected TAG=VALUE", arg)
+		}
+		if _, dup := tags[arg[:i]]; !dup {
+			keys = append(keys, arg[:i])
+		}
+		tags[arg[:i]] = arg[i+1:]
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	switch format {
+	case "graphite":
+		b.WriteString(name)
+		for _, k := range keys {
+			fmt.Fprintf(&b, ";%s=%s", k, tags[k])
+		}
+		fmt.Fprintf(&b, " %s %d", value, ts)
+	case "influx":
+		b.WriteString(name)
+		for _, k := range keys {
+			fmt.Fprintf(&b, ",%s=%s", k, tags[k])
+		}
+		fmt.Fprintf(&b, " value=%s %d", value, ts*int64(time.Second))
+	case "opentsdb":
+		fmt.Fprintf(&b, "%s %d %s", name, ts, value)
+		for _, k := range keys {
+			fmt.Fprintf(&b, " %s=%s", k, tags[k])
+		}
+	case "prom":
+		labels := make(map[string]interface{}, len(tags))
+		for k, v := range tags {
+			labels[k] = v
+		}
+		line, err := promLine(map[string]interface{}{
+			"name":      name,
+			"value":     f,
+			"labels":    labels,
+			"timestamp": float64(ts * 1000),
+		})
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(line, "\n"), nil
+	default:
+		return "", fmt.Errorf("unknown metric format: %q", format)
+	}
+	return b.String(), nil
+}
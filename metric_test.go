@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestMetricLine(t *testing.T) {
+	cases := []struct {
+		name, format, metric, value string
+		tags                        []string
+		want                        string
+		err                         bool
+	}{
+		{"graphite", "graphite", "cpu.user", "42.5", nil, "cpu.user 42.5 1700000000", false},
+		{"graphite tags", "graphite", "cpu.user", "1", []string{"z=1", "host=web-1"}, "cpu.user;host=web-1;z=1 1 1700000000", false},
+		{"influx", "influx", "cpu", "2", []string{"host=web-1"}, "cpu,host=web-1 value=2 1700000000000000000", false},
+		{"opentsdb", "opentsdb", "cpu", "2", []string{"b=2", "a=1"}, "cpu 1700000000 2 a=1 b=2", false},
+		{"prom", "prom", "cpu", "2", []string{"host=web-1"}, `cpu{host="web-1"} 2 1700000000000`, false},
+		{"duplicate tag", "graphite", "m", "1", []string{"a=1", "a=2"}, "m;a=2 1 1700000000", false},
+		{"empty tag value", "graphite", "m", "1", []string{"a="}, "m;a= 1 1700000000", false},
+		{"integer", "graphite", "m", "10", nil, "m 10 1700000000", false},
+		{"exponent", "graphite", "m", "1e3", nil, "m 1000 1700000000", false},
+		{"negative", "graphite", "m", "-0.5", nil, "m -0.5 1700000000", false},
+		{"not a number", "graphite", "m", "ten", nil, "", true},
+		{"empty value", "graphite", "m", "", nil, "", true},
+		{"nan", "graphite", "m", "NaN", nil, "", true},
+		{"infinity", "graphite", "m", "+Inf", nil, "", true},
+		{"empty name", "graphite", "", "1", nil, "", true},
+		{"name with space", "graphite", "a b", "1", nil, "", true},
+		{"tag without value", "graphite", "m", "1", []string{"a"}, "", true},
+		{"tag without name", "graphite", "m", "1", []string{"=1"}, "", true},
+		{"tag with space", "graphite", "m", "1", []string{"a=b c"}, "", true},
+		{"tag with equals", "graphite", "m", "1", []string{"a=b=c"}, "", true},
+		{"unknown format", "statsd", "m", "1", nil, "", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := metricLine(c.format, c.metric, c.value, c.tags, 1700000000)
+			if c.err {
+				if err == nil {
+					t.Fatalf("metricLine() = %q; want an error", got)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("metricLine(): %v", err)
+			}
+			if got != c.want {
+				t.Errorf("metricLine() = %q; want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestMetric(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{name: "graphite", script: `metric -t 1700000000 disk.used 91 host=web-1`, want: "disk.used;host=web-1 91 1700000000\n"},
+		{name: "loop", script: `for v in 1 2; do metric -timestamp 5 -format opentsdb m $v; done`, want: "m 5 1\nm 5 2\n"},
+		{name: "not a number", script: `metric m x`, status: 1, wantErr: `metric value is not a number: "x"`},
+		{name: "missing value", script: `metric m`, status: 1, wantErr: "expected a name and value"},
+	}, nil)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/itchyny/gojq"
+)
+
+// moduleLoader is a gojq.ModuleLoader that searches a list of directories for
+// modules, as jq does for -L. The module "a/b" is loaded from the first of
+// DIR/a/b.jq and DIR/a/b/b.jq found in any directory, in order. JSON data
+// imported as a variable is loaded from DIR/a/b.json.
+type moduleLoader struct {
+	dirs []string
+	root fsRoot
+}
+
+func (l *moduleLoader) LoadModule(name string) (*gojq.Module, error) {
+	path, err := l.find(name, name+".jq", filepath.Join(name, filepath.Base(name)+".jq"))
+	if err != nil {
+		return nil, err
+	}
+	src, err := l.root.readFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading module %q: %w", name, err)
+	}
+	m, err := gojq.ParseModule(string(src))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse module %q [%s]: %w", name, path, err)
+	}
+	return m, nil
+}
+
+// LoadJSON returns an array of the JSON values held by the data file name.
+func (l *moduleLoader) LoadJSON(name string) (interface{}, error) {
+	path, err := l.find(name, name+".json")
+	if err != nil {
+		return nil, err
+	}
+	f, err := l.root.open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading data %q: %w", name, err)
+	}
+	defer f.Close()
+
+	vals := []interface{}{}
+	dec := json.NewDecoder(f)
+	for {
+		var v interface{}
+		if err := dec.Decode(&v); errors.Is(err, io.EOF) {
+			return vals, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("error decoding data %q [%s]: %w", name, path, err)
+		}
+		vals = append(vals, v)
+	}
+}
+
+// find returns the first of the candidate files that exists in the loader's
+// directories.
+func (l *moduleLoader) find(name string, candidates ...string) (string, error) {
+	if filepath.IsAbs(name) {
+		return "", fmt.Errorf("module path must be relative: %q", name)
+	}
+	for _, dir := range l.dirs {
+		for _, c := range candidates {
+			path := filepath.Join(dir, c)
+			if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+				return path, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("module not found: %q", name)
+}
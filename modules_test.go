@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestModules(t *testing.T) {
+	lib := tempDir(t)
+	writeFile(t, lib, "helpers.jq", `def double: . * 2;`)
+	writeFile(t, lib, "nested/nested.jq", `def triple: . * 3;`)
+	writeFile(t, lib, "data.json", `{"a": 1} {"a": 2}`)
+	other := tempDir(t)
+	writeFile(t, other, "helpers.jq", `def double: . * 20;`)
+
+	runScriptCases(t, []scriptCase{
+		{name: "include", script: `event -L ` + lib + ` 'include "helpers"; .check.status | double'`, want: "2"},
+		{name: "import", script: `event -L ` + lib + ` 'import "helpers" as h; .check.status | h::double'`, want: "2"},
+		{name: "directory module", script: `event -L ` + lib + ` 'include "nested"; .check.status | triple'`, want: "3"},
+		{name: "data", script: `event -ndjson -L ` + lib + ` 'import "data" as $d; $d | map(.a)'`, want: "[1,2]\n"},
+		{name: "search order", script: `event -L ` + other + ` -L ` + lib + ` 'include "helpers"; .check.status | double'`, want: "20"},
+		{name: "not found", script: `event -L ` + lib + ` 'include "missing"; .'`, status: 1, wantErr: `module not found: "missing"`},
+		{name: "absolute", script: `event -L ` + lib + ` 'include "` + lib + `/helpers"; .'`, status: 1, wantErr: "module path must be relative"},
+	}, nil)
+}
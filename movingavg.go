@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// movingAvg implements the moving-avg builtin. It appends the number produced
+// by a query to a series persisted in a state file and prints the average of
+// the most recent values in the series:
+//
+//	moving-avg [options] FILE SOURCE QUERY
+func (p *Prog) movingAvg(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "moving-avg")
+	f := flag.NewFlagSet("moving-avg", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	window := 10
+	// -w, -window
+	f.IntVar(&window, "w", window, "Number of values to average over. (long: -window)")
+	f.IntVar(&window, "window", window, "Number of values to average over. (short: -w)")
+
+	pos, err := parseArgs(f, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	if len(pos) != 3 {
+		logger.Printf("expected a state file, source, and query")
+		return interp.NewExitStatus(1)
+	} else if window < 1 {
+		logger.Printf("window must be at least 1")
+		return interp.NewExitStatus(1)
+	}
+
+	docs, err := p.inputs(ctx, pos[1])
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	query, err := p.compileUserQuery(ctx, pos[2])
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	var vals []interface{}
+	for _, doc := range docs {
+		v, err := evalCode(ctx, query, doc)
+		if err != nil {
+			logger.Print(err)
+			return interp.NewExitStatus(1)
+		}
+		vals = append(vals, v...)
+	}
+	if len(vals) != 1 {
+		logger.Printf("query must produce exactly one value, got %d", len(vals))
+		return interp.NewExitStatus(1)
+	}
+	val, ok := toFloat(vals[0])
+	if !ok {
+		logger.Printf("query result is not a number: %v", vals[0])
+		return interp.NewExitStatus(1)
+	}
+
+	path, err := p.root.resolveTarget(handlerPath(h, pos[0]))
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+	series, err := readSeries(path)
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	series = append(series, val)
+	if over := len(series) - window; over > 0 {
+		series = series[over:]
+	}
+
+	data, err := json.Marshal(series)
+	if err != nil {
+		logger.Printf("error encoding series: %v", err)
+		return interp.NewExitStatus(1)
+	}
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		logger.Printf("error writing state file [%s]: %v", path, err)
+		return interp.NewExitStatus(1)
+	}
+
+	sum := 0.0
+	for _, v := range series {
+		sum += v
+	}
+	avg := sum / float64(len(series))
+	if _, err := fmt.Fprintln(h.Stdout, strconv.FormatFloat(avg, 'f', -1, 64)); err != nil {
+		logger.Printf("error writing average: %v", err)
+		return interp.NewExitStatus(1)
+	}
+	return nil
+}
+
+// readSeries reads a series of numbers from the state file at path, which has
+// been resolved by resolveTarget. A missing state file is an empty series.
+func readSeries(path string) ([]float64, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading state file [%s]: %w", path, err)
+	}
+
+	var series []float64
+	if err := json.Unmarshal(data, &series); err != nil {
+		return nil, fmt.Errorf("error parsing state file [%s]: %w", path, err)
+	}
+	return series, nil
+}
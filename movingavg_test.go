@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMovingAvg(t *testing.T) {
+	dir := tempDir(t)
+	writeFile(t, dir, "bad.json", "{")
+	runScriptCases(t, []scriptCase{
+		{name: "window", script: "cd " + dir + "\nfor v in 1 2 3 4; do echo $v | moving-avg -w 3 avg.json - .; done\ncat avg.json", want: "1\n1.5\n2\n3\n[2,3,4]"},
+		{name: "event", script: "cd " + dir + "\nmoving-avg status.json event .check.status", want: "1\n"},
+		{name: "not a number", script: "cd " + dir + "\necho x | moving-avg x.json - .", status: 1, wantErr: "query result is not a number"},
+		{name: "bad state", script: "cd " + dir + "\necho 1 | moving-avg bad.json - .", status: 1, wantErr: "error parsing state file [" + filepath.Join(dir, "bad.json") + "]"},
+		{name: "window too small", script: "echo 1 | moving-avg -w 0 avg.json - .", status: 1, wantErr: "window must be at least 1"},
+	}, nil)
+}
+
+func TestMovingAvgSymlink(t *testing.T) {
+	dir := tempDir(t)
+	target := writeFile(t, dir, "state/avg.json", "[2]")
+	link := filepath.Join(dir, "avg.json")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+	runScriptCases(t, []scriptCase{
+		{name: "through link", script: "echo 4 | moving-avg " + link + " - .", want: "3\n"},
+	}, nil)
+
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("state file symlink replaced: %v, %v", fi, err)
+	}
+	if data, err := ioutil.ReadFile(target); err != nil || string(data) != "[2,4]" {
+		t.Errorf("symlink target = %q, %v; want [2,4]", data, err)
+	}
+}
+
+func TestMovingAvgRoot(t *testing.T) {
+	dir := tempDir(t)
+	outside := tempDir(t)
+	secret := writeFile(t, outside, "secret.json", "[1]")
+	if err := os.Symlink(secret, filepath.Join(dir, "secret.json")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "pwned.json"), filepath.Join(dir, "dangling.json")); err != nil {
+		t.Fatal(err)
+	}
+	target := writeFile(t, dir, "state/avg.json", "[2]")
+	if err := os.Symlink(target, filepath.Join(dir, "avg.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	runScriptCases(t, []scriptCase{
+		{name: "inside", script: "cd " + dir + "\necho 1 | moving-avg new.json - .", want: "1\n"},
+		{name: "link inside", script: "cd " + dir + "\necho 4 | moving-avg avg.json - .", want: "3\n"},
+		{name: "absolute", script: "echo 1 | moving-avg " + secret + " - .", status: 1, wantErr: "outside of the root"},
+		{name: "link outside", script: "cd " + dir + "\necho 1 | moving-avg secret.json - .", status: 1, wantErr: "outside of the root"},
+		{name: "dangling link", script: "cd " + dir + "\necho 1 | moving-avg dangling.json - .", status: 1, wantErr: "outside of the root"},
+	}, func(p *Prog) {
+		root, err := newFSRoot(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.root = root
+	})
+
+	if data, err := ioutil.ReadFile(secret); err != nil || string(data) != "[1]" {
+		t.Errorf("state file outside of the root = %q, %v; want it unchanged", data, err)
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "pwned.json")); !os.IsNotExist(err) {
+		t.Errorf("state file created outside of the root: %v", err)
+	}
+	if data, err := ioutil.ReadFile(target); err != nil || string(data) != "[2,4]" {
+		t.Errorf("symlink target = %q, %v; want [2,4]", data, err)
+	}
+}
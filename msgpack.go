@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// msgpackTimestamp is the MessagePack extension type of timestamps.
+const msgpackTimestamp = -1
+
+// maxDecodeDepth limits how deeply the arrays, maps, and tags of binary
+// formats may nest, so that corrupt input cannot exhaust the stack.
+const maxDecodeDepth = 10000
+
+// msgpackDecoder is a Decoder for a stream of MessagePack values. Values are
+// decoded into the same types as YAML documents and normalized by
+// normalizeValue, so maps with keys that are not strings have their keys
+// converted to strings. Binary data is decoded as a string of its bytes, and
+// timestamps as RFC 3339 strings. Other extension types are an error.
+//
+// As with cborDecoder, this is written here rather than wrapping
+// github.com/vmihailenco/msgpack, which is not among the modules sensu-sh can
+// be built with. It is tested with encoded fixtures and random values in each
+// of the encodings MessagePack allows, both intact and corrupted.
+type msgpackDecoder struct {
+	r *bufio.Reader
+	// depth is the number of arrays and maps being decoded.
+	depth int
+}
+
+func newMsgpackDecoder(r io.Reader) *msgpackDecoder {
+	return &msgpackDecoder{r: bufio.NewReader(r)}
+}
+
+// Decode decodes the next value into v, which must be a *interface{} or a
+// *map[string]interface{}.
+func (m *msgpackDecoder) Decode(v interface{}) error {
+	if _, err := m.r.Peek(1); err != nil {
+		return err
+	}
+	doc, err := m.value()
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
+		return err
+	}
+	doc = normalizeValue(doc)
+
+	switch v := v.(type) {
+	case *interface{}:
+		*v = doc
+	case *map[string]interface{}:
+		obj, ok := doc.(map[string]interface{})
+		if !ok && doc != nil {
+			return fmt.Errorf("expected an object, got %s", typeName(doc))
+		}
+		*v = obj
+	default:
+		return fmt.Errorf("cannot decode into %T", v)
+	}
+	return nil
+}
+
+func (m *msgpackDecoder) value() (interface{}, error) {
+	if m.depth++; m.depth > maxDecodeDepth {
+		return nil, fmt.Errorf("MessagePack value nested too deeply: more than %d levels", maxDecodeDepth)
+	}
+	defer func() { m.depth-- }()
+
+	b, err := m.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b >= 0x80 && b <= 0x8f:
+		return m.mapValue(uint64(b & 0x0f))
+	case b >= 0x90 && b <= 0x9f:
+		return m.array(uint64(b & 0x0f))
+	case b >= 0xa0 && b <= 0xbf:
+		return m.str(uint64(b & 0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xd9:
+		n, err := m.uint(1)
+		if err != nil {
+			return nil, err
+		}
+		return m.str(n)
+	case 0xc5, 0xda:
+		n, err := m.uint(2)
+		if err != nil {
+			return nil, err
+		}
+		return m.str(n)
+	case 0xc6, 0xdb:
+		n, err := m.uint(4)
+		if err != nil {
+			return nil, err
+		}
+		return m.str(n)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := m.uint(1 << (b - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return m.ext(n)
+	case 0xca:
+		n, err := m.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := m.uint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return m.uint(1 << (b - 0xcc))
+	case 0xd0:
+		n, err := m.uint(1)
+		return int64(int8(n)), err
+	case 0xd1:
+		n, err := m.uint(2)
+		return int64(int16(n)), err
+	case 0xd2:
+		n, err := m.uint(4)
+		return int64(int32(n)), err
+	case 0xd3:
+		n, err := m.uint(8)
+		return int64(n), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return m.ext(1 << (b - 0xd4))
+	case 0xdc:
+		n, err := m.uint(2)
+		if err != nil {
+			return nil, err
+		}
+		return m.array(n)
+	case 0xdd:
+		n, err := m.uint(4)
+		if err != nil {
+			return nil, err
+		}
+		return m.array(n)
+	case 0xde:
+		n, err := m.uint(2)
+		if err != nil {
+			return nil, err
+		}
+		return m.mapValue(n)
+	case 0xdf:
+		n, err := m.uint(4)
+		if err != nil {
+			return nil, err
+		}
+		return m.mapValue(n)
+	}
+	return nil, fmt.Errorf("invalid MessagePack type 0x%02x", b)
+}
+
+// uint reads a big-endian unsigned integer of size bytes.
+func (m *msgpackDecoder) uint(size int) (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(m.r, buf[8-size:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+// bytes reads n bytes. The buffer grows as the bytes are read, so that a
+// corrupt length cannot allocate more memory than the input holds.
+func (m *msgpackDecoder) bytes(n uint64) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, m.r, int64(n)); errors.Is(err, io.EOF) {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (m *msgpackDecoder) str(n uint64) (interface{}, error) {
+	p, err := m.bytes(n)
+	return string(p), err
+}
+
+func (m *msgpackDecoder) array(n uint64) (interface{}, error) {
+	list := make([]interface{}, 0, minLen(n))
+	for i := uint64(0); i < n; i++ {
+		val, err := m.value()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, val)
+	}
+	return list, nil
+}
+
+func (m *msgpackDecoder) mapValue(n uint64) (interface{}, error) {
+	obj := make(map[interface{}]interface{}, minLen(n))
+	for i := uint64(0); i < n; i++ {
+		key, err := m.value()
+		if err != nil {
+			return nil, err
+		}
+		switch key.(type) {
+		case []interface{}, map[interface{}]interface{}:
+			return nil, fmt.Errorf("unsupported MessagePack map key: %s", typeName(normalizeValue(key)))
+		}
+		if obj[key], err = m.value(); err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
+
+// ext reads the type and n bytes of data of an extension value.
+func (m *msgpackDecoder) ext(n uint64) (interface{}, error) {
+	typ, err := m.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	data, err := m.bytes(n)
+	if err != nil {
+		return nil, err
+	} else if int8(typ) != msgpackTimestamp {
+		return nil, fmt.Errorf("unsupported MessagePack extension type %d", int8(typ))
+	}
+
+	var sec, nsec int64
+	switch len(data) {
+	case 4:
+		sec = int64(binary.BigEndian.Uint32(data))
+	case 8:
+		v := binary.BigEndian.Uint64(data)
+		sec, nsec = int64(v&(1<<34-1)), int64(v>>34)
+	case 12:
+		nsec = int64(binary.BigEndian.Uint32(data))
+		sec = int64(binary.BigEndian.Uint64(data[4:]))
+	default:
+		return nil, fmt.Errorf("invalid MessagePack timestamp length %d", len(data))
+	}
+	return time.Unix(sec, nsec).UTC().Format(time.RFC3339Nano), nil
+}
+
+// minLen returns the capacity to allocate for n elements, limited so that a
+// corrupt length cannot allocate more memory than the input holds.
+func minLen(n uint64) int {
+	if n > 1024 {
+		return 1024
+	}
+	return int(n)
+}
+
+// isMsgpackMap reports whether r begins with a MessagePack map, such as an
+// event encoded as MessagePack. None of the bytes that begin a map may begin a
+// JSON document, and only uncommon non-ASCII characters could begin a YAML
+// document with the same bytes.
+func isMsgpackMap(r *bufio.Reader) bool {
+	b, err := r.Peek(1)
+	if err != nil {
+		return false
+	}
+	return b[0] >= 0x80 && b[0] <= 0x8f || b[0] == 0xde || b[0] == 0xdf
+}
+
+// eventFormatFlag is a flag.Value for -event-format, which must be one of auto,
+// yaml, or msgpack.
+type eventFormatFlag struct {
+	format *string
+}
+
+func (e eventFormatFlag) String() string {
+	if e.format == nil {
+		return ""
+	}
+	return *e.format
+}
+
+func (e eventFormatFlag) Set(v string) error {
+	switch v {
+	case "auto", "yaml", "msgpack":
+		*e.format = v
+		return nil
+	}
+	return errors.New("must be one of auto, yaml, or msgpack")
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// msgpackEvent is {"entity":{"metadata":{"name":"web-1"}},"check":{"status":1}}
+// encoded as MessagePack.
+const msgpackEvent = "82 a6656e74697479 81 a86d65746164617461 81 a46e616d65 a57765622d31 a5636865636b 81 a6737461747573 01"
+
+// fromHex returns the bytes of h, a string of hex digits that may be separated
+// by spaces.
+func fromHex(t *testing.T, h string) string {
+	t.Helper()
+	p, err := hex.DecodeString(strings.Replace(h, " ", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(p)
+}
+
+func TestMsgpackDecoder(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		// want is the decoded value as JSON.
+		want string
+		err  string
+	}{
+		{"positive fixint", "05", `5`, ""},
+		{"negative fixint", "ff", `-1`, ""},
+		{"nil", "c0", `null`, ""},
+		{"false", "c2", `false`, ""},
+		{"true", "c3", `true`, ""},
+		{"uint8", "cc ff", `255`, ""},
+		{"uint16", "cd 0100", `256`, ""},
+		{"uint32", "ce 00010000", `65536`, ""},
+		{"uint64", "cf 0000000100000000", `4294967296`, ""},
+		{"uint64 max", "cf ffffffffffffffff", `18446744073709551615`, ""},
+		{"int8", "d0 80", `-128`, ""},
+		{"int16", "d1 ff00", `-256`, ""},
+		{"int32", "d2 ffff0000", `-65536`, ""},
+		{"int64", "d3 ffffffffffffffff", `-1`, ""},
+		{"float32", "ca 3fc00000", `1.5`, ""},
+		{"float64", "cb 3ff8000000000000", `1.5`, ""},
+		{"fixstr", "a3 616263", `"abc"`, ""},
+		{"str8", "d9 03 616263", `"abc"`, ""},
+		{"str16", "da 0003 616263", `"abc"`, ""},
+		{"bin8", "c4 02 6869", `"hi"`, ""},
+		{"fixarray", "92 01 a161", `[1,"a"]`, ""},
+		{"array16", "dc 0002 c0 c3", `[null,true]`, ""},
+		{"fixmap", "81 a161 01", `{"a":1}`, ""},
+		{"map16", "de 0001 a161 90", `{"a":[]}`, ""},
+		{"integer key", "82 01 a162 c3 a163", `{"1":"b","true":"c"}`, ""},
+		{"nested", "81 a161 81 a162 92 01 02", `{"a":{"b":[1,2]}}`, ""},
+		{"timestamp 32", "d6 ff 5f5e1000", `"2020-09-13T12:26:40Z"`, ""},
+		{"timestamp 64", "d7 ff 773594005f5e1000", `"2020-09-13T12:26:40.5Z"`, ""},
+		{"timestamp 96", "c7 0c ff 00000000 ffffffffffffffff", `"1969-12-31T23:59:59Z"`, ""},
+		{"event", msgpackEvent, `{"check":{"status":1},"entity":{"metadata":{"name":"web-1"}}}`, ""},
+		{"invalid type", "c1", "", "invalid MessagePack type 0xc1"},
+		{"unsupported extension", "d4 05 00", "", "unsupported MessagePack extension type 5"},
+		{"invalid timestamp", "c7 03 ff 000000", "", "invalid MessagePack timestamp length 3"},
+		{"array key", "81 90 01", "", "unsupported MessagePack map key: array"},
+		{"truncated string", "a3 61", "", io.ErrUnexpectedEOF.Error()},
+		{"truncated array", "92 01", "", io.ErrUnexpectedEOF.Error()},
+		{"truncated integer", "cd 01", "", io.ErrUnexpectedEOF.Error()},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var doc interface{}
+			err := newMsgpackDecoder(strings.NewReader(fromHex(t, c.in))).Decode(&doc)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("Decode(%s) = %v, %v; want an error containing %q", c.in, doc, err, c.err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("Decode(%s): %v", c.in, err)
+			}
+			got, err := json.Marshal(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != c.want {
+				t.Errorf("Decode(%s) = %s; want %s", c.in, got, c.want)
+			}
+		})
+	}
+}
+
+func TestMsgpackDecoderStream(t *testing.T) {
+	dec := newMsgpackDecoder(strings.NewReader(fromHex(t, "01 a161 81a16102")))
+	var docs []interface{}
+	for {
+		var doc interface{}
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, doc)
+	}
+	got, _ := json.Marshal(docs)
+	if want := `[1,"a",{"a":2}]`; string(got) != want {
+		t.Errorf("decoded %s; want %s", got, want)
+	}
+
+	var obj map[string]interface{}
+	err := newMsgpackDecoder(strings.NewReader(fromHex(t, "92 01 02"))).Decode(&obj)
+	if err == nil || err.Error() != "expected an object, got array" {
+		t.Errorf("Decode(&obj) = %v; want an error for an array", err)
+	}
+}
+
+func TestReadEventMsgpack(t *testing.T) {
+	dir := tempDir(t)
+	msgpack := writeFile(t, dir, "event.msgpack", fromHex(t, msgpackEvent))
+	yaml := writeFile(t, dir, "event.yaml", "check:\n  status: 2\n")
+	cases := []struct {
+		name, format, path string
+		want               interface{}
+		err                string
+	}{
+		{"auto", "auto", msgpack, 1, ""},
+		{"explicit", "msgpack", msgpack, 1, ""},
+		{"auto yaml", "auto", yaml, 2, ""},
+		{"yaml as msgpack", "msgpack", yaml, nil, "error parsing event"},
+		{"msgpack as yaml", "yaml", msgpack, nil, "error parsing event"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := &Prog{eventFormat: c.format}
+			event, err := p.readEvent(context.Background(), c.path, false)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("readEvent() = %v, %v; want an error containing %q", event, err, c.err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("readEvent(): %v", err)
+			}
+			check, _ := event["check"].(map[string]interface{})
+			if check["status"] != c.want {
+				t.Errorf("check.status = %#v; want %#v", check["status"], c.want)
+			}
+		})
+	}
+}
+
+func TestMainEventFormat(t *testing.T) {
+	path := writeFile(t, tempDir(t), "event.msgpack", fromHex(t, msgpackEvent))
+	cases := []struct {
+		name   string
+		args   []string
+		status int
+		logged string
+	}{
+		{"msgpack", []string{"-event-format", "msgpack"}, 0, ""},
+		{"invalid", []string{"-event-format", "cbor"}, 1, ""},
+		{"strict numbers", []string{"-event-format", "msgpack", "-strict-numbers"}, 1, "-strict-numbers cannot be combined with -event-format msgpack"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			args := append(c.args, "-E", path, "-R", `[ "$(event .check.status)" = 1 ]`)
+			status, logged := runMain(context.Background(), t, args...)
+			if status != c.status {
+				t.Errorf("status = %d; want %d\nlogged: %s", status, c.status, logged)
+			}
+			if !strings.Contains(logged, c.logged) {
+				t.Errorf("logged %q; want it to contain %q", logged, c.logged)
+			}
+		})
+	}
+}
+
+// appendMsgpack appends the MessagePack encoding of val, one of the values of
+// randomValue without big integers, to p. Where MessagePack has more than one
+// encoding of a value, r picks one, so that each form is decoded.
+func appendMsgpack(r *rand.Rand, p []byte, val interface{}) []byte {
+	// head appends the type and length n of a string, array, or map. A fixed
+	// type holds n in its low bits if n < fixMax, and the sizes of the
+	// lengths following the types in forms double from size.
+	head := func(n uint64, fix byte, fixMax uint64, size int, forms ...byte) []byte {
+		if n < fixMax && r.Intn(2) == 0 {
+			return append(p, fix|byte(n))
+		}
+		for i, b := range forms {
+			if n < 1<<(8*uint(size)) && (r.Intn(2) == 0 || i == len(forms)-1) {
+				var buf [8]byte
+				binary.BigEndian.PutUint64(buf[:], n)
+				return append(append(p, b), buf[8-size:]...)
+			}
+			size *= 2
+		}
+		panic("length too long")
+	}
+	switch val := val.(type) {
+	case nil:
+		return append(p, 0xc0)
+	case bool:
+		if val {
+			return append(p, 0xc3)
+		}
+		return append(p, 0xc2)
+	case int:
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], uint64(val))
+		switch {
+		case val >= 0 && val <= 0x7f && r.Intn(2) == 0, val >= -32 && val < 0 && r.Intn(2) == 0:
+			return append(p, byte(val))
+		case val >= math.MinInt8 && val <= math.MaxInt8 && r.Intn(2) == 0:
+			return append(append(p, 0xd0), buf[7:]...)
+		case val >= 0 && val <= math.MaxUint8 && r.Intn(2) == 0:
+			return append(append(p, 0xcc), buf[7:]...)
+		case val >= math.MinInt16 && val <= math.MaxInt16 && r.Intn(2) == 0:
+			return append(append(p, 0xd1), buf[6:]...)
+		case val >= 0 && val <= math.MaxUint16 && r.Intn(2) == 0:
+			return append(append(p, 0xcd), buf[6:]...)
+		case val >= math.MinInt32 && val <= math.MaxInt32 && r.Intn(2) == 0:
+			return append(append(p, 0xd2), buf[4:]...)
+		case val >= 0 && val <= math.MaxUint32 && r.Intn(2) == 0:
+			return append(append(p, 0xce), buf[4:]...)
+		case val >= 0 && r.Intn(2) == 0:
+			return append(append(p, 0xcf), buf[:]...)
+		}
+		return append(append(p, 0xd3), buf[:]...)
+	case float64:
+		var buf [8]byte
+		if f := float32(val); float64(f) == val && r.Intn(2) == 0 {
+			binary.BigEndian.PutUint32(buf[:], math.Float32bits(f))
+			return append(append(p, 0xca), buf[:4]...)
+		}
+		binary.BigEndian.PutUint64(buf[:], math.Float64bits(val))
+		return append(append(p, 0xcb), buf[:]...)
+	case string:
+		// Binary data decodes as a string as well.
+		if r.Intn(4) == 0 {
+			p = head(uint64(len(val)), 0, 0, 1, 0xc4, 0xc5, 0xc6)
+		} else {
+			p = head(uint64(len(val)), 0xa0, 32, 1, 0xd9, 0xda, 0xdb)
+		}
+		return append(p, val...)
+	case []interface{}:
+		p = head(uint64(len(val)), 0x90, 16, 2, 0xdc, 0xdd)
+		for _, v := range val {
+			p = appendMsgpack(r, p, v)
+		}
+		return p
+	case map[string]interface{}:
+		p = head(uint64(len(val)), 0x80, 16, 2, 0xde, 0xdf)
+		for k, v := range val {
+			p = appendMsgpack(r, appendMsgpack(r, p, k), v)
+		}
+		return p
+	}
+	panic(fmt.Sprintf("cannot encode %T as MessagePack", val))
+}
+
+func TestMsgpackRandomRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		want := randomValues{noBigInts: true}.randomValue(r, 4)
+		p := appendMsgpack(r, nil, want)
+		var got interface{}
+		dec := newMsgpackDecoder(bytes.NewReader(p))
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("Decode(%x) of %#v: %v", p, want, err)
+		} else if !sameValue(got, want) {
+			t.Fatalf("Decode(%x) = %#v; want %#v", p, got, want)
+		}
+		if err := dec.Decode(&got); err != io.EOF {
+			t.Fatalf("Decode after %x = %v; want EOF", p, err)
+		}
+	}
+}
+
+// TestMsgpackDecodeCorrupt decodes truncated, mutated, and random input, which
+// must fail without panicking or allocating more than the input holds.
+func TestMsgpackDecodeCorrupt(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	decode := func(p []byte) error {
+		var doc interface{}
+		dec := newMsgpackDecoder(bytes.NewReader(p))
+		for {
+			if err := dec.Decode(&doc); err != nil {
+				return err
+			}
+		}
+	}
+	for i := 0; i < 2000; i++ {
+		p := appendMsgpack(r, nil, randomValues{noBigInts: true}.randomValue(r, 3))
+		for n := 1; n < len(p); n++ {
+			if err := decode(p[:n]); err == io.EOF {
+				t.Fatalf("Decode(%x), a prefix of %x, succeeded", p[:n], p)
+			}
+		}
+		for j := 0; j < 4; j++ {
+			q := append([]byte(nil), p...)
+			q[r.Intn(len(q))] = byte(r.Intn(256))
+			decode(q)
+		}
+		q := make([]byte, r.Intn(32))
+		r.Read(q)
+		decode(q)
+	}
+
+	// Lengths far beyond the input fail once the input runs out.
+	for _, in := range []string{"db ffffffff 00", "c6 ffffffff 00", "c9 ffffffff ff 00", "dd ffffffff 00", "df ffffffff 0000"} {
+		if err := decode([]byte(fromHex(t, in))); err != io.ErrUnexpectedEOF {
+			t.Errorf("Decode(%s) = %v; want %v", in, err, io.ErrUnexpectedEOF)
+		}
+	}
+}
+
+func TestMsgpackDecodeDepth(t *testing.T) {
+	for _, head := range []string{"91", "dc 0001", "81 00"} {
+		p := bytes.Repeat([]byte(fromHex(t, head)), maxDecodeDepth+1)
+		var doc interface{}
+		err := newMsgpackDecoder(bytes.NewReader(p)).Decode(&doc)
+		if err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+			t.Errorf("Decode(%s × %d) = %v; want an error for nesting too deeply", head, maxDecodeDepth+1, err)
+		}
+	}
+
+	p := append(bytes.Repeat([]byte{0x91}, maxDecodeDepth-1), 0x01)
+	var doc interface{}
+	if err := newMsgpackDecoder(bytes.NewReader(p)).Decode(&doc); err != nil {
+		t.Errorf("Decode of %d nested arrays: %v", maxDecodeDepth-1, err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlDecoder is a Decoder for JSON and YAML documents whose values are
+// normalized for use by gojq. See normalizeValue.
+type yamlDecoder struct {
+	dec *yaml.Decoder
+}
+
+func newYAMLDecoder(r io.Reader) *yamlDecoder {
+	return &yamlDecoder{dec: yaml.NewDecoder(r)}
+}
+
+// Decode decodes the next document into v, which must be a *interface{} or a
+// *map[string]interface{}.
+func (y *yamlDecoder) Decode(v interface{}) error {
+	var doc interface{}
+	if err := y.dec.Decode(&doc); err != nil {
+		return err
+	}
+	doc = normalizeValue(doc)
+
+	switch v := v.(type) {
+	case *interface{}:
+		*v = doc
+	case *map[string]interface{}:
+		obj, ok := doc.(map[string]interface{})
+		if !ok && doc != nil {
+			return fmt.Errorf("expected an object, got %s", typeName(doc))
+		}
+		*v = obj
+	default:
+		return fmt.Errorf("cannot decode into %T", v)
+	}
+	return nil
+}
+
+// normalizeValue returns val with every map converted to a
+// map[string]interface{}, as gojq requires. Keys that are not strings, such as
+// the integer and boolean keys permitted by YAML, are converted to strings the
+// way plainString formats them, with null keys written as "null". Integers are
+// converted to the types gojq uses for them: an int if it fits, and otherwise
+// a *big.Int, so that no precision is lost.
+func normalizeValue(val interface{}) interface{} {
+	switch val := val.(type) {
+	case int64:
+		if int64(int(val)) == val {
+			return int(val)
+		}
+		return big.NewInt(val)
+	case uint64:
+		if val <= math.MaxInt64 && int64(int(val)) == int64(val) {
+			return int(val)
+		}
+		return new(big.Int).SetUint64(val)
+	case map[string]interface{}:
+		for k, v := range val {
+			val[k] = normalizeValue(v)
+		}
+		return val
+	case map[interface{}]interface{}:
+		obj := make(map[string]interface{}, len(val))
+		for k, v := range val {
+			obj[keyString(k)] = normalizeValue(v)
+		}
+		return obj
+	case []interface{}:
+		for i, v := range val {
+			val[i] = normalizeValue(v)
+		}
+		return val
+	default:
+		return val
+	}
+}
+
+// keyString returns the map key k as a string.
+func keyString(k interface{}) string {
+	if k == nil {
+		return "null"
+	}
+	str, err := plainString(normalizeValue(k))
+	if err != nil {
+		return fmt.Sprint(k)
+	}
+	return str
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeValueKeys(t *testing.T) {
+	cases := []struct {
+		name string
+		in   interface{}
+		want interface{}
+	}{
+		{"string keys", map[string]interface{}{"a": 1}, map[string]interface{}{"a": 1}},
+		{"int key", map[interface{}]interface{}{1: "a"}, map[string]interface{}{"1": "a"}},
+		{"bool key", map[interface{}]interface{}{true: "a", false: "b"}, map[string]interface{}{"true": "a", "false": "b"}},
+		{"null key", map[interface{}]interface{}{nil: "a"}, map[string]interface{}{"null": "a"}},
+		{"float key", map[interface{}]interface{}{1.5: "a"}, map[string]interface{}{"1.5": "a"}},
+		{
+			"nested",
+			[]interface{}{map[string]interface{}{"a": map[interface{}]interface{}{2: []interface{}{map[interface{}]interface{}{true: nil}}}}},
+			[]interface{}{map[string]interface{}{"a": map[string]interface{}{"2": []interface{}{map[string]interface{}{"true": nil}}}}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := normalizeValue(c.in); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("normalizeValue(%v) = %#v; want %#v", c.in, got, c.want)
+			}
+		})
+	}
+}
+
+func TestYAMLKeys(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{name: "keys", script: `query -ndjson keys doc`, want: `["1","false","name","true"]` + "\n"},
+		{name: "int key", script: `query '.["1"]' doc`, want: "one"},
+		{name: "bool key", script: `query '.["true"]' doc`, want: "yes"},
+		{name: "nested", script: `query -ndjson '.name' doc`, want: `{"2":"two"}` + "\n"},
+	}, nil, "doc=1: one\ntrue: yes\nfalse: no\nname:\n  2: two\n")
+}
+
+func TestNormalizeValueNumbers(t *testing.T) {
+	huge, _ := new(big.Int).SetString("18446744073709551615", 10)
+	cases := []struct {
+		name string
+		in   interface{}
+		want interface{}
+	}{
+		{"int64", int64(5), 5},
+		{"negative int64", int64(-5), -5},
+		{"uint64", uint64(7), 7},
+		{"max int64", int64(math.MaxInt64), int(math.MaxInt64)},
+		{"max uint64", uint64(math.MaxUint64), huge},
+		{"float", 1.5, 1.5},
+		{"in array", []interface{}{int64(1), uint64(2)}, []interface{}{1, 2}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := normalizeValue(c.in); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("normalizeValue(%v) = %#v; want %#v", c.in, got, c.want)
+			}
+		})
+	}
+}
+
+func TestYAMLNumbers(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{name: "add", script: `query '. + 1' n`, want: "42"},
+		{name: "type", script: `query 'type' n`, want: "number"},
+		{name: "equal", script: `query '. == 41.0' n`, want: "true"},
+		{name: "large", script: `query '.' big`, want: "18446744073709551615"},
+		{name: "large add", script: `query '. + 1' big`, want: "18446744073709551616"},
+		{name: "float", script: `query '. * 2' f`, want: "3"},
+	}, nil, "n=41", "big=18446744073709551615", "f=1.5")
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// offsetWindow is the number of trailing bytes kept by an offsetReader.
+	// Errors before them are reported without context.
+	offsetWindow = 64 * 1024
+	// offsetContext is the number of bytes of input shown on either side of
+	// an error's position.
+	offsetContext = 24
+)
+
+// yamlLineRe matches the line number of a YAML error, such as "yaml: line 3:"
+// or the "line 3:" of each unmarshal error.
+var yamlLineRe = regexp.MustCompile(`\bline (\d+):`)
+
+// offsetReader is an io.Reader that tracks the number of bytes read from its
+// underlying reader and retains the last offsetWindow of them. It is used to
+// give decoding errors a location in their input.
+type offsetReader struct {
+	r   io.Reader
+	off int64
+	// buf holds the last bytes read, and line is the line number of the
+	// first of them.
+	buf  []byte
+	line int
+}
+
+func newOffsetReader(r io.Reader) *offsetReader {
+	return &offsetReader{r: r, line: 1}
+}
+
+func (o *offsetReader) Read(b []byte) (int, error) {
+	n, err := o.r.Read(b)
+	o.off += int64(n)
+	o.buf = append(o.buf, b[:n]...)
+	// Trim the window only once it has doubled, so that bytes are not
+	// copied on every read.
+	if over := len(o.buf) - offsetWindow; over > offsetWindow {
+		o.line += bytes.Count(o.buf[:over], []byte{'\n'})
+		o.buf = append(o.buf[:0], o.buf[over:]...)
+	}
+	return n, err
+}
+
+// base returns the offset of the first byte of the window.
+func (o *offsetReader) base() int64 {
+	return o.off - int64(len(o.buf))
+}
+
+// annotate returns err with its position in the input and the input around
+// it. The position is taken from the byte offset of a JSON error or the line
+// of an XML or YAML error. If err has no position, the number of bytes read is
+// given instead. Decoders read ahead, so that is only an upper bound on where
+// the error occurred.
+func (o *offsetReader) annotate(err error) error {
+	pos, ok := o.position(err)
+	if !ok {
+		tail := o.buf
+		if len(tail) > 2*offsetContext {
+			tail = tail[len(tail)-2*offsetContext:]
+		}
+		return fmt.Errorf("%w (at or before byte offset %d, near %q)", err, o.off, tail)
+	}
+
+	i := pos - o.base()
+	if i < 0 || i > int64(len(o.buf)) {
+		return fmt.Errorf("%w (at byte offset %d)", err, pos)
+	}
+	line := o.line + bytes.Count(o.buf[:i], []byte{'\n'})
+	start, end := i-offsetContext, i+offsetContext
+	if start < 0 {
+		start = 0
+	}
+	if end > int64(len(o.buf)) {
+		end = int64(len(o.buf))
+	}
+	return fmt.Errorf("%w (at line %d, byte offset %d, near %q)", err, line, pos, o.buf[start:end])
+}
+
+// position returns the byte offset in the input at which err occurred, if it
+// is known. For errors that only give a line, it is the start of the line.
+func (o *offsetReader) position(err error) (int64, bool) {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var xmlErr *xml.SyntaxError
+	switch {
+	case errors.As(err, &syntaxErr):
+		// The offset is just past the byte that caused the error.
+		if syntaxErr.Offset > 0 {
+			return syntaxErr.Offset - 1, true
+		}
+		return 0, true
+	case errors.As(err, &typeErr):
+		return typeErr.Offset, true
+	case errors.As(err, &xmlErr):
+		return o.lineStart(xmlErr.Line)
+	case strings.HasPrefix(err.Error(), "yaml: "):
+		m := yamlLineRe.FindStringSubmatch(err.Error())
+		if m == nil {
+			return 0, false
+		}
+		line, _ := strconv.Atoi(m[1])
+		return o.lineStart(line)
+	}
+	return 0, false
+}
+
+// lineStart returns the offset of the first byte of line, if it is within the
+// window.
+func (o *offsetReader) lineStart(line int) (int64, bool) {
+	if line < o.line {
+		return 0, false
+	}
+	i := 0
+	for n := o.line; n < line; n++ {
+		nl := bytes.IndexByte(o.buf[i:], '\n')
+		if nl < 0 {
+			return 0, false
+		}
+		i += nl + 1
+	}
+	return o.base() + int64(i), true
+}
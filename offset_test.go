@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestOffsetReaderAnnotate(t *testing.T) {
+	cases := []struct {
+		name   string
+		input  string
+		strict bool
+		xml    bool
+		want   string
+	}{
+		{
+			name:   "json",
+			input:  `{"a":1}` + "\n" + `{"b": tru}` + "\n",
+			strict: true,
+			want:   `(at line 2, byte offset 17, near "{\"a\":1}\n{\"b\": tru}\n")`,
+		},
+		{
+			name:  "yaml",
+			input: "{\"a\": 1,\n \"b\": [1,2,\n}\n",
+			want:  `(at line 2, byte offset 9, near "{\"a\": 1,\n \"b\": [1,2,\n}\n")`,
+		},
+		{
+			name:  "xml",
+			input: "<a>\n<b></a>",
+			xml:   true,
+			want:  `(at line 2, byte offset 4, near "<a>\n<b></a>")`,
+		},
+		{
+			// Only the bytes around the error are shown.
+			name:   "window",
+			input:  `[` + strings.Repeat(`1,`, 100) + `x]`,
+			strict: true,
+			want:   `(at line 1, byte offset 201, near "1,1,1,1,1,1,1,1,1,1,1,1,x]")`,
+		},
+		{
+			// Errors without a position give the bytes read so far.
+			name:  "unknown",
+			input: "1\n",
+			want:  `expected an object, got number (at or before byte offset 2, near "1\n")`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			or := newOffsetReader(strings.NewReader(c.input))
+			var dec Decoder = newDecoder(or, c.strict)
+			if c.xml {
+				dec = newXMLDecoder(or)
+			}
+			var err error
+			for err == nil {
+				var doc map[string]interface{}
+				err = dec.Decode(&doc)
+			}
+			if errors.Is(err, io.EOF) {
+				t.Fatal("no decoding error")
+			}
+			if got := or.annotate(err).Error(); !strings.HasSuffix(got, c.want) {
+				t.Errorf("annotate() = %q; want suffix %q", got, c.want)
+			}
+		})
+	}
+}
+
+// TestOffsetReaderWindow checks that the line and offset of an error are
+// correct once the start of the input has left the window.
+func TestOffsetReaderWindow(t *testing.T) {
+	input := strings.Repeat("[1]\n", offsetWindow) + "[x]\n"
+	or := newOffsetReader(strings.NewReader(input))
+	dec := newDecoder(or, true)
+	var err error
+	for err == nil {
+		var doc interface{}
+		err = dec.Decode(&doc)
+	}
+	want := `(at line 65537, byte offset 262145, near "1]\n[1]\n[1]\n[1]\n[1]\n[1]\n[x]\n")`
+	if got := or.annotate(err).Error(); !strings.HasSuffix(got, want) {
+		t.Errorf("annotate() = %q; want suffix %q", got, want)
+	}
+}
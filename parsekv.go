@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// kvNumberRe matches values of key=value pairs that are converted to numbers.
+var kvNumberRe = regexp.MustCompile(`^-?(?:\d+\.?\d*|\.\d+)(?:[eE][-+]?\d+)?$`)
+
+// parseKV implements the parse-kv builtin. It queries the event for strings of
+// key=value pairs and prints each of them as an object:
+//
+//	parse-kv [options] [query]
+func (p *Prog) parseKV(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "parse-kv")
+	f := flag.NewFlagSet("parse-kv", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	strs := false
+	// -s, -strings
+	f.BoolVar(&strs, "s", strs, "Keep all values as strings. (long: -strings)")
+	f.BoolVar(&strs, "strings", strs, "Keep all values as strings. (short: -s)")
+
+	filter := p.newJSONFilter(logger)
+	filter.bind(f)
+	defer filter.close()
+
+	if err := f.Parse(pairArgs(f, args[1:])); errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	queryStr := ".check.output"
+	if f.NArg() == 1 {
+		queryStr = f.Arg(0)
+	} else if f.NArg() > 1 {
+		logger.Printf("too many arguments to parse-kv: expected 0..1")
+		return interp.NewExitStatus(1)
+	}
+	queryStr, err := filter.loadQuery(h, queryStr, f.NArg() == 1)
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	filter.transforms = append(filter.transforms, func(val interface{}) (interface{}, error) {
+		str, ok := val.(string)
+		if !ok {
+			return nil, fmt.Errorf("query result is not a string: %T", val)
+		}
+		return parseKVString(str, strs)
+	})
+
+	query, err := filter.compile(ctx, queryStr)
+	if err != nil {
+		return err
+	}
+	if err := filter.run(ctx, query, p.currentEvent()); err != nil {
+		return err
+	}
+	return filter.finish(ctx)
+}
+
+// parseKVString parses whitespace-separated key=value pairs from str. Values
+// may be double-quoted, permitting backslash escapes, or single-quoted.
+// Unquoted values are converted to numbers or booleans where possible unless
+// strs is true. Words without an equals sign are ignored.
+func parseKVString(str string, strs bool) (map[string]interface{}, error) {
+	obj := map[string]interface{}{}
+	rs := []rune(str)
+	for i := 0; i < len(rs); {
+		if unicode.IsSpace(rs[i]) {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(rs) && rs[i] != '=' && !unicode.IsSpace(rs[i]) {
+			i++
+		}
+		if i == len(rs) || rs[i] != '=' || i == start {
+			// Not a key=value pair: skip the rest of the word.
+			for i < len(rs) && !unicode.IsSpace(rs[i]) {
+				i++
+			}
+			continue
+		}
+		key := string(rs[start:i])
+		i++ // Skip '='
+
+		var value strings.Builder
+		quoted := false
+		for i < len(rs) && !unicode.IsSpace(rs[i]) {
+			switch q := rs[i]; q {
+			case '"', '\'':
+				quoted = true
+				i++
+				for ; i < len(rs) && rs[i] != q; i++ {
+					if q == '"' && rs[i] == '\\' && i+1 < len(rs) {
+						i++
+					}
+					value.WriteRune(rs[i])
+				}
+				if i == len(rs) {
+					return nil, fmt.Errorf("unterminated quote in value of %q", key)
+				}
+				i++
+			case '\\':
+				if i+1 < len(rs) {
+					i++
+				}
+				value.WriteRune(rs[i])
+				i++
+			default:
+				value.WriteRune(q)
+				i++
+			}
+		}
+
+		obj[key] = kvValue(value.String(), quoted || strs)
+	}
+	return obj, nil
+}
+
+// kvValue returns the value of a key=value pair, converting it to a number or
+// boolean if asString is false and it parses as one.
+func kvValue(s string, asString bool) interface{} {
+	if asString {
+		return s
+	}
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if kvNumberRe.MatchString(s) {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return s
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+var (
+	// perfValueRe matches a performance data value and its unit of measure.
+	perfValueRe = regexp.MustCompile(`^(?:U|-?(?:\d+\.?\d*|\.\d+)(?:[eE][-+]?\d+)?(?:s|ms|us|%|B|KB|MB|GB|TB|c)?)$`)
+	// perfRangeRe matches a warning or critical threshold range.
+	perfRangeRe = regexp.MustCompile(`^@?(?:(?:~|-?(?:\d+\.?\d*|\.\d+)):)?(?:-?(?:\d+\.?\d*|\.\d+))?$`)
+	// perfNumberRe matches a minimum or maximum value.
+	perfNumberRe = regexp.MustCompile(`^(?:-?(?:\d+\.?\d*|\.\d+))?$`)
+)
+
+// perfdata implements the perfdata builtin. It validates each argument as
+// a Nagios plugin performance data item and prints them following a pipe, as
+// they would appear at the end of check output:
+//
+//	perfdata LABEL=VALUE[UOM][;WARN[;CRIT[;MIN[;MAX]]]]...
+func (p *Prog) perfdata(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "perfdata")
+	f := flag.NewFlagSet("perfdata", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	if err := f.Parse(args[1:]); errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	if f.NArg() == 0 {
+		logger.Printf("no performance data given")
+		return interp.NewExitStatus(1)
+	}
+
+	items := make([]string, f.NArg())
+	for i, arg := range f.Args() {
+		item, err := formatPerfdata(arg)
+		if err != nil {
+			logger.Print(err)
+			return interp.NewExitStatus(1)
+		}
+		items[i] = item
+	}
+
+	if _, err := fmt.Fprintf(h.Stdout, "| %s\n", strings.Join(items, " ")); err != nil {
+		logger.Printf("error writing performance data: %v", err)
+		return interp.NewExitStatus(1)
+	}
+	return nil
+}
+
+// formatPerfdata validates a single performance data item and returns it in
+// its canonical form, quoting the label if needed.
+func formatPerfdata(item string) (string, error) {
+	eq := strings.LastIndexByte(item, '=')
+	if eq == -1 {
+		return "", fmt.Errorf("invalid performance data %q: expected label=value", item)
+	}
+
+	label := item[:eq]
+	if len(label) >= 2 && label[0] == '\'' && label[len(label)-1] == '\'' {
+		label = strings.ReplaceAll(label[1:len(label)-1], "''", "'")
+	}
+	if label == "" || strings.ContainsRune(label, '=') {
+		return "", fmt.Errorf("invalid performance data %q: invalid label", item)
+	}
+
+	fields := strings.Split(item[eq+1:], ";")
+	if len(fields) > 5 {
+		return "", fmt.Errorf("invalid performance data %q: too many fields", item)
+	}
+	for i, field := range fields {
+		var re *regexp.Regexp
+		var what string
+		switch i {
+		case 0:
+			re, what = perfValueRe, "value"
+		case 1:
+			re, what = perfRangeRe, "warning range"
+		case 2:
+			re, what = perfRangeRe, "critical range"
+		case 3:
+			re, what = perfNumberRe, "minimum"
+		case 4:
+			re, what = perfNumberRe, "maximum"
+		}
+		if !re.MatchString(field) {
+			return "", fmt.Errorf("invalid performance data %q: invalid %s %q", item, what, field)
+		}
+	}
+
+	if strings.ContainsAny(label, " '") {
+		label = "'" + strings.ReplaceAll(label, "'", "''") + "'"
+	}
+	return label + "=" + strings.Join(fields, ";"), nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	promNameRe  = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	promLabelRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+	promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// promEncoder is an Encoder that writes metric objects as lines of the
+// Prometheus text exposition format. Each value must be an object with a
+// "name" and numeric "value", and may have an object of string "labels" and a
+// numeric "timestamp" in milliseconds.
+type promEncoder struct {
+	w io.Writer
+}
+
+func newPromEncoder(w io.Writer) *promEncoder {
+	return &promEncoder{w: w}
+}
+
+func (p *promEncoder) Encode(val interface{}) error {
+	line, err := promLine(val)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, compactJSON(val))
+	}
+	_, err = io.WriteString(p.w, line)
+	return err
+}
+
+// promLine returns the exposition line, including its newline, for the metric
+// val.
+func promLine(val interface{}) (string, error) {
+	metric, ok := val.(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("metric must be an object, got %s", typeName(val))
+	}
+
+	name, _ := metric["name"].(string)
+	if !promNameRe.MatchString(name) {
+		return "", fmt.Errorf("metric name must be a valid Prometheus metric name")
+	}
+	value, ok := metricNumber(metric["value"])
+	if !ok {
+		return "", fmt.Errorf("metric value must be a number")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(name)
+
+	switch labels := metric["labels"].(type) {
+	case nil:
+	case map[string]interface{}:
+		keys := make([]string, 0, len(labels))
+		for k := range labels {
+			if !promLabelRe.MatchString(k) {
+				return "", fmt.Errorf("invalid metric label name: %q", k)
+			}
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for i, k := range keys {
+			v, ok := labels[k].(string)
+			if !ok {
+				return "", fmt.Errorf("metric label %s must be a string, got %s", k, typeName(labels[k]))
+			}
+			if i == 0 {
+				buf.WriteByte('{')
+			} else {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(k)
+			buf.WriteString(`="`)
+			buf.WriteString(promEscaper.Replace(v))
+			buf.WriteByte('"')
+		}
+		if len(keys) > 0 {
+			buf.WriteByte('}')
+		}
+	default:
+		return "", fmt.Errorf("metric labels must be an object, got %s", typeName(labels))
+	}
+
+	buf.WriteByte(' ')
+	buf.WriteString(value)
+
+	if ts, ok := metric["timestamp"]; ok && ts != nil {
+		f, ok := toFloat(ts)
+		if !ok {
+			return "", fmt.Errorf("metric timestamp must be a number, got %s", typeName(ts))
+		}
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatInt(int64(f), 10))
+	}
+
+	buf.WriteByte('\n')
+	return buf.String(), nil
+}
+
+// metricNumber formats val for a metric line if it is a number. Numbers are
+// formatted without exponents, as plainString does.
+func metricNumber(val interface{}) (string, bool) {
+	if _, ok := toFloat(val); !ok {
+		return "", false
+	}
+	str, err := plainString(val)
+	return str, err == nil
+}
+
+// compactJSON returns val as compact JSON for use in error messages.
+func compactJSON(val interface{}) string {
+	p, err := json.Marshal(val)
+	if err != nil {
+		return fmt.Sprint(val)
+	}
+	return string(p)
+}
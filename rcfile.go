@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/itchyny/gojq"
+	"mvdan.cc/sh/v3/interp"
+)
+
+// rcFileName is the name of the file of jq definitions loaded for every query
+// from the home directory and the working directory.
+const rcFileName = ".sensu-sh.jq"
+
+// rcFiles returns the paths of the rc files that may be loaded: the one in
+// $HOME, then the one in the working directory, so that definitions in the
+// working directory take precedence.
+func rcFiles(h interp.HandlerContext) []string {
+	var paths []string
+	if home := h.Env.Get("HOME").String(); home != "" {
+		paths = append(paths, filepath.Join(home, rcFileName))
+	}
+	local := handlerPath(h, rcFileName)
+	if len(paths) == 0 || filepath.Clean(paths[0]) != filepath.Clean(local) {
+		paths = append(paths, local)
+	}
+	return paths
+}
+
+// loadRCFiles returns the definitions in the rc files that exist. Files that
+// do not exist or are outside of root are skipped. As with loadDefs, each file
+// must contain only definitions.
+func loadRCFiles(h interp.HandlerContext, root fsRoot) (string, error) {
+	var defs strings.Builder
+	for _, path := range rcFiles(h) {
+		p, err := root.readFile(path)
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, errOutsideRoot) {
+			continue
+		} else if err != nil {
+			return "", fmt.Errorf("error reading rc file: %w", err)
+		}
+		if _, err := gojq.Parse(string(p) + "\n."); err != nil {
+			return "", fmt.Errorf("unable to parse rc file [%s] (use -no-rcfile to skip it): %w", path, err)
+		}
+		defs.Write(p)
+		defs.WriteByte('\n')
+	}
+	return defs.String(), nil
+}
+
+// loadRCFiles returns the definitions in the rc files, unless -no-rcfile was
+// given to the command or to sensu-sh.
+func (j *jsonFilter) loadRCFiles(h interp.HandlerContext) (string, error) {
+	if j.noRCFile {
+		return "", nil
+	}
+	return loadRCFiles(h, j.root)
+}
+
+// compileUserQuery is compileQuery for a query given to a builtin, with the
+// definitions in the rc files prepended to it unless -no-rcfile was given to
+// sensu-sh. Queries that a builtin supplies itself use compileQuery.
+func (p *Prog) compileUserQuery(ctx context.Context, queryStr string, vars ...string) (*gojq.Code, error) {
+	if !p.noRCFile {
+		defs, err := loadRCFiles(interp.HandlerCtx(ctx), p.root)
+		if err != nil {
+			return nil, err
+		}
+		queryStr = prependDefs(defs, queryStr)
+	}
+	return compileQuery(ctx, queryStr, vars...)
+}
+
+// evalUserQuery is evalQuery for a query given to a builtin, with the
+// definitions in the rc files prepended to it as compileUserQuery does.
+func (p *Prog) evalUserQuery(ctx context.Context, queryStr string, input interface{}) ([]interface{}, error) {
+	code, err := p.compileUserQuery(ctx, queryStr)
+	if err != nil {
+		return nil, err
+	}
+	return evalCode(ctx, code, input)
+}
+
+// prependDefs returns queryStr with defs prepended to it. Any imports at the
+// start of queryStr are moved ahead of defs, since jq requires imports to
+// precede definitions.
+func prependDefs(defs, queryStr string) string {
+	if defs == "" {
+		return queryStr
+	}
+	query, err := gojq.Parse(queryStr)
+	if err != nil || len(query.Imports) == 0 {
+		// Errors are reported when the query is compiled.
+		return defs + "\n" + queryStr
+	}
+	var imports strings.Builder
+	for _, imp := range query.Imports {
+		imports.WriteString(imp.String())
+	}
+	query.Imports = nil
+	return imports.String() + defs + "\n" + query.String()
+}
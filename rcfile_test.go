@@ -0,0 +1,81 @@
+package main
+
+import "testing"
+
+func TestPrependDefs(t *testing.T) {
+	cases := []struct {
+		name, defs, query, want string
+	}{
+		{"no defs", "", ".a", ".a"},
+		{"defs", "def f: 1;", ".a", "def f: 1;\n.a"},
+		{"imports", "def f: 1;", `import "m" as m; .a`, "import \"m\" as m;\ndef f: 1;\n.a"},
+		{"parse error", "def f: 1;", ".[", "def f: 1;\n.["},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := prependDefs(c.defs, c.query); got != c.want {
+				t.Errorf("prependDefs(%q, %q) = %q; want %q", c.defs, c.query, got, c.want)
+			}
+		})
+	}
+}
+
+func TestRCFiles(t *testing.T) {
+	home, dir, empty, bad := tempDir(t), tempDir(t), tempDir(t), tempDir(t)
+	writeFile(t, home, rcFileName, `def sev: "home"; def fromhome: "home only";`)
+	writeFile(t, dir, rcFileName, `def sev: ["ok", "warning", "critical"][.check.status // 0];`)
+	writeFile(t, bad, rcFileName, `def sev: ;`)
+
+	in := func(dir, script string) string { return "cd " + dir + "\n" + script }
+	cases := []scriptCase{
+		{name: "event", script: in(dir, `event sev`), want: "warning"},
+		{name: "home", script: in(dir, `event -n fromhome`), want: "home only"},
+		{name: "home only", script: in(empty, `event -n sev`), want: "home"},
+		{name: "query", script: in(dir, `doc='{"check": {"status": 2}}'`+"\nquery sev doc"), want: "critical"},
+		{name: "command option", script: in(dir, `event -no-rcfile sev`), status: 1, wantErr: "function not defined: sev/0"},
+		{name: "defs", script: in(dir, `echo 'def extra: sev + "!";' > defs.jq`+"\nevent -defs defs.jq extra"), want: "warning!"},
+		{name: "count-by", script: in(dir, `event -ndjson . | query -count-by sev . -`), want: `{"warning":1}`},
+		{name: "split-by", script: in(dir, `event -split-by sev -split-dir split '{check}' && cat split/warning.jsonl`), want: `{"check":{"metadata":{"name":"disk"},"status":1}}` + "\n"},
+		{name: "assert-count", script: in(dir, `assert-count event 'sev | select(. == "warning")' -eq 1`), want: ""},
+		{name: "assign", script: in(dir, "assign x sev\necho $x"), want: "warning\n"},
+		{name: "@var=", script: in(dir, "doc='{\"check\": {\"status\": 2}}'\n@doc= sev\necho $doc"), want: `"critical"` + "\n"},
+		{name: "event set", script: in(dir, "event set '.severity = sev'\nevent .severity"), want: "warning"},
+		{name: "decide", script: in(dir, `decide -crit 'sev == "warning"'`), want: "CRITICAL: sev == \"warning\"\n", status: 2},
+		{name: "bad rc file", script: in(bad, `event .`), status: 1, wantErr: "unable to parse rc file"},
+		{name: "bad rc file builtin", script: in(bad, `assign x .`), status: 1, wantErr: "unable to parse rc file"},
+		{name: "bad rc file skipped", script: in(bad, `event -no-rcfile -n 1`), want: "1"},
+	}
+	runScriptCases(t, cases, nil, "HOME="+home)
+}
+
+func TestNoRCFile(t *testing.T) {
+	dir := tempDir(t)
+	writeFile(t, dir, rcFileName, `def sev: "crit";`)
+	in := func(script string) string { return "cd " + dir + "\n" + script }
+	runScriptCases(t, []scriptCase{
+		{name: "event", script: in(`event -n sev`), status: 1, wantErr: "function not defined: sev/0"},
+		{name: "query", script: in(`query -n sev`), status: 1, wantErr: "function not defined: sev/0"},
+		{name: "count-by", script: in(`event -ndjson . | query -count-by sev . -`), status: 1, wantErr: "function not defined: sev/0"},
+		{name: "assign", script: in(`assign x sev`), status: 1, wantErr: "function not defined: sev/0"},
+		{name: "@var=", script: in("doc=1\n@doc= sev"), status: 1, wantErr: "function not defined: sev/0"},
+		{name: "without rc file", script: in(`event -n 1`), want: "1"},
+	}, func(p *Prog) {
+		p.noRCFile = true
+	}, "HOME="+tempDir(t))
+}
+
+func TestRCFileOutsideRoot(t *testing.T) {
+	home, dir := tempDir(t), tempDir(t)
+	writeFile(t, home, rcFileName, `def sev: "home";`)
+	writeFile(t, dir, rcFileName, `def local: "local";`)
+	runScriptCases(t, []scriptCase{
+		{name: "home skipped", script: "cd " + dir + "\nevent -n sev", status: 1, wantErr: "function not defined: sev/0"},
+		{name: "local loaded", script: "cd " + dir + "\nevent -n local", want: "local"},
+	}, func(p *Prog) {
+		root, err := newFSRoot(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.root = root
+	}, "HOME="+home)
+}
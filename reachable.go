@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// reachable implements the reachable builtin. It queries the event for an
+// address and attempts a TCP connection to it, exiting with a critical status
+// if the connection fails:
+//
+//	reachable [options] QUERY
+func (p *Prog) reachable(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "reachable")
+	f := flag.NewFlagSet("reachable", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	port := 0
+	timeout := 5 * time.Second
+	// -p, -port
+	f.IntVar(&port, "p", port, "The TCP `port` to connect to. (long: -port)")
+	f.IntVar(&port, "port", port, "The TCP `port` to connect to. (short: -p)")
+	// -t, -timeout
+	f.DurationVar(&timeout, "t", timeout, "How long to wait for a connection. (long: -timeout)")
+	f.DurationVar(&timeout, "timeout", timeout, "How long to wait for a connection. (short: -t)")
+
+	pos, err := parseArgs(f, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(statusUnknown)
+	}
+
+	if len(pos) != 1 {
+		logger.Printf("expected a query")
+		return interp.NewExitStatus(statusUnknown)
+	} else if port <= 0 || port > 65535 {
+		logger.Printf("a port between 1 and 65535 is required")
+		return interp.NewExitStatus(statusUnknown)
+	}
+
+	vals, err := p.evalUserQuery(ctx, pos[0], p.currentEvent())
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(statusUnknown)
+	} else if len(vals) != 1 {
+		logger.Printf("query must produce exactly one value, got %d", len(vals))
+		return interp.NewExitStatus(statusUnknown)
+	}
+
+	host, ok := vals[0].(string)
+	if !ok || host == "" {
+		logger.Printf("query result is not an address: %v", vals[0])
+		return interp.NewExitStatus(statusUnknown)
+	}
+	// Entity addresses are given in CIDR notation.
+	if i := strings.IndexByte(host, '/'); i != -1 {
+		host = host[:i]
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		fmt.Fprintf(h.Stdout, "CRITICAL: %s is unreachable: %v\n", addr, err)
+		return interp.NewExitStatus(statusCritical)
+	}
+	conn.Close()
+
+	fmt.Fprintf(h.Stdout, "OK: %s is reachable\n", addr)
+	return nil
+}
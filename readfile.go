@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// readFileCmd implements the readfile builtin. It queries each JSON or YAML
+// document in a file, or the file's contents as a string with -raw-input:
+//
+//	readfile [options] PATH [query]
+func (p *Prog) readFileCmd(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "readfile")
+	f := flag.NewFlagSet("readfile", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	rawInput := false
+	// -R, -raw-input
+	f.BoolVar(&rawInput, "R", rawInput, "Read the file as a string. (long: -raw-input)")
+	f.BoolVar(&rawInput, "raw-input", rawInput, "Read the file as a string. (short: -R)")
+
+	filter := p.newJSONFilter(logger)
+	filter.bind(f)
+	// -strict-numbers
+	f.BoolVar(&filter.strictNumbers, "strict-numbers", filter.strictNumbers, "Decode the file as JSON, preserving the precision of numbers.")
+	defer filter.close()
+
+	pos, err := parseArgs(f, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	queryStr := "."
+	switch len(pos) {
+	case 2:
+		queryStr = pos[1]
+	case 1:
+	default:
+		logger.Printf("expected a file and optional query")
+		return interp.NewExitStatus(1)
+	}
+	queryStr, err = filter.loadQuery(h, queryStr, len(pos) == 2)
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	path := handlerPath(h, pos[0])
+	r, err := openFile(p.root, path)
+	if err != nil {
+		logger.Printf("error opening [%s]: %v", pos[0], err)
+		return interp.NewExitStatus(1)
+	}
+	defer r.Close()
+
+	var docs []interface{}
+	if rawInput {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			logger.Printf("error reading [%s]: %v", pos[0], err)
+			return interp.NewExitStatus(1)
+		}
+		docs = append(docs, string(data))
+	} else {
+		or := newOffsetReader(r)
+		dec := newDecoder(or, filter.strictNumbers)
+		for {
+			var doc interface{}
+			if err := dec.Decode(&doc); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				logger.Printf("error decoding [%s]: %v", pos[0], or.annotate(err))
+				return interp.NewExitStatus(1)
+			}
+			docs = append(docs, doc)
+		}
+	}
+
+	query, err := filter.compile(ctx, queryStr)
+	if err != nil {
+		return err
+	}
+	if filter.slurp {
+		docs = []interface{}{docs}
+	}
+	for _, doc := range docs {
+		if err := filter.run(ctx, query, doc); err != nil {
+			return err
+		}
+	}
+	return filter.finish(ctx)
+}
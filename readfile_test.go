@@ -0,0 +1,43 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestReadFile(t *testing.T) {
+	dir := tempDir(t)
+	outside := tempDir(t)
+	writeFile(t, dir, "event.json", `{"check": {"status": 2, "output": "disk full"}}`)
+	writeFile(t, dir, "hosts.yaml", "name: web-1\n---\nname: web-2\n")
+	writeFile(t, dir, "notes.txt", "line 1\nline 2\n")
+	writeFile(t, dir, "bad.json", `{"a": [1, 2}`)
+	writeFile(t, dir, "big.json", `{"n": 12345678901234567890}`)
+	writeFile(t, outside, "secret.json", `{}`)
+
+	cases := []scriptCase{
+		{name: "json", script: `readfile event.json .check.status`, want: "2"},
+		{name: "default query", script: `readfile -ndjson event.json`, want: `{"check":{"output":"disk full","status":2}}` + "\n"},
+		{name: "yaml documents", script: `readfile hosts.yaml .name`, want: "web-1\nweb-2"},
+		{name: "slurp", script: `readfile -s hosts.yaml 'map(.name) | join(",")'`, want: "web-1,web-2"},
+		{name: "raw", script: `readfile -R notes.txt 'split("\n") | length'`, want: "3"},
+		{name: "raw long", script: `readfile -raw-input notes.txt .`, want: "line 1\nline 2\n"},
+		{name: "strict numbers", script: `readfile -strict-numbers big.json .n`, want: "12345678901234567890"},
+		{name: "absolute path", script: `readfile ` + filepath.Join(dir, "event.json") + ` .check.output`, want: "disk full"},
+		{name: "missing", script: `readfile missing.json`, status: 1, wantErr: "error opening [missing.json]"},
+		{name: "decode error", script: `readfile bad.json`, status: 1, wantErr: "error decoding [bad.json]"},
+		{name: "outside root", script: `readfile ` + filepath.Join(outside, "secret.json"), status: 1, wantErr: "outside of the root"},
+		{name: "no file", script: `readfile`, status: 1, wantErr: "expected a file and optional query"},
+		{name: "query error", script: `readfile event.json 'error("x")'`, status: 1, wantErr: "query error"},
+	}
+	for i := range cases {
+		cases[i].script = "cd " + dir + "\n" + cases[i].script
+	}
+	runScriptCases(t, cases, func(p *Prog) {
+		root, err := newFSRoot(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.root = root
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// redactMask replaces secret values in redacted output.
+const redactMask = "***"
+
+// redactSecrets implements the redact-secrets builtin. It queries the event and
+// masks any occurrence of the values of the named environment variables in
+// the results:
+//
+//	redact-secrets [options] [query]
+func (p *Prog) redactSecrets(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "redact-secrets")
+	f := flag.NewFlagSet("redact-secrets", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	var names stringsFlag
+	// -e, -env
+	f.Var(&names, "e", "Name of an environment variable holding a secret. May be repeated. (long: -env)")
+	f.Var(&names, "env", "Name of an environment variable holding a secret. May be repeated. (short: -e)")
+
+	filter := p.newJSONFilter(logger)
+	filter.bind(f)
+	defer filter.close()
+
+	if err := f.Parse(pairArgs(f, args[1:])); errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	queryStr := "."
+	if f.NArg() == 1 {
+		queryStr = f.Arg(0)
+	} else if f.NArg() > 1 {
+		logger.Printf("too many arguments to redact-secrets: expected 0..1")
+		return interp.NewExitStatus(1)
+	}
+	queryStr, err := filter.loadQuery(h, queryStr, f.NArg() == 1)
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	var secrets []string
+	for _, name := range names {
+		if v := h.Env.Get(name); v.IsSet() && v.String() != "" {
+			secrets = append(secrets, v.String())
+		}
+	}
+	// Mask longer secrets first so that a secret containing another is
+	// masked in its entirety.
+	sort.Slice(secrets, func(i, k int) bool { return len(secrets[i]) > len(secrets[k]) })
+
+	filter.transforms = append(filter.transforms, func(val interface{}) (interface{}, error) {
+		return redactValue(val, secrets)
+	})
+
+	query, err := filter.compile(ctx, queryStr)
+	if err != nil {
+		return err
+	}
+	if err := filter.run(ctx, query, p.currentEvent()); err != nil {
+		return err
+	}
+	return filter.finish(ctx)
+}
+
+// redactValue returns a copy of val with every occurrence of each secret in
+// its strings and object keys replaced by redactMask. Numbers whose JSON form
+// contains a secret are replaced by that form, masked, as a string. It is an
+// error for two keys of an object to be the same once masked.
+func redactValue(val interface{}, secrets []string) (interface{}, error) {
+	if len(secrets) == 0 {
+		return val, nil
+	}
+
+	switch val := val.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for _, k := range sortedKeys(val) {
+			v, err := redactValue(val[k], secrets)
+			if err != nil {
+				return nil, err
+			}
+			rk := redactString(k, secrets)
+			if _, ok := m[rk]; ok {
+				return nil, fmt.Errorf("redacted keys collide: %q", rk)
+			}
+			m[rk] = v
+		}
+		return m, nil
+	case []interface{}:
+		s := make([]interface{}, len(val))
+		for i, v := range val {
+			var err error
+			if s[i], err = redactValue(v, secrets); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	case string:
+		return redactString(val, secrets), nil
+	case nil, bool:
+		return val, nil
+	default:
+		str, err := plainString(val)
+		if err != nil {
+			return nil, err
+		}
+		if masked := redactString(str, secrets); masked != str {
+			return masked, nil
+		}
+		return val, nil
+	}
+}
+
+// redactString returns str with every occurrence of each secret replaced by
+// redactMask.
+func redactString(str string, secrets []string) string {
+	for _, secret := range secrets {
+		str = strings.ReplaceAll(str, secret, redactMask)
+	}
+	return str
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRedactValue(t *testing.T) {
+	secrets := []string{"hunter2", "1234"}
+	cases := []struct {
+		name string
+		in   interface{}
+		want interface{}
+		err  bool
+	}{
+		{"string", "pw=hunter2;", "pw=***;", false},
+		{"unmatched", "public", "public", false},
+		{"null", nil, nil, false},
+		{"bool", true, true, false},
+		{"int", 912345, "9***5", false},
+		{"float", 1.5, 1.5, false},
+		{"big", new(big.Int).SetUint64(1<<64 - 1), new(big.Int).SetUint64(1<<64 - 1), false},
+		{"key", map[string]interface{}{"hunter2": "x"}, map[string]interface{}{"***": "x"}, false},
+		{"nested", []interface{}{map[string]interface{}{"a": []interface{}{"hunter2", 1234}}}, []interface{}{map[string]interface{}{"a": []interface{}{"***", "***"}}}, false},
+		{"collision", map[string]interface{}{"hunter2": 1, "***": 2}, nil, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := redactValue(c.in, secrets)
+			if c.err {
+				if err == nil {
+					t.Fatalf("redactValue(%v) = %v; want an error", c.in, got)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("redactValue(%v): %v", c.in, err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("redactValue(%v) = %#v; want %#v", c.in, got, c.want)
+			}
+		})
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{
+			name:   "value",
+			script: `redact-secrets -e TOKEN -ndjson '{token: "tok-s3cret", n: 1}'`,
+			want:   `{"n":1,"token":"tok-***"}` + "\n",
+		},
+		{
+			name:   "key",
+			script: `redact-secrets -e TOKEN -ndjson '{"s3cret": 1}'`,
+			want:   `{"***":1}` + "\n",
+		},
+	}, nil, "TOKEN=s3cret")
+}
+
+// TestRedactSecretsFilterCmd checks that a filter command is only ever given
+// redacted results.
+func TestRedactSecretsFilterCmd(t *testing.T) {
+	dir := tempDir(t)
+	seen := filepath.Join(dir, "seen")
+	p := &Prog{event: testEvent()}
+	stdout, stderr, status := runScript(t, p, `redact-secrets -e TOKEN -filter-cmd "tee `+seen+`" -ndjson '{token: "s3cret"}'`, "TOKEN=s3cret")
+	if status != 0 {
+		t.Fatalf("status = %d; stderr: %s", status, stderr)
+	}
+	want := `{"token":"***"}` + "\n"
+	if stdout != want {
+		t.Errorf("stdout = %q; want %q", stdout, want)
+	}
+	if got, err := ioutil.ReadFile(seen); err != nil {
+		t.Fatal(err)
+	} else if string(got) != want {
+		t.Errorf("filter command input = %q; want %q", got, want)
+	}
+}
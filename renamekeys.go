@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// keyCases maps the cases accepted by rename-keys -to to their conversions.
+var keyCases = map[string]func(string) string{
+	"snake": snakeCase,
+	"camel": camelCase,
+}
+
+// renameKeys implements the rename-keys builtin. It queries a source and
+// prints the results with every object key converted to snake_case or
+// camelCase:
+//
+//	rename-keys [options] SOURCE [query]
+func (p *Prog) renameKeys(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "rename-keys")
+	f := flag.NewFlagSet("rename-keys", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	to := "snake"
+	f.StringVar(&to, "to", to, "Convert keys to `case`: snake or camel.")
+
+	filter := p.newJSONFilter(logger)
+	filter.bind(f)
+	defer filter.close()
+
+	pos, err := parseArgs(f, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	queryStr := "."
+	switch len(pos) {
+	case 2:
+		queryStr = pos[1]
+	case 1:
+	default:
+		logger.Printf("expected a source and optional query")
+		return interp.NewExitStatus(1)
+	}
+	queryStr, err = filter.loadQuery(h, queryStr, len(pos) == 2)
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	convert, ok := keyCases[to]
+	if !ok {
+		logger.Printf("invalid case %q: expected snake or camel", to)
+		return interp.NewExitStatus(1)
+	}
+
+	docs, err := p.inputs(ctx, pos[0])
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	filter.transforms = append(filter.transforms, func(val interface{}) (interface{}, error) {
+		return renameValue(val, convert)
+	})
+
+	query, err := filter.compile(ctx, queryStr)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if err := filter.run(ctx, query, doc); err != nil {
+			return err
+		}
+	}
+	return filter.finish(ctx)
+}
+
+// renameValue returns a copy of val with the keys of every object in it
+// replaced by the result of convert. It is an error for two keys of an object
+// to convert to the same key.
+func renameValue(val interface{}, convert func(string) string) (interface{}, error) {
+	switch val := val.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		from := make(map[string]string, len(val))
+		for k, v := range val {
+			nk := convert(k)
+			if prev, ok := from[nk]; ok {
+				return nil, fmt.Errorf("keys %q and %q both convert to %q", prev, k, nk)
+			}
+			from[nk] = k
+
+			v, err := renameValue(v, convert)
+			if err != nil {
+				return nil, err
+			}
+			m[nk] = v
+		}
+		return m, nil
+	case []interface{}:
+		s := make([]interface{}, len(val))
+		for i, v := range val {
+			v, err := renameValue(v, convert)
+			if err != nil {
+				return nil, err
+			}
+			s[i] = v
+		}
+		return s, nil
+	default:
+		return val, nil
+	}
+}
+
+// snakeCase converts a camelCase key to snake_case. Runs of capitals are
+// treated as a single word, so "HTTPServer" becomes "http_server".
+func snakeCase(key string) string {
+	rs := []rune(key)
+	var sb strings.Builder
+	for i, r := range rs {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := rs[i-1]
+			nextLower := i+1 < len(rs) && unicode.IsLower(rs[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				sb.WriteByte('_')
+			}
+		}
+		sb.WriteRune(unicode.ToLower(r))
+	}
+	return sb.String()
+}
+
+// camelCase converts a snake_case key to camelCase. Leading underscores are
+// kept as-is.
+func camelCase(key string) string {
+	trimmed := strings.TrimLeft(key, "_")
+	var sb strings.Builder
+	sb.WriteString(key[:len(key)-len(trimmed)])
+	for i, word := range strings.Split(trimmed, "_") {
+		if i == 0 || word == "" {
+			sb.WriteString(word)
+			continue
+		}
+		rs := []rune(word)
+		rs[0] = unicode.ToUpper(rs[0])
+		sb.WriteString(string(rs))
+	}
+	return sb.String()
+}
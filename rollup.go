@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"math"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// statusSeverity ranks check statuses from least to most severe. Statuses
+// other than OK, warning, and critical are treated as unknown.
+var statusSeverity = []int{statusOK, statusWarning, statusUnknown, statusCritical}
+
+// rollup implements the rollup builtin. It queries the event for the statuses
+// of sub-checks and exits with an aggregate status:
+//
+//	rollup [options] QUERY
+func (p *Prog) rollup(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "rollup")
+	f := flag.NewFlagSet("rollup", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	var critIfAny, worst, majority bool
+	f.BoolVar(&critIfAny, "critical-if-any", false, "Exit with a critical status if any status is not OK.")
+	f.BoolVar(&worst, "worst", false, "Exit with the most severe status. (default)")
+	f.BoolVar(&majority, "majority", false, "Exit with the most severe status shared or exceeded by a majority of statuses.")
+
+	pos, err := parseArgs(f, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(statusUnknown)
+	}
+
+	if len(pos) != 1 {
+		logger.Printf("expected a query")
+		return interp.NewExitStatus(statusUnknown)
+	}
+
+	modes := 0
+	for _, set := range []bool{critIfAny, worst, majority} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		logger.Printf("only one of -critical-if-any, -worst, or -majority may be given")
+		return interp.NewExitStatus(statusUnknown)
+	}
+
+	vals, err := p.evalUserQuery(ctx, pos[0], p.currentEvent())
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(statusUnknown)
+	} else if len(vals) == 0 {
+		logger.Printf("query produced no statuses")
+		return interp.NewExitStatus(statusUnknown)
+	}
+
+	// counts holds the number of statuses at each severity.
+	counts := make([]int, len(statusSeverity))
+	for _, val := range vals {
+		num, ok := toFloat(val)
+		if !ok || num != math.Trunc(num) {
+			logger.Printf("status is not an integer: %v", val)
+			return interp.NewExitStatus(statusUnknown)
+		}
+		counts[severity(int(num))]++
+	}
+
+	status := statusOK
+	switch {
+	case critIfAny:
+		if counts[severity(statusOK)] < len(vals) {
+			status = statusCritical
+		}
+	case majority:
+		atLeast := 0
+		for i := len(counts) - 1; i >= 0; i-- {
+			if atLeast += counts[i]; atLeast*2 > len(vals) {
+				status = statusSeverity[i]
+				break
+			}
+		}
+	default:
+		for i := len(counts) - 1; i >= 0; i-- {
+			if counts[i] > 0 {
+				status = statusSeverity[i]
+				break
+			}
+		}
+	}
+
+	if status == statusOK {
+		return nil
+	}
+	return interp.NewExitStatus(uint8(status))
+}
+
+// severity returns the index of status in statusSeverity.
+func severity(status int) int {
+	for i, s := range statusSeverity {
+		if s == status {
+			return i
+		}
+	}
+	return severity(statusUnknown)
+}
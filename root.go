@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+var (
+	errOutsideRoot  = errors.New("path is outside of the root directory")
+	errTooManyLinks = errors.New("too many levels of symbolic links")
+)
+
+// fsRoot is a directory that file access is restricted to, given by -root. The
+// empty fsRoot permits access to any path.
+type fsRoot string
+
+// newFSRoot returns the fsRoot for dir, which must be a directory. Symlinks in
+// dir are resolved.
+func newFSRoot(dir string) (fsRoot, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if abs, err = filepath.EvalSymlinks(abs); err != nil {
+		return "", err
+	}
+	if fi, err := os.Stat(abs); err != nil {
+		return "", err
+	} else if !fi.IsDir() {
+		return "", &os.PathError{Op: "root", Path: dir, Err: errors.New("not a directory")}
+	}
+	return fsRoot(abs), nil
+}
+
+// resolve returns path with any symlinks resolved, or an *os.PathError if it
+// is outside of the root. Paths that do not exist yet are resolved as far as
+// they do exist, so that files may be created, while symlinks are followed
+// whether or not their targets exist.
+func (r fsRoot) resolve(path string) (string, error) {
+	if r == "" {
+		return path, nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := resolveLinks(abs)
+	if err != nil {
+		return "", &os.PathError{Op: "open", Path: path, Err: err}
+	}
+
+	rel, err := filepath.Rel(string(r), resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &os.PathError{Op: "open", Path: path, Err: errOutsideRoot}
+	}
+	return resolved, nil
+}
+
+// resolveTarget is resolve for a file that is replaced rather than written in
+// place, such as by writeFileAtomic. Symlinks are resolved even if there is no
+// root, so that the file they point to is replaced instead of the symlink, and
+// the result is the path to use both to read and to replace the file.
+func (r fsRoot) resolveTarget(path string) (string, error) {
+	if r != "" {
+		return r.resolve(path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := resolveLinks(abs)
+	if err != nil {
+		return "", &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return resolved, nil
+}
+
+// maxLinks is the number of symlinks resolveLinks follows before giving up.
+const maxLinks = 255
+
+// resolveLinks returns the absolute path abs with every symlink in it
+// resolved, one component at a time, as opening it would. Unlike
+// filepath.EvalSymlinks, a symlink is followed even if its target does not
+// exist, so that the path a file would be created at is known. Components
+// that do not exist are kept as they are.
+func resolveLinks(abs string) (string, error) {
+	sep := string(filepath.Separator)
+	vol := filepath.VolumeName(abs)
+	resolved := vol + sep
+	rest := strings.Split(abs[len(vol):], sep)
+	for links := 0; len(rest) > 0; {
+		name := rest[0]
+		rest = rest[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, name)
+		fi, err := os.Lstat(next)
+		if errors.Is(err, os.ErrNotExist) || err == nil && fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		if links++; links > maxLinks {
+			return "", errTooManyLinks
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			vol = filepath.VolumeName(target)
+			resolved = vol + sep
+			target = target[len(vol):]
+		}
+		rest = append(strings.Split(target, sep), rest...)
+	}
+	return resolved, nil
+}
+
+// open opens the file at path for reading, as os.Open does, if it is inside
+// of the root.
+func (r fsRoot) open(path string) (*os.File, error) {
+	resolved, err := r.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(resolved)
+}
+
+// readFile reads the file at path, as ioutil.ReadFile does, if it is inside of
+// the root.
+func (r fsRoot) readFile(path string) ([]byte, error) {
+	resolved, err := r.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(resolved)
+}
+
+// openHandler returns an interp.OpenHandlerFunc that opens files for the
+// shell's redirections if they are inside of the root. /dev/null is always
+// permitted.
+func (r fsRoot) openHandler() interp.OpenHandlerFunc {
+	open := interp.DefaultOpenHandler()
+	return func(ctx context.Context, path string, flag int, perm os.FileMode) (io.ReadWriteCloser, error) {
+		if r == "" || path == os.DevNull {
+			return open(ctx, path, flag, perm)
+		}
+		h := interp.HandlerCtx(ctx)
+		resolved, err := r.resolve(handlerPath(h, path))
+		if err != nil {
+			return nil, err
+		}
+		return open(ctx, resolved, flag, perm)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFSRootResolve(t *testing.T) {
+	dir := tempDir(t)
+	outside := tempDir(t)
+	root, err := newFSRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, dir, "sub/file", "x")
+	writeFile(t, outside, "secret", "x")
+	for _, link := range []struct{ name, target string }{
+		{"inside", "sub/file"},
+		{"inside-abs", filepath.Join(dir, "sub")},
+		{"dangling-inside", "sub/new"},
+		{"outside", filepath.Join(outside, "secret")},
+		{"dangling-outside", filepath.Join(outside, "pwned")},
+		{"dangling-outside-dir", filepath.Join(outside, "missing", "pwned")},
+		{"relative-escape", "../" + filepath.Base(outside) + "/secret"},
+		{"dotdot", "sub/../.."},
+		{"chain", "dangling-outside"},
+		{"loop-a", "loop-b"},
+		{"loop-b", "loop-a"},
+		{"sub/up", ".."},
+	} {
+		if err := os.Symlink(link.target, filepath.Join(dir, link.name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name, path, want string
+		err              error
+	}{
+		{"file", "sub/file", "sub/file", nil},
+		{"missing", "sub/missing/file", "sub/missing/file", nil},
+		{"link", "inside", "sub/file", nil},
+		{"absolute link", "inside-abs/file", "sub/file", nil},
+		{"dangling link", "dangling-inside", "sub/new", nil},
+		{"link to parent", "sub/up/sub/file", "sub/file", nil},
+		{"dotdot inside", "sub/../sub/file", "sub/file", nil},
+		{"root", ".", ".", nil},
+		{"traversal", "../" + filepath.Base(outside) + "/secret", "", errOutsideRoot},
+		{"deep traversal", "sub/../../../../etc/passwd", "", errOutsideRoot},
+		{"absolute", filepath.Join(outside, "secret"), "", errOutsideRoot},
+		{"link outside", "outside", "", errOutsideRoot},
+		{"dangling link outside", "dangling-outside", "", errOutsideRoot},
+		{"dangling link outside dir", "dangling-outside-dir", "", errOutsideRoot},
+		{"relative link escape", "relative-escape", "", errOutsideRoot},
+		{"link dotdot escape", "dotdot/" + filepath.Base(outside) + "/secret", "", errOutsideRoot},
+		{"chained link", "chain", "", errOutsideRoot},
+		{"missing then link", "sub/missing/../../dangling-outside", "", errOutsideRoot},
+		{"loop", "loop-a", "", errTooManyLinks},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := c.path
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			got, err := root.resolve(path)
+			switch {
+			case c.err != nil:
+				if !errors.Is(err, c.err) {
+					t.Fatalf("resolve(%q) = %q, %v; want %v", c.path, got, err, c.err)
+				}
+			case err != nil:
+				t.Fatalf("resolve(%q): %v", c.path, err)
+			case got != filepath.Join(dir, c.want):
+				t.Errorf("resolve(%q) = %q; want %q", c.path, got, filepath.Join(dir, c.want))
+			}
+		})
+	}
+}
+
+// TestRootRedirections checks that shell redirections cannot read or create
+// files outside of the root, including through dangling symlinks.
+func TestRootRedirections(t *testing.T) {
+	dir := tempDir(t)
+	outside := tempDir(t)
+	writeFile(t, dir, "in.txt", "inside\n")
+	writeFile(t, outside, "secret", "secret\n")
+	if err := os.Symlink(filepath.Join(outside, "pwned"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret"), filepath.Join(dir, "secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	runScriptCases(t, []scriptCase{
+		{name: "read inside", script: "cd " + dir + "\nread -r x < in.txt; echo $x", want: "inside\n"},
+		{name: "write inside", script: "cd " + dir + "\necho hi > new.txt && cat < new.txt", want: "hi\n"},
+		{name: "traversal", script: "cd " + dir + "\nread -r x < ../" + filepath.Base(outside) + "/secret", status: 1, wantErr: "outside of the root"},
+		{name: "absolute", script: "read -r x < " + filepath.Join(outside, "secret"), status: 1, wantErr: "outside of the root"},
+		{name: "read through link", script: "cd " + dir + "\nread -r x < secret", status: 1, wantErr: "outside of the root"},
+		{name: "write through dangling link", script: "cd " + dir + "\necho hi > link", status: 1, wantErr: "outside of the root"},
+		{name: "append through dangling link", script: "cd " + dir + "\necho hi >> link", status: 1, wantErr: "outside of the root"},
+		{name: "dev null", script: "echo hi > /dev/null; echo ok", want: "ok\n"},
+	}, func(p *Prog) {
+		root, err := newFSRoot(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.root = root
+	})
+
+	if _, err := os.Lstat(filepath.Join(outside, "pwned")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("file created outside of the root through a dangling symlink: %v", err)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import "io"
+
+// seqWriter is a Writer that frames each JSON text written to it as an RFC
+// 7464 JSON text sequence, by writing a record separator (RS, 0x1E) before
+// it. As with asciiWriter, each text must be written in a single call, and is
+// already terminated by a newline.
+type seqWriter struct {
+	w io.Writer
+}
+
+func (s seqWriter) Write(p []byte) (int, error) {
+	buf := make([]byte, 0, len(p)+1)
+	buf = append(buf, '\x1e')
+	buf = append(buf, p...)
+	if _, err := s.w.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSeqWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := seqWriter{&buf}
+	for _, text := range []string{"1\n", "{\"a\":[1]}\n"} {
+		n, err := w.Write([]byte(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(text) {
+			t.Errorf("Write(%q) = %d; want %d", text, n, len(text))
+		}
+	}
+	if got, want := buf.String(), "\x1e1\n\x1e{\"a\":[1]}\n"; got != want {
+		t.Errorf("wrote %q; want %q", got, want)
+	}
+}
+
+func TestSeqOutput(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{name: "implies json", script: `event -seq '1, {"a": [1]}'`, want: "\x1e1\n\x1e{\"a\":[1]}\n"},
+		{name: "json", script: `event -seq -json '"a"'`, want: "\x1e\"a\"\n"},
+		{name: "ndjson", script: `event -seq -ndjson '1, 2'`, want: "\x1e1\n\x1e2\n"},
+		{name: "pretty", script: `event -seq -pretty '{"a": 1}, 2'`, want: "\x1e{\n  \"a\": 1\n}\n\x1e2\n"},
+		{name: "ascii", script: `event -seq -a '"é"'`, want: "\x1e\"\\u00e9\"\n"},
+		{name: "no results", script: `event -seq empty`, want: ""},
+		{name: "yaml", script: `event -seq -yaml 1`, status: 1, wantErr: "-seq cannot be combined with -yaml"},
+		{name: "plain", script: `event -seq -0 1`, status: 1, wantErr: "-seq cannot be combined with plain output options"},
+		{name: "join", script: `event -seq -join 1`, status: 1, wantErr: "-seq cannot be combined with plain output options"},
+	}, nil)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+)
+
+// shellMarkVar is the readonly indexed variable that marks the script's
+// top-level shell. Subshells, including pipeline stages and command
+// substitutions, receive deep copies of indexed variables, so only the
+// top-level shell holds the element recorded by newRunner.
+const shellMarkVar = "SENSU_SH_SHELL"
+
+// newRunner returns an interpreter that runs the builtins of p, with opts
+// applied after the environment and handlers, and marks its top-level shell.
+func (p *Prog) newRunner(opts ...interp.RunnerOption) (*interp.Runner, error) {
+	opts = append([]interp.RunnerOption{
+		interp.Env(p.defaultEnv),
+		interp.ExecHandler(p.exec),
+		interp.OpenHandler(p.root.openHandler()),
+	}, opts...)
+	r, err := interp.New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	// Run would otherwise reset the runner's variables.
+	r.Reset()
+	mark := []string{"top-level"}
+	r.Vars[shellMarkVar] = expand.Variable{ReadOnly: true, Kind: expand.Indexed, List: mark}
+	p.shellMark = &mark[0]
+	return r, nil
+}
+
+// topLevel reports whether env, the environment of a builtin, is that of the
+// script's top-level shell. Builtins run by the top-level shell are called in
+// turn by the interpreter, so only they may change state shared by the script,
+// such as its variables.
+func (p *Prog) topLevel(env expand.Environ) bool {
+	mark := env.Get(shellMarkVar)
+	return p.shellMark != nil && len(mark.List) > 0 && &mark.List[0] == p.shellMark
+}
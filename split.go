@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/itchyny/gojq"
+)
+
+// splitEncoder is an Encoder that writes each value as a line of JSON to a
+// file in dir. The file is named after the result of a key query run against
+// the value, with a ".jsonl" extension. Files are created, or truncated unless
+// append is set, on first use and remain open until the encoder is closed.
+// Both dir and its files must be inside of root.
+type splitEncoder struct {
+	ctx        context.Context
+	root       fsRoot
+	dir        string
+	key        *gojq.Code
+	bufferSize int
+	append     bool
+
+	encs    map[string]*json.Encoder
+	closers []io.Closer
+}
+
+func newSplitEncoder(ctx context.Context, root fsRoot, dir string, key *gojq.Code, bufferSize int, appendFiles bool) (*splitEncoder, error) {
+	resolved, err := root.resolve(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error creating split directory: %w", err)
+	}
+	if err := os.MkdirAll(resolved, 0777); err != nil {
+		return nil, fmt.Errorf("error creating split directory: %w", err)
+	}
+	return &splitEncoder{
+		ctx:        ctx,
+		root:       root,
+		dir:        dir,
+		key:        key,
+		bufferSize: bufferSize,
+		append:     appendFiles,
+		encs:       map[string]*json.Encoder{},
+	}, nil
+}
+
+func (s *splitEncoder) Encode(val interface{}) error {
+	name, err := s.name(val)
+	if err != nil {
+		return err
+	}
+
+	enc, ok := s.encs[name]
+	if !ok {
+		path := filepath.Join(s.dir, name+".jsonl")
+		flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if s.append {
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		resolved, err := s.root.resolve(path)
+		if err != nil {
+			return fmt.Errorf("error opening split output [%s]: %w", path, err)
+		}
+		f, err := os.OpenFile(resolved, flag, 0666)
+		if err != nil {
+			return fmt.Errorf("error opening split output [%s]: %w", path, err)
+		}
+		s.closers = append(s.closers, f)
+
+		var w io.Writer = f
+		if s.bufferSize > 0 {
+			bw := bufio.NewWriterSize(f, s.bufferSize)
+			s.closers = append(s.closers, flushCloser{bw})
+			w = bw
+		}
+
+		enc = json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		s.encs[name] = enc
+	}
+	return enc.Encode(val)
+}
+
+// name returns the file name, without extension, for val.
+func (s *splitEncoder) name(val interface{}) (string, error) {
+	iter := runQuery(s.ctx, s.key, val)
+	key, ok := iter.Next()
+	if !ok {
+		return "", errors.New("split key produced no value")
+	} else if err, ok := key.(error); ok {
+		return "", fmt.Errorf("split key error: %w", err)
+	} else if _, ok := iter.Next(); ok {
+		return "", errors.New("split key produced more than one value")
+	}
+
+	switch key.(type) {
+	case map[string]interface{}, []interface{}:
+		return "", fmt.Errorf("split key is not a scalar: %T", key)
+	}
+	str, err := plainString(key)
+	if err != nil {
+		return "", err
+	}
+	return splitFileName(str), nil
+}
+
+// Flush flushes the buffered output of every open file.
+func (s *splitEncoder) Flush() error {
+	for _, c := range s.closers {
+		if f, ok := c.(flushCloser); ok {
+			if err := f.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close flushes and closes every open file.
+func (s *splitEncoder) Close() error {
+	var first error
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		if err := s.closers[i].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	s.closers = nil
+	s.encs = map[string]*json.Encoder{}
+	return first
+}
+
+// splitFileName returns key with every character other than ASCII letters,
+// digits, '-', '_', and '.' replaced by '_', so that it is safe to use as a
+// file name. Keys that would be empty or refer to a directory are replaced by
+// "_".
+func splitFileName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, key)
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitFileName(t *testing.T) {
+	cases := []struct {
+		key, want string
+	}{
+		{"web-1", "web-1"},
+		{"a.b_c", "a.b_c"},
+		{"a/b", "a_b"},
+		{"../etc", ".._etc"},
+		{"café", "caf_"},
+		{"", "_"},
+		{".", "_"},
+		{"..", "_"},
+	}
+	for _, c := range cases {
+		if got := splitFileName(c.key); got != c.want {
+			t.Errorf("splitFileName(%q) = %q; want %q", c.key, got, c.want)
+		}
+	}
+}
+
+func TestSplitOutput(t *testing.T) {
+	dir := tempDir(t)
+	outside := tempDir(t)
+	writeFile(t, dir, "old/1.jsonl", "stale\n")
+	writeFile(t, dir, "kept/1.jsonl", "{\"n\":0}\n")
+	if err := os.Symlink(outside, filepath.Join(dir, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "1.jsonl"), filepath.Join(dir, "kept", "2.jsonl")); err != nil {
+		t.Fatal(err)
+	}
+
+	const emit = `'{n: 1}, {n: 2}, {n: 1}'`
+	cases := []scriptCase{
+		{name: "create", script: `event -split-by .n -split-dir new/out ` + emit + ` && cat new/out/1.jsonl new/out/2.jsonl`, want: "{\"n\":1}\n{\"n\":1}\n{\"n\":2}\n"},
+		{name: "truncate", script: `event -split-by .n -split-dir old ` + emit + ` && cat old/1.jsonl`, want: "{\"n\":1}\n{\"n\":1}\n"},
+		{name: "append", script: `event -append -split-by .n -split-dir kept '{n: 1}' && cat kept/1.jsonl`, want: "{\"n\":0}\n{\"n\":1}\n"},
+		{name: "key", script: `event -split-by '"../" + (.n | tostring)' -split-dir keys ` + emit + ` && cat keys/.._1.jsonl`, want: "{\"n\":1}\n{\"n\":1}\n"},
+		{name: "dir outside root", script: `event -split-by .n -split-dir ` + filepath.Join(outside, "out") + ` ` + emit, status: 1, wantErr: "outside of the root"},
+		{name: "dir through link", script: `event -split-by .n -split-dir escape/out ` + emit, status: 1, wantErr: "outside of the root"},
+		{name: "file through link", script: `event -split-by .n -split-dir kept '{n: 2}'`, status: 1, wantErr: "outside of the root"},
+		{name: "no dir", script: `event -split-by .n ` + emit, status: 1, wantErr: "-split-by requires -split-dir"},
+		{name: "output", script: `event -split-by .n -split-dir x -o y ` + emit, status: 1, wantErr: "-split-by cannot be combined with -output"},
+	}
+	for i := range cases {
+		cases[i].script = "cd " + dir + "\n" + cases[i].script
+	}
+	runScriptCases(t, cases, func(p *Prog) {
+		root, err := newFSRoot(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.root = root
+	})
+
+	for _, name := range []string{"out", "1.jsonl"} {
+		if _, err := os.Lstat(filepath.Join(outside, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was created outside of the root: %v", name, err)
+		}
+	}
+}
+
+func TestOutputFile(t *testing.T) {
+	dir := tempDir(t)
+	outside := tempDir(t)
+	writeFile(t, dir, "old.json", "stale\n")
+	writeFile(t, dir, "log.json", "0\n")
+	if err := os.Symlink(filepath.Join(outside, "out.json"), filepath.Join(dir, "escape.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []scriptCase{
+		{name: "create", script: `event -ndjson -o new.json '1, 2' && cat new.json`, want: "1\n2\n"},
+		{name: "truncate", script: `event -ndjson -o old.json 1 && cat old.json`, want: "1\n"},
+		{name: "append", script: `event -ndjson -append -o log.json 1 && event -ndjson -append -o log.json 2 && cat log.json`, want: "0\n1\n2\n"},
+		{name: "stdout", script: `event -ndjson -o - 1`, want: "1\n"},
+		{name: "append without output", script: `event -append 1`, status: 1, wantErr: "-append requires -output"},
+		{name: "outside root", script: `event -o ` + filepath.Join(outside, "out.json") + ` 1`, status: 1, wantErr: "outside of the root"},
+		{name: "through link", script: `event -o escape.json 1`, status: 1, wantErr: "outside of the root"},
+	}
+	for i := range cases {
+		cases[i].script = "cd " + dir + "\n" + cases[i].script
+	}
+	runScriptCases(t, cases, func(p *Prog) {
+		root, err := newFSRoot(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.root = root
+	})
+
+	if _, err := os.Lstat(filepath.Join(outside, "out.json")); !os.IsNotExist(err) {
+		t.Errorf("out.json was created outside of the root: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import "fmt"
+
+// streamDecoder is a Decoder that decomposes each document decoded by dec into
+// the events of jq's streaming form, as -stream does, and decodes them one at
+// a time. See streamEvents.
+type streamDecoder struct {
+	dec    Decoder
+	events []interface{}
+}
+
+func newStreamDecoder(dec Decoder) *streamDecoder {
+	return &streamDecoder{dec: dec}
+}
+
+// Decode decodes the next event into v, which must be a *interface{}.
+func (s *streamDecoder) Decode(v interface{}) error {
+	for len(s.events) == 0 {
+		var doc interface{}
+		if err := s.dec.Decode(&doc); err != nil {
+			return err
+		}
+		s.events = streamEvents(doc)
+	}
+
+	ev, ok := v.(*interface{})
+	if !ok {
+		return fmt.Errorf("cannot decode into %T", v)
+	}
+	*ev, s.events = s.events[0], s.events[1:]
+	return nil
+}
+
+// streamEvents returns the events of jq's streaming form of val, as produced
+// by jq --stream and tostream:
+//
+//   - Each scalar, empty array, and empty object is a leaf, and produces
+//     [PATH, LEAF], where PATH is the array of keys and indices leading to it.
+//   - Each non-empty array and object is closed by [PATH], where PATH is the
+//     path of its last element. Object keys are in sorted order.
+//
+// For example, {"a":[1,{"b":2}]} produces [["a",0],1], [["a",1,"b"],2],
+// [["a",1,"b"]], [["a",1]], and [["a"]].
+func streamEvents(val interface{}) []interface{} {
+	var events []interface{}
+	var walk func(path []interface{}, val interface{})
+	leaf := func(path []interface{}, val interface{}) {
+		events = append(events, []interface{}{copyPath(path), val})
+	}
+	closing := func(path []interface{}) {
+		events = append(events, []interface{}{copyPath(path)})
+	}
+	walk = func(path []interface{}, val interface{}) {
+		switch val := val.(type) {
+		case []interface{}:
+			if len(val) == 0 {
+				leaf(path, val)
+				return
+			}
+			for i, elem := range val {
+				walk(append(path, i), elem)
+			}
+			closing(append(path, len(val)-1))
+		case map[string]interface{}:
+			if len(val) == 0 {
+				leaf(path, val)
+				return
+			}
+			keys := sortedKeys(val)
+			for _, k := range keys {
+				walk(append(path, k), val[k])
+			}
+			closing(append(path, keys[len(keys)-1]))
+		default:
+			leaf(path, val)
+		}
+	}
+	walk([]interface{}{}, val)
+	return events
+}
+
+// copyPath returns a copy of path, which may share its backing array with the
+// paths of other events.
+func copyPath(path []interface{}) []interface{} {
+	return append([]interface{}{}, path...)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/itchyny/gojq"
+)
+
+func TestStreamEvents(t *testing.T) {
+	cases := []struct {
+		name string
+		doc  string
+		// want is the output of jq -c --stream for doc.
+		want string
+	}{
+		{"scalar", `3`, `[[],3]`},
+		{"null", `null`, `[[],null]`},
+		{"empty array", `[]`, `[[],[]]`},
+		{"empty object", `{}`, `[[],{}]`},
+		{"array", `[1,"a"]`, `[[0],1] [[1],"a"] [[1]]`},
+		{"object", `{"a":1,"b":2}`, `[["a"],1] [["b"],2] [["b"]]`},
+		{"nested object", `{"a":{"b":[1,2],"c":null},"d":"x"}`,
+			`[["a","b",0],1] [["a","b",1],2] [["a","b",1]] [["a","c"],null] [["a","c"]] [["d"],"x"] [["d"]]`},
+		{"nested empty", `{"a":[],"b":[{}]}`, `[["a"],[]] [["b",0],{}] [["b",0]] [["b"]]`},
+		{"nested arrays", `[[1,[2]],3]`, `[[0,0],1] [[0,1,0],2] [[0,1,0]] [[0,1]] [[1],3] [[1]]`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var doc interface{}
+			if err := json.Unmarshal([]byte(c.doc), &doc); err != nil {
+				t.Fatal(err)
+			}
+			got := streamEvents(doc)
+			var want []interface{}
+			dec := json.NewDecoder(strings.NewReader(c.want))
+			for dec.More() {
+				var ev interface{}
+				if err := dec.Decode(&ev); err != nil {
+					t.Fatal(err)
+				}
+				want = append(want, normalizeStreamEvent(ev))
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("streamEvents(%s) = %v; want %v", c.doc, got, want)
+			}
+
+			// The events are also those of tostream.
+			var tostream []interface{}
+			query, err := gojq.Parse("tostream")
+			if err != nil {
+				t.Fatal(err)
+			}
+			iter := query.Run(doc)
+			for {
+				v, ok := iter.Next()
+				if !ok {
+					break
+				} else if err, ok := v.(error); ok {
+					t.Fatal(err)
+				}
+				tostream = append(tostream, normalizeStreamEvent(v))
+			}
+			if !reflect.DeepEqual(got, tostream) {
+				t.Errorf("streamEvents(%s) = %v; tostream = %v", c.doc, got, tostream)
+			}
+		})
+	}
+}
+
+// normalizeStreamEvent converts the float64 array indices of a decoded event
+// to the ints produced by streamEvents.
+func normalizeStreamEvent(ev interface{}) interface{} {
+	event := ev.([]interface{})
+	path := event[0].([]interface{})
+	for i, k := range path {
+		if f, ok := k.(float64); ok {
+			path[i] = int(f)
+		}
+	}
+	return event
+}
+
+func TestStreamDecoder(t *testing.T) {
+	dec := newStreamDecoder(json.NewDecoder(strings.NewReader(`{"a":1} [2]`)))
+	var got []interface{}
+	for {
+		var ev interface{}
+		err := dec.Decode(&ev)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, ev)
+	}
+	want := []interface{}{
+		[]interface{}{[]interface{}{"a"}, 1.0},
+		[]interface{}{[]interface{}{"a"}},
+		[]interface{}{[]interface{}{0}, 2.0},
+		[]interface{}{[]interface{}{0}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v; want %v", got, want)
+	}
+}
+
+func TestQueryStream(t *testing.T) {
+	const doc = `doc='{"a":{"b":[1,2],"c":null},"d":"x"}'` + "\n"
+	runScriptCases(t, []scriptCase{
+		{name: "events", script: doc + `query -stream -ndjson . doc`, want: `[["a","b",0],1]
+[["a","b",1],2]
+[["a","b",1]]
+[["a","c"],null]
+[["a","c"]]
+[["d"],"x"]
+[["d"]]
+`},
+		{name: "leaves", script: doc + `query -stream -ndjson 'select(length == 2) | .[1]' doc`, want: "1\n2\nnull\n\"x\"\n"},
+		{name: "fromstream", script: doc + `query -stream -n -ndjson 'fromstream(inputs)' doc`, want: `{"a":{"b":[1,2],"c":null},"d":"x"}` + "\n"},
+		{name: "truncate", script: doc + `query -stream -n -ndjson 'fromstream(1 | truncate_stream(inputs))' doc`, want: "{\"b\":[1,2],\"c\":null}\n"},
+		{name: "documents", script: `docs=$'1\n---\n[2]'` + "\n" + `query -stream -ndjson . docs`, want: "[[],1]\n[[0],2]\n[[0]]\n"},
+		{name: "raw input", script: `query -stream -R . doc`, status: 1, wantErr: "-stream cannot be combined"},
+		{name: "array stream", script: `query -stream -array-stream . doc`, status: 1, wantErr: "-stream cannot be combined"},
+	}, nil)
+}
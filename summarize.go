@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// ellipsis marks summarized text that was truncated.
+const ellipsis = "…"
+
+// summarize implements the summarize builtin. It queries the event for a
+// string and prints its first lines, folded onto a single line and truncated
+// to a maximum number of characters:
+//
+//	summarize [options] [query]
+func (p *Prog) summarize(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "summarize")
+	f := flag.NewFlagSet("summarize", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	maxLines, maxChars := 3, 200
+	sep := " "
+	f.IntVar(&maxLines, "max-lines", maxLines, "Keep at most `n` non-empty lines. Zero keeps all lines.")
+	f.IntVar(&maxChars, "max-chars", maxChars, "Keep at most `n` characters. Zero keeps all characters.")
+	// -s, -separator
+	f.StringVar(&sep, "s", sep, "Separator used to join lines. (long: -separator)")
+	f.StringVar(&sep, "separator", sep, "Separator used to join lines. (short: -s)")
+
+	pos, err := parseArgs(f, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	queryStr := ".check.output"
+	if len(pos) == 1 {
+		queryStr = pos[0]
+	} else if len(pos) > 1 {
+		logger.Printf("too many arguments to summarize: expected 0..1")
+		return interp.NewExitStatus(1)
+	}
+
+	vals, err := p.evalUserQuery(ctx, queryStr, p.currentEvent())
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	for _, val := range vals {
+		str, err := plainString(val)
+		if err != nil {
+			logger.Printf("error formatting value: %v", err)
+			return interp.NewExitStatus(1)
+		}
+		if _, err := fmt.Fprintln(h.Stdout, summarizeText(str, sep, maxLines, maxChars)); err != nil {
+			logger.Printf("error writing summary: %v", err)
+			return interp.NewExitStatus(1)
+		}
+	}
+	return nil
+}
+
+// summarizeText joins the first maxLines non-empty lines of text with sep and
+// truncates the result to maxChars characters. If anything is cut, the
+// result ends with an ellipsis. A limit of zero disables it.
+func summarizeText(text, sep string, maxLines, maxChars int) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	truncated := false
+	if maxLines > 0 && len(lines) > maxLines {
+		lines, truncated = lines[:maxLines], true
+	}
+
+	summary := []rune(strings.Join(lines, sep))
+	if maxChars > 0 {
+		// Leave room for the ellipsis if one is needed.
+		if len(summary) > maxChars || truncated && len(summary) >= maxChars {
+			summary, truncated = summary[:maxChars-1], true
+		}
+	}
+	if truncated {
+		return string(summary) + ellipsis
+	}
+	return string(summary)
+}
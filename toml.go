@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// tomlEncoder is an Encoder that writes objects as TOML documents. Documents
+// are separated by an empty line. Keys are written in sorted order, with the
+// values of each table preceding its sub-tables. Arrays whose elements are all
+// objects are written as arrays of tables; other objects in arrays are written
+// as inline tables.
+//
+// The encoder is written here rather than wrapping github.com/BurntSushi/toml,
+// which is not among the modules sensu-sh can be built with, and only writes
+// the types of query values. Its output for random objects is tested against
+// Python's tomllib when available.
+type tomlEncoder struct {
+	w       io.Writer
+	written bool
+}
+
+func newTOMLEncoder(w io.Writer) *tomlEncoder {
+	return &tomlEncoder{w: w}
+}
+
+func (t *tomlEncoder) Encode(val interface{}) error {
+	table, ok := val.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("TOML requires a table at the root, got %s", typeName(val))
+	}
+
+	var buf bytes.Buffer
+	if t.written {
+		buf.WriteByte('\n')
+	}
+	if err := tomlTable(&buf, nil, table, false); err != nil {
+		return err
+	}
+	t.written = true
+	_, err := t.w.Write(buf.Bytes())
+	return err
+}
+
+// tomlTable writes the table at path. Unless hasHeader is true, the table's
+// header is written if it holds any values or is empty.
+func tomlTable(buf *bytes.Buffer, path []string, table map[string]interface{}, hasHeader bool) error {
+	keys := make([]string, 0, len(table))
+	for k := range table {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var tables, arrays, plain []string
+	for _, k := range keys {
+		switch v := table[k].(type) {
+		case map[string]interface{}:
+			tables = append(tables, k)
+		case []interface{}:
+			if isTableArray(v) {
+				arrays = append(arrays, k)
+			} else {
+				plain = append(plain, k)
+			}
+		default:
+			plain = append(plain, k)
+		}
+	}
+
+	if len(path) > 0 && !hasHeader && (len(plain) > 0 || len(table) == 0) {
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		fmt.Fprintf(buf, "[%s]\n", tomlPath(path))
+	}
+	for _, k := range plain {
+		buf.WriteString(tomlKey(k))
+		buf.WriteString(" = ")
+		if err := tomlValue(buf, append(path, k), table[k]); err != nil {
+			return err
+		}
+		buf.WriteByte('\n')
+	}
+	for _, k := range tables {
+		sub := append(path[:len(path):len(path)], k)
+		if err := tomlTable(buf, sub, table[k].(map[string]interface{}), false); err != nil {
+			return err
+		}
+	}
+	for _, k := range arrays {
+		sub := append(path[:len(path):len(path)], k)
+		for _, elem := range table[k].([]interface{}) {
+			if buf.Len() > 0 {
+				buf.WriteByte('\n')
+			}
+			fmt.Fprintf(buf, "[[%s]]\n", tomlPath(sub))
+			if err := tomlTable(buf, sub, elem.(map[string]interface{}), true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// tomlValue writes val as an inline TOML value.
+func tomlValue(buf *bytes.Buffer, path []string, val interface{}) error {
+	switch val := val.(type) {
+	case nil:
+		return fmt.Errorf("TOML cannot represent null at %s", tomlPath(path))
+	case bool:
+		buf.WriteString(strconv.FormatBool(val))
+	case string:
+		buf.WriteString(tomlString(val))
+	case int:
+		buf.WriteString(strconv.Itoa(val))
+	case int64:
+		buf.WriteString(strconv.FormatInt(val, 10))
+	case uint64:
+		if val > math.MaxInt64 {
+			return fmt.Errorf("TOML cannot represent integer %d at %s", val, tomlPath(path))
+		}
+		buf.WriteString(strconv.FormatUint(val, 10))
+	case *big.Int:
+		if !val.IsInt64() {
+			return fmt.Errorf("TOML cannot represent integer %v at %s", val, tomlPath(path))
+		}
+		buf.WriteString(val.String())
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			buf.WriteString(strconv.FormatInt(i, 10))
+		} else if f, err := val.Float64(); err == nil {
+			buf.WriteString(tomlFloat(f))
+		} else {
+			return fmt.Errorf("TOML cannot represent number %s at %s", val, tomlPath(path))
+		}
+	case float64:
+		buf.WriteString(tomlFloat(val))
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, v := range val {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			if err := tomlValue(buf, append(path, strconv.Itoa(i)), v); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte(' ')
+			buf.WriteString(tomlKey(k))
+			buf.WriteString(" = ")
+			if err := tomlValue(buf, append(path, k), val[k]); err != nil {
+				return err
+			}
+		}
+		if len(keys) > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("TOML cannot represent %T at %s", val, tomlPath(path))
+	}
+	return nil
+}
+
+// isTableArray returns whether arr is a non-empty array of objects.
+func isTableArray(arr []interface{}) bool {
+	for _, v := range arr {
+		if _, ok := v.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return len(arr) > 0
+}
+
+// tomlFloat formats f as a TOML number. Integral values are written as
+// integers.
+func tomlFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case f == math.Trunc(f) && math.Abs(f) < 1<<53:
+		return strconv.FormatInt(int64(f), 10)
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eE") {
+		s += ".0"
+	}
+	return s
+}
+
+var tomlBareKeyRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// tomlKey returns k as a bare key if possible and as a quoted key otherwise.
+func tomlKey(k string) string {
+	if tomlBareKeyRe.MatchString(k) {
+		return k
+	}
+	return tomlString(k)
+}
+
+// tomlPath returns the dotted key for path.
+func tomlPath(path []string) string {
+	keys := make([]string, len(path))
+	for i, k := range path {
+		keys[i] = tomlKey(k)
+	}
+	return strings.Join(keys, ".")
+}
+
+// tomlString returns s as a TOML basic string.
+func tomlString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case '\r':
+			sb.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&sb, `\u%04X`, r)
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// typeName returns the jq type name of val.
+func typeName(val interface{}) string {
+	switch val.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	if _, ok := toFloat(val); ok {
+		return "number"
+	}
+	return fmt.Sprintf("%T", val)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// tomlParser is a Python program that parses each of a JSON array of TOML
+// documents with tomllib and prints the array of results as JSON. Since JSON
+// cannot hold every float, strings and floats are tagged: strings are prefixed
+// with "s", floats are written as "f" followed by float.hex, and documents that
+// fail to parse are written as "e" followed by the error.
+const tomlParser = `
+import json, sys, tomllib
+
+def tag(v):
+    if isinstance(v, str):
+        return "s" + v
+    if isinstance(v, float):
+        return "f" + v.hex()
+    if isinstance(v, list):
+        return [tag(e) for e in v]
+    if isinstance(v, dict):
+        return {k: tag(e) for k, e in v.items()}
+    return v
+
+def parse(doc):
+    try:
+        return tag(tomllib.loads(doc))
+    except Exception as e:
+        return "e" + str(e)
+
+json.dump([parse(doc) for doc in json.load(sys.stdin)], sys.stdout)
+`
+
+// untagTOML returns the value tagged by tomlParser.
+func untagTOML(t *testing.T, v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		switch v[0] {
+		case 's':
+			return v[1:]
+		case 'f':
+			f, err := strconv.ParseFloat(v[1:], 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return f
+		}
+	case json.Number:
+		i, err := strconv.Atoi(v.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return i
+	case []interface{}:
+		for i, e := range v {
+			v[i] = untagTOML(t, e)
+		}
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = untagTOML(t, e)
+		}
+	}
+	return v
+}
+
+// TestTOMLRandomRoundTrip encodes random objects as TOML and parses them with
+// Python's tomllib, which must produce the same objects.
+func TestTOMLRandomRoundTrip(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err == nil {
+		err = exec.Command(python, "-c", "import tomllib").Run()
+	}
+	if err != nil {
+		t.Skip("python3 with tomllib not found")
+	}
+
+	r := rand.New(rand.NewSource(1))
+	gen := randomValues{noNull: true, noBigInts: true, text: true}
+	var want []interface{}
+	var docs []string
+	for i := 0; i < 2000; i++ {
+		obj := gen.randomObject(r, 4)
+		var buf bytes.Buffer
+		if err := newTOMLEncoder(&buf).Encode(obj); err != nil {
+			t.Fatalf("Encode(%#v): %v", obj, err)
+		}
+		want = append(want, obj)
+		docs = append(docs, buf.String())
+	}
+
+	in, err := json.Marshal(docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(python, "-c", tomlParser)
+	cmd.Stdin = bytes.NewReader(in)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("python3: %v", err)
+	}
+
+	var got []interface{}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	dec.UseNumber()
+	if err := dec.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range got {
+		if msg, ok := v.(string); ok && strings.HasPrefix(msg, "e") {
+			t.Errorf("parsing TOML of %#v: %s\n%s", want[i], msg[1:], docs[i])
+		} else if v = untagTOML(t, v); !sameValue(v, want[i]) {
+			t.Errorf("parsed TOML of %#v as %#v\n%s", want[i], v, docs[i])
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// uuidNamespaces are the predefined name-based UUID namespaces of RFC 4122.
+var uuidNamespaces = map[string]string{
+	"dns":  "6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+	"url":  "6ba7b811-9dad-11d1-80b4-00c04fd430c8",
+	"oid":  "6ba7b812-9dad-11d1-80b4-00c04fd430c8",
+	"x500": "6ba7b814-9dad-11d1-80b4-00c04fd430c8",
+}
+
+// uuidCmd implements the uuid builtin. It prints a random (version 4) UUID or,
+// given a namespace and name, a name-based (version 5) UUID:
+//
+//	uuid
+//	uuid -v5 NAMESPACE NAME
+func (p *Prog) uuidCmd(ctx context.Context, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, "uuid")
+	f := flag.NewFlagSet("uuid", flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	v5 := false
+	f.BoolVar(&v5, "v5", v5, "Generate a name-based UUID from a namespace and name.")
+
+	if err := f.Parse(args[1:]); errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	var u [16]byte
+	if v5 {
+		if f.NArg() != 2 {
+			logger.Printf("expected a namespace and a name")
+			return interp.NewExitStatus(1)
+		}
+		ns, err := parseUUID(f.Arg(0))
+		if err != nil {
+			logger.Print(err)
+			return interp.NewExitStatus(1)
+		}
+		sum := sha1.Sum(append(ns[:], f.Arg(1)...))
+		copy(u[:], sum[:])
+		u[6] = u[6]&0x0f | 0x50
+	} else {
+		if f.NArg() != 0 {
+			logger.Printf("too many arguments to uuid: expected 0")
+			return interp.NewExitStatus(1)
+		}
+		if _, err := rand.Read(u[:]); err != nil {
+			logger.Printf("error generating uuid: %v", err)
+			return interp.NewExitStatus(1)
+		}
+		u[6] = u[6]&0x0f | 0x40
+	}
+	u[8] = u[8]&0x3f | 0x80
+
+	if _, err := fmt.Fprintln(h.Stdout, formatUUID(u)); err != nil {
+		logger.Printf("error writing uuid: %v", err)
+		return interp.NewExitStatus(1)
+	}
+	return nil
+}
+
+// parseUUID parses a UUID in its canonical hyphenated form. The names of the
+// predefined namespaces (dns, url, oid, and x500) are also accepted.
+func parseUUID(s string) (u [16]byte, err error) {
+	if ns, ok := uuidNamespaces[strings.ToLower(s)]; ok {
+		s = ns
+	}
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, fmt.Errorf("invalid uuid: %q", s)
+	}
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != 16 {
+		return u, fmt.Errorf("invalid uuid: %q", s)
+	}
+	copy(u[:], b)
+	return u, nil
+}
+
+// formatUUID formats u in its canonical lowercase, hyphenated form.
+func formatUUID(u [16]byte) string {
+	h := hex.EncodeToString(u[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// validateEvent checks that event has the shape of a Sensu Go event: an
+// entity and a check or metrics, each with the fields that Sensu always sets.
+// Every problem found is reported in the returned error.
+func validateEvent(event map[string]interface{}) error {
+	var problems []string
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	// field returns the value at the path of keys, if each of its parents is
+	// an object.
+	field := func(path ...string) (interface{}, bool) {
+		var val interface{} = event
+		for _, key := range path {
+			obj, ok := val.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if val, ok = obj[key]; !ok {
+				return nil, false
+			}
+		}
+		return val, true
+	}
+	require := func(kind string, path ...string) {
+		name := "." + strings.Join(path, ".")
+		val, ok := field(path...)
+		if !ok || val == nil {
+			report("%s is missing", name)
+		} else if got := typeName(val); got != kind {
+			report("%s must be %s %s, got %s", name, article(kind), kind, got)
+		}
+	}
+	optional := func(kind string, path ...string) {
+		if val, ok := field(path...); ok && val != nil {
+			require(kind, path...)
+		}
+	}
+
+	require("object", "entity")
+	require("object", "entity", "metadata")
+	require("string", "entity", "metadata", "name")
+	optional("string", "entity", "entity_class")
+
+	_, hasCheck := field("check")
+	_, hasMetrics := field("metrics")
+	if !hasCheck && !hasMetrics {
+		report("one of .check or .metrics is required")
+	}
+	if hasCheck {
+		require("object", "check")
+		require("object", "check", "metadata")
+		require("string", "check", "metadata", "name")
+		optional("number", "check", "status")
+		optional("string", "check", "output")
+	}
+	if hasMetrics {
+		require("object", "metrics")
+		optional("array", "metrics", "points")
+	}
+
+	optional("number", "timestamp")
+	optional("string", "id")
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// article returns the indefinite article for the type name kind.
+func article(kind string) string {
+	if strings.IndexByte("aeiou", kind[0]) >= 0 {
+		return "an"
+	}
+	return "a"
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestValidateEvent(t *testing.T) {
+	cases := []struct {
+		name  string
+		event string
+		err   string
+	}{
+		{"check", `{"entity": {"metadata": {"name": "web-1"}}, "check": {"metadata": {"name": "disk"}, "status": 1}, "timestamp": 1600000000}`, ""},
+		{"metrics", `{"entity": {"metadata": {"name": "web-1"}}, "metrics": {"points": []}}`, ""},
+		{"null optional", `{"entity": {"metadata": {"name": "web-1"}, "entity_class": null}, "check": {"metadata": {"name": "disk"}, "status": null}}`, ""},
+		{"empty", `{}`, ".entity is missing; .entity.metadata is missing; .entity.metadata.name is missing; one of .check or .metrics is required"},
+		{"entity not an object", `{"entity": "web-1", "check": {"metadata": {"name": "disk"}}}`, ".entity must be an object, got string"},
+		{"name not a string", `{"entity": {"metadata": {"name": 1}}, "check": {"metadata": {"name": "disk"}}}`, ".entity.metadata.name must be a string, got number"},
+		{"no check name", `{"entity": {"metadata": {"name": "web-1"}}, "check": {"status": 0}}`, ".check.metadata is missing; .check.metadata.name is missing"},
+		{"status not a number", `{"entity": {"metadata": {"name": "web-1"}}, "check": {"metadata": {"name": "disk"}, "status": "1"}}`, ".check.status must be a number, got string"},
+		{"points not an array", `{"entity": {"metadata": {"name": "web-1"}}, "metrics": {"points": {}}}`, ".metrics.points must be an array, got object"},
+		{"timestamp not a number", `{"entity": {"metadata": {"name": "web-1"}}, "check": {"metadata": {"name": "disk"}}, "timestamp": "now"}`, ".timestamp must be a number, got string"},
+		{"null check", `{"entity": {"metadata": {"name": "web-1"}}, "check": null}`, ".check is missing"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var event map[string]interface{}
+			if err := newYAMLDecoder(strings.NewReader(c.event)).Decode(&event); err != nil {
+				t.Fatal(err)
+			}
+			err := validateEvent(event)
+			if c.err == "" && err != nil {
+				t.Errorf("validateEvent(%s) = %v; want no error", c.event, err)
+			} else if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+				t.Errorf("validateEvent(%s) = %v; want %q", c.event, err, c.err)
+			}
+		})
+	}
+}
+
+func TestMainValidateEvent(t *testing.T) {
+	dir := tempDir(t)
+	bad := writeFile(t, dir, "bad.json", `{"check": {"metadata": {"name": "disk"}}}`)
+	status, logged := runMain(context.Background(), t, "-validate-event", "-E", bad, "-R", "true")
+	if status != 1 || !strings.Contains(logged, "invalid event: .entity is missing") {
+		t.Errorf("status = %d, logged %q; want 1 and an invalid event", status, logged)
+	}
+	if status, logged := runMain(context.Background(), t, "-E", bad, "-R", "true"); status != 0 {
+		t.Errorf("without -validate-event: status = %d; want 0\nlogged: %s", status, logged)
+	}
+	if status, logged := runMain(context.Background(), t, "-validate-event", "-E", testEventFile(t), "-R", "true"); status != 0 {
+		t.Errorf("valid event: status = %d; want 0\nlogged: %s", status, logged)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+)
+
+// setVar implements the @VAR= form of the @VAR shorthand. It runs a query
+// against the JSON or YAML document held by the variable name and replaces
+// the variable with the single result, written as compact JSON:
+//
+//	@VAR= [options] query
+//	@VAR[N]= [options] query
+//
+// Each element of an indexed variable is updated separately. If elem is not
+// negative, only that element is updated. As with assign, the variable is set
+// in the script's top-level shell, so readonly and local variables cannot be
+// updated, and @VAR= fails in subshells, command substitutions, and pipeline
+// stages other than the last.
+func (p *Prog) setVar(ctx context.Context, name string, elem int, args []string) error {
+	h := interp.HandlerCtx(ctx)
+	logger := p.newLogger(h.Stderr, args[0])
+	f := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	f.SetOutput(h.Stderr)
+
+	var vars queryVars
+	// -arg NAME VALUE, -argjson NAME JSON
+	f.Var(argFlag{&vars}, "arg", "Bind the query variable $`name` to the string value given by the following argument.")
+	f.Var(argJSONFlag{&vars}, "argjson", "Bind the query variable $`name` to the JSON value given by the following argument.")
+	strictNumbers := false
+	// -strict-numbers
+	f.BoolVar(&strictNumbers, "strict-numbers", strictNumbers, "Decode the variable as JSON, preserving the precision of numbers.")
+
+	pos, err := parseArgs(f, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return interp.NewExitStatus(2)
+	} else if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	} else if len(pos) != 1 {
+		logger.Printf("expected a query")
+		return interp.NewExitStatus(1)
+	}
+
+	cur, err := p.assignable(h.Env, name)
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+
+	code, err := p.compileUserQuery(ctx, pos[0], vars.names...)
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+	update := func(doc string) (string, error) {
+		var result interface{}
+		docs, results := 0, 0
+		dec := newDecoder(strings.NewReader(doc), strictNumbers)
+		for ; ; docs++ {
+			var input interface{}
+			if err := dec.Decode(&input); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return "", fmt.Errorf("error decoding variable: %w", err)
+			}
+			iter := runQuery(ctx, code, input, vars.values...)
+			for {
+				val, ok := iter.Next()
+				if !ok {
+					break
+				} else if err, ok := val.(error); ok {
+					return "", fmt.Errorf("query error: %w", err)
+				} else if results++; results > 1 {
+					return "", errors.New("query produced more than one result")
+				}
+				result = val
+			}
+		}
+
+		switch {
+		case docs == 0:
+			return "", errors.New("variable holds no document")
+		case results == 0:
+			return "", errors.New("query produced no result")
+		}
+		out, err := json.Marshal(result)
+		return string(out), err
+	}
+
+	if cur.Kind == expand.Indexed {
+		list := append([]string(nil), cur.List...)
+		for i := range list {
+			if elem >= 0 && i != elem {
+				continue
+			} else if list[i], err = update(list[i]); err != nil {
+				logger.Printf("%s[%d]: %v", name, i, err)
+				return interp.NewExitStatus(1)
+			}
+		}
+		p.storeVar(name, cur, true, list)
+		return nil
+	}
+
+	str, err := update(cur.Str)
+	if err != nil {
+		logger.Print(err)
+		return interp.NewExitStatus(1)
+	}
+	p.storeVar(name, cur, false, []string{str})
+	return nil
+}
+
+// varElement returns the index of the element of v given by index, which may
+// be negative to count back from the last element. A string variable has a
+// single element.
+func varElement(v expand.Variable, index string) (int, error) {
+	n := 1
+	if v.Kind == expand.Indexed {
+		n = len(v.List)
+	}
+	i, err := strconv.Atoi(index)
+	if err != nil {
+		return 0, fmt.Errorf("invalid index: %q", index)
+	} else if i < 0 {
+		i += n
+	}
+	if i < 0 || i >= n {
+		noun := "elements"
+		if n == 1 {
+			noun = "element"
+		}
+		return 0, fmt.Errorf("index %s out of range: the variable has %d %s", index, n, noun)
+	}
+	return i, nil
+}
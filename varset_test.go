@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+
+	"mvdan.cc/sh/v3/expand"
+)
+
+func TestSetVar(t *testing.T) {
+	runScriptCases(t, []scriptCase{
+		{name: "string", script: "doc='{\"a\": 1}'\n@doc= '.a += 1'\necho \"$doc\"", want: `{"a":2}` + "\n"},
+		{name: "yaml", script: "doc='a: [1, 2]'\n@doc= '.a | length'\necho \"$doc\"", want: "2\n"},
+		{name: "string result", script: "doc='{\"a\": \"x\"}'\n@doc= .a\necho \"$doc\"", want: `"x"` + "\n"},
+		{name: "arg", script: "doc='{}'\n@doc= -arg env prod '.env = $env'\necho \"$doc\"", want: `{"env":"prod"}` + "\n"},
+		{name: "argjson", script: "doc='{}'\n@doc= -argjson n '[1]' '.n = $n'\necho \"$doc\"", want: `{"n":[1]}` + "\n"},
+		{name: "strict numbers", script: "doc='{\"n\": 12345678901234567890}'\n@doc= -strict-numbers .n\necho \"$doc\"", want: "12345678901234567890\n"},
+		{name: "indexed", script: "docs=('{\"a\": 1}' '{\"a\": 2}')\n@docs= .a\necho \"${docs[@]}\"", want: "1 2\n"},
+		{name: "keeps export", script: "export doc=1\n@doc= '. + 1'\nsh -c 'echo $doc'", want: "2\n"},
+		{name: "in function", script: "doc=1\nf() { @doc= '. + 1'; }\nf\necho $doc", want: "2\n"},
+		{name: "local", script: "f() { local doc=1; @doc= '. + 1'; echo \"$? $doc\"; }\nf", want: "1 1\n", wantErr: "doc: cannot assign to a local variable"},
+		{name: "local global untouched", script: "doc=1\nf() { local doc=5; @doc= '. + 1'; }\nf\necho $doc", want: "1\n", wantErr: "cannot assign to a local variable"},
+		{name: "readonly", script: "readonly doc=1\n@doc= '. + 1'", status: 1, wantErr: "doc: readonly variable"},
+		{name: "subshell", script: "doc=1\n( @doc= '. + 1'; echo \"inner=$? $doc\" )\necho outer=$doc", want: "inner=1 1\nouter=1\n", wantErr: "doc: cannot assign outside the top-level shell"},
+		{name: "command substitution", script: "doc=1\nst=$(@doc= '. + 1'; echo $?)\necho \"$st $doc\"", want: "1 1\n", wantErr: "cannot assign outside the top-level shell"},
+		{name: "background", script: "doc=1\n@doc= '. + 1' &\nwait\necho $doc", want: "1\n", wantErr: "cannot assign outside the top-level shell"},
+		{name: "pipeline", script: "a=1 b=1\n@a= '. + 1' | @b= '. + 1' | @b= '. + 10'\necho $a $b", want: "1 11\n", wantErr: "a: cannot assign outside the top-level shell"},
+		{name: "pipeline last stage", script: "doc=1\necho | @doc= '. + 1'\necho $doc", want: "2\n"},
+		{name: "no document", script: "doc=\n@doc= .", status: 1, wantErr: "variable holds no document"},
+		{name: "no result", script: "doc=1\n@doc= empty\necho $?; echo $doc", want: "1\n1\n", wantErr: "query produced no result"},
+		{name: "multiple results", script: "doc=1\n@doc= '., .'\necho $doc", want: "1\n", wantErr: "query produced more than one result"},
+		{name: "unchanged on error", script: "docs=(1 x 3)\n@docs= '. + 1'\necho \"${docs[@]}\"", want: "1 x 3\n", wantErr: "docs[1]: query error"},
+		{name: "decode error", script: "doc='{'\n@doc= .", status: 1, wantErr: "error decoding variable"},
+		{name: "missing query", script: "doc=1\n@doc=", status: 1, wantErr: "expected a query"},
+	}, nil)
+}
+
+func TestVarElement(t *testing.T) {
+	list := expand.Variable{Kind: expand.Indexed, List: []string{"a", "b", "c"}}
+	str := expand.Variable{Kind: expand.String, Str: "a"}
+	empty := expand.Variable{Kind: expand.Indexed}
+	cases := []struct {
+		name    string
+		v       expand.Variable
+		index   string
+		want    int
+		wantErr string
+	}{
+		{"first", list, "0", 0, ""},
+		{"last", list, "2", 2, ""},
+		{"negative", list, "-1", 2, ""},
+		{"negative first", list, "-3", 0, ""},
+		{"out of range", list, "3", 0, "index 3 out of range: the variable has 3 elements"},
+		{"negative out of range", list, "-4", 0, "index -4 out of range: the variable has 3 elements"},
+		{"string", str, "0", 0, ""},
+		{"string negative", str, "-1", 0, ""},
+		{"string out of range", str, "1", 0, "index 1 out of range: the variable has 1 element"},
+		{"empty", empty, "0", 0, "index 0 out of range: the variable has 0 elements"},
+		{"invalid", list, "x", 0, `invalid index: "x"`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := varElement(c.v, c.index)
+			if c.wantErr != "" {
+				if err == nil || err.Error() != c.wantErr {
+					t.Fatalf("varElement(%q) error = %v; want %q", c.index, err, c.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("varElement(%q): %v", c.index, err)
+			}
+			if got != c.want {
+				t.Errorf("varElement(%q) = %d; want %d", c.index, got, c.want)
+			}
+		})
+	}
+}
+
+func TestVarElementShorthand(t *testing.T) {
+	const checks = `checks=('{"name": "a"}' '{"name": "b"}' '{"name": "c"}')` + "\n"
+	runScriptCases(t, []scriptCase{
+		{name: "query", script: checks + `'@checks[1]' .name`, want: "b"},
+		{name: "query negative", script: checks + `'@checks[-1]' .name`, want: "c"},
+		{name: "query all", script: checks + `'@checks[*]' .name`, want: "a\nb\nc"},
+		{name: "query string", script: `doc='{"name": "d"}'` + "\n" + `'@doc[0]' .name`, want: "d"},
+		{name: "query string negative", script: `doc='{"name": "d"}'` + "\n" + `'@doc[-1]' .name`, want: "d"},
+		{name: "update", script: checks + `'@checks[1]=' '.name = "x"'` + "\n" + `echo "${checks[@]}"`,
+			want: `{"name": "a"} {"name":"x"} {"name": "c"}` + "\n"},
+		{name: "update negative", script: checks + `'@checks[-3]=' .name` + "\n" + `echo "${checks[@]}"`,
+			want: `"a" {"name": "b"} {"name": "c"}` + "\n"},
+		{name: "update string", script: `doc='{"n": 1}'` + "\n" + `'@doc[0]=' .n` + "\n" + `echo "$doc"`, want: "1\n"},
+		{name: "out of range", script: checks + `'@checks[3]' .name`, status: 1, wantErr: "index 3 out of range: the variable has 3 elements"},
+		{name: "negative out of range", script: checks + `'@checks[-4]=' .name` + "\n" + `echo "${checks[0]}"`,
+			want: `{"name": "a"}` + "\n", wantErr: "index -4 out of range"},
+		{name: "string out of range", script: `doc=1` + "\n" + `'@doc[1]' .`, status: 1, wantErr: "index 1 out of range: the variable has 1 element"},
+		{name: "invalid index", script: checks + `'@checks[x]' .`, status: 1, wantErr: `invalid index: "x"`},
+	}, nil)
+}
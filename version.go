@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, set at build time with -ldflags, such as:
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%FT%TZ)"
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+// versionDeps are the modules whose versions are reported by -version.
+var versionDeps = []string{
+	"github.com/itchyny/gojq",
+	"mvdan.cc/sh/v3",
+}
+
+// printVersion writes the version of sensu-sh, its commit and build date, the
+// Go version it was built with, and the versions of versionDeps to w. Versions
+// not set at build time are taken from the binary's module information if
+// possible.
+func printVersion(w io.Writer) {
+	deps := map[string]string{}
+	v := version
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		for _, dep := range info.Deps {
+			if dep.Replace != nil {
+				deps[dep.Path] = dep.Replace.Version
+			} else {
+				deps[dep.Path] = dep.Version
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "sensu-sh %s\n", orUnknown(v))
+	fmt.Fprintf(w, "commit: %s\n", orUnknown(commit))
+	fmt.Fprintf(w, "built: %s\n", orUnknown(date))
+	fmt.Fprintf(w, "go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	for _, path := range versionDeps {
+		fmt.Fprintf(w, "%s: %s\n", path, orUnknown(deps[path]))
+	}
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
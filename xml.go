@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// xmlNameRe matches the element and attribute names written by xmlEncoder.
+// Colons are not permitted, since namespaces are not supported.
+var xmlNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9._-]*$`)
+
+const (
+	// xmlAttrsKey is the key of the object holding an element's attributes.
+	xmlAttrsKey = "@attrs"
+	// xmlTextKey is the key of an element's text when it also has attributes
+	// or child elements.
+	xmlTextKey = "#text"
+)
+
+// xmlDecoder is a Decoder for XML documents. Each top-level element is decoded
+// as a separate document: an object whose only key is the element's name. An
+// element is decoded as follows:
+//
+//   - An element with no attributes or child elements is its text, with
+//     surrounding whitespace removed.
+//   - Otherwise, it is an object. Its attributes are held by an object of
+//     strings under "@attrs", and its text, if not only whitespace, under
+//     "#text". Each child element is held under its name, and the children
+//     of a repeated name are held in an array in document order.
+//
+// Namespaces are discarded, so elements and attributes are named by their
+// local names and namespace declarations are not attributes. Comments,
+// processing instructions, and directives are ignored.
+type xmlDecoder struct {
+	dec *xml.Decoder
+}
+
+func newXMLDecoder(r io.Reader) *xmlDecoder {
+	return &xmlDecoder{dec: xml.NewDecoder(r)}
+}
+
+// Decode decodes the next top-level element into v, which must be a
+// *interface{} or a *map[string]interface{}.
+func (x *xmlDecoder) Decode(v interface{}) error {
+	var doc map[string]interface{}
+	for doc == nil {
+		tok, err := x.dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			val, err := x.element(tok)
+			if err != nil {
+				return err
+			}
+			doc = map[string]interface{}{tok.Name.Local: val}
+		case xml.CharData:
+			if len(strings.TrimSpace(string(tok))) > 0 {
+				return errors.New("unexpected text outside of an element")
+			}
+		}
+	}
+
+	switch v := v.(type) {
+	case *interface{}:
+		*v = doc
+	case *map[string]interface{}:
+		*v = doc
+	default:
+		return fmt.Errorf("cannot decode into %T", v)
+	}
+	return nil
+}
+
+// element decodes the element begun by start, consuming tokens up to and
+// including its end element.
+func (x *xmlDecoder) element(start xml.StartElement) (interface{}, error) {
+	obj := map[string]interface{}{}
+	attrs := map[string]interface{}{}
+	for _, attr := range start.Attr {
+		if attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+			continue
+		}
+		attrs[attr.Name.Local] = attr.Value
+	}
+	if len(attrs) > 0 {
+		obj[xmlAttrsKey] = attrs
+	}
+
+	var text strings.Builder
+	for {
+		tok, err := x.dec.Token()
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			child, err := x.element(tok)
+			if err != nil {
+				return nil, err
+			}
+			// Elements never decode to arrays, so an array can only
+			// hold the children of a repeated name.
+			name := tok.Name.Local
+			switch prev := obj[name].(type) {
+			case nil:
+				obj[name] = child
+			case []interface{}:
+				obj[name] = append(prev, child)
+			default:
+				obj[name] = []interface{}{prev, child}
+			}
+		case xml.CharData:
+			text.Write(tok)
+		case xml.EndElement:
+			str := strings.TrimSpace(text.String())
+			if len(obj) == 0 {
+				return str, nil
+			} else if str != "" {
+				obj[xmlTextKey] = str
+			}
+			return obj, nil
+		}
+	}
+}
+
+// xmlEncoder is an Encoder that writes values as XML documents, using the
+// inverse of the mapping used by xmlDecoder:
+//
+//   - An object with a single key is written as an element named by the key.
+//     Any other value is written as an element named by root.
+//   - An object's "@attrs" object is written as its element's attributes,
+//     its "#text" as text, and each other key as a child element. An array
+//     under a key is written as one element named by the key per element.
+//   - An array is written as one child element named by item per element.
+//   - A string, number, or boolean is written as text, and null as nothing.
+//
+// Keys are written in sorted order. Names that are not valid XML names,
+// such as keys containing spaces, are rejected.
+type xmlEncoder struct {
+	w      io.Writer
+	root   string
+	item   string
+	indent string
+}
+
+func newXMLEncoder(w io.Writer, root, item, indent string) *xmlEncoder {
+	return &xmlEncoder{w: w, root: root, item: item, indent: indent}
+}
+
+func (x *xmlEncoder) Encode(val interface{}) error {
+	name := x.root
+	if obj, ok := val.(map[string]interface{}); ok && len(obj) == 1 {
+		for k, v := range obj {
+			name, val = k, v
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", x.indent)
+	if err := x.element(enc, name, val); err != nil {
+		return err
+	}
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err := x.w.Write(buf.Bytes())
+	return err
+}
+
+// element writes val as an element named name. Arrays under a key are
+// expanded by the caller, so an array here is a list of items.
+func (x *xmlEncoder) element(enc *xml.Encoder, name string, val interface{}) error {
+	if !xmlNameRe.MatchString(name) {
+		return fmt.Errorf("invalid XML element name: %q", name)
+	}
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+
+	switch val := val.(type) {
+	case map[string]interface{}:
+		switch attrs := val[xmlAttrsKey].(type) {
+		case nil:
+		case map[string]interface{}:
+			for _, k := range sortedKeys(attrs) {
+				if !xmlNameRe.MatchString(k) {
+					return fmt.Errorf("invalid XML attribute name: %q", k)
+				}
+				v, err := xmlText(attrs[k])
+				if err != nil {
+					return fmt.Errorf("attribute %s of %s: %w", k, name, err)
+				}
+				start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: k}, Value: v})
+			}
+		default:
+			return fmt.Errorf("%s of %s must be an object, got %s", xmlAttrsKey, name, typeName(attrs))
+		}
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		if text, ok := val[xmlTextKey]; ok {
+			str, err := xmlText(text)
+			if err != nil {
+				return fmt.Errorf("%s of %s: %w", xmlTextKey, name, err)
+			}
+			if err := enc.EncodeToken(xml.CharData(str)); err != nil {
+				return err
+			}
+		}
+		for _, k := range sortedKeys(val) {
+			if k == xmlAttrsKey || k == xmlTextKey {
+				continue
+			}
+			children, ok := val[k].([]interface{})
+			if !ok {
+				children = []interface{}{val[k]}
+			}
+			for _, child := range children {
+				if err := x.element(enc, k, child); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		for _, child := range val {
+			if err := x.element(enc, x.item, child); err != nil {
+				return err
+			}
+		}
+	default:
+		str, err := xmlText(val)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		if str != "" {
+			if err := enc.EncodeToken(xml.CharData(str)); err != nil {
+				return err
+			}
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// xmlText returns the text of a scalar for an attribute or text node.
+func xmlText(val interface{}) (string, error) {
+	switch val.(type) {
+	case map[string]interface{}, []interface{}:
+		return "", fmt.Errorf("expected a scalar, got %s", typeName(val))
+	}
+	return plainString(val)
+}
+
+func sortedKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestXMLDecoder(t *testing.T) {
+	type obj = map[string]interface{}
+	type arr = []interface{}
+	cases := []struct {
+		name string
+		in   string
+		want []interface{}
+		err  bool
+	}{
+		{"text", `<a> hi </a>`, arr{obj{"a": "hi"}}, false},
+		{"empty", `<a/>`, arr{obj{"a": ""}}, false},
+		{"attributes", `<a x="1" y="two"/>`, arr{obj{"a": obj{"@attrs": obj{"x": "1", "y": "two"}}}}, false},
+		{"attributes and text", `<a x="1">hi</a>`, arr{obj{"a": obj{"@attrs": obj{"x": "1"}, "#text": "hi"}}}, false},
+		{"children", `<a><b>1</b><c>2</c></a>`, arr{obj{"a": obj{"b": "1", "c": "2"}}}, false},
+		{"repeated", `<a><b>1</b><c/><b>2</b><b>3</b></a>`, arr{obj{"a": obj{"b": arr{"1", "2", "3"}, "c": ""}}}, false},
+		{"nested text", `<a>x<b>y<c>z</c></b> w </a>`, arr{obj{"a": obj{"#text": "x w", "b": obj{"#text": "y", "c": "z"}}}}, false},
+		{"whitespace text", "<a>\n  <b>1</b>\n</a>", arr{obj{"a": obj{"b": "1"}}}, false},
+		{"namespaces", `<s:a xmlns:s="urn:s" xmlns="urn:d" s:x="1"><s:b>2</s:b></s:a>`, arr{obj{"a": obj{"@attrs": obj{"x": "1"}, "b": "2"}}}, false},
+		{"ignored", `<?xml version="1.0"?><!-- c --><!DOCTYPE a><a><!-- c -->1</a>`, arr{obj{"a": "1"}}, false},
+		{"entities", `<a>&lt;&amp;&gt;</a>`, arr{obj{"a": "<&>"}}, false},
+		{"documents", "<a>1</a>\n<b>2</b>", arr{obj{"a": "1"}, obj{"b": "2"}}, false},
+		{"no documents", " \n", nil, false},
+		{"text outside", `hi <a/>`, nil, true},
+		{"unclosed", `<a><b></a>`, nil, true},
+		{"truncated", `<a><b>`, nil, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dec := newXMLDecoder(strings.NewReader(c.in))
+			var docs []interface{}
+			for {
+				var doc interface{}
+				err := dec.Decode(&doc)
+				if errors.Is(err, io.EOF) {
+					break
+				} else if err != nil {
+					if !c.err {
+						t.Fatalf("Decode(%q): %v", c.in, err)
+					}
+					return
+				}
+				docs = append(docs, doc)
+			}
+			if c.err {
+				t.Fatalf("Decode(%q) = %v; want an error", c.in, docs)
+			}
+			if !reflect.DeepEqual(docs, c.want) {
+				t.Errorf("Decode(%q) = %#v; want %#v", c.in, docs, c.want)
+			}
+		})
+	}
+}
+
+func TestXMLInput(t *testing.T) {
+	const doc = `<env><header id="7"/><body><item n="1">a</item><item n="2">b</item></body></env>`
+	runScriptCases(t, []scriptCase{
+		{name: "attribute", script: `query -xml-input '.env.header."@attrs".id' doc`, want: "7"},
+		{name: "repeated", script: `query -xml-input '.env.body.item[] | .["#text"]' doc`, want: "a\nb"},
+		{name: "invalid", script: `query -xml-input . bad`, status: 1, wantErr: "error decoding input"},
+		{name: "raw conflict", script: `query -xml-input -R . doc`, status: 1, wantErr: "-xml-input cannot be combined"},
+	}, nil, "doc="+doc, "bad=<a>")
+}
+
+func TestXMLEncoder(t *testing.T) {
+	type obj = map[string]interface{}
+	type arr = []interface{}
+	cases := []struct {
+		name   string
+		in     interface{}
+		indent string
+		want   string
+		err    bool
+	}{
+		{"scalar", 1.5, "", "<root>1.5</root>\n", false},
+		{"null", nil, "", "<root></root>\n", false},
+		{"escaped", "<&>", "", "<root>&lt;&amp;&gt;</root>\n", false},
+		{"single key", obj{"a": "x"}, "", "<a>x</a>\n", false},
+		{"several keys", obj{"b": 1, "a": true}, "", "<root><a>true</a><b>1</b></root>\n", false},
+		{"array", arr{1, "x"}, "", "<root><item>1</item><item>x</item></root>\n", false},
+		{"array under key", obj{"a": obj{"b": arr{1, 2}}}, "", "<a><b>1</b><b>2</b></a>\n", false},
+		{"attributes", obj{"a": obj{"@attrs": obj{"y": 2, "x": "1"}, "#text": "t"}}, "", `<a x="1" y="2">t</a>` + "\n", false},
+		{"indent", obj{"a": obj{"b": "1"}}, "  ", "<a>\n  <b>1</b>\n</a>\n", false},
+		{"invalid name", obj{"a b": 1}, "", "", true},
+		{"invalid attribute", obj{"a": obj{"@attrs": obj{"x y": 1}}}, "", "", true},
+		{"attrs not an object", obj{"a": obj{"@attrs": "x"}}, "", "", true},
+		{"text not a scalar", obj{"a": obj{"#text": arr{}}}, "", "", true},
+		{"attribute not a scalar", obj{"a": obj{"@attrs": obj{"x": obj{}}}}, "", "", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var buf strings.Builder
+			err := newXMLEncoder(&buf, "root", "item", c.indent).Encode(c.in)
+			if c.err {
+				if err == nil {
+					t.Fatalf("Encode(%v) = %q; want an error", c.in, buf.String())
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("Encode(%v): %v", c.in, err)
+			}
+			if got := buf.String(); got != c.want {
+				t.Errorf("Encode(%v) = %q; want %q", c.in, got, c.want)
+			}
+		})
+	}
+}
+
+func TestXMLRoundTrip(t *testing.T) {
+	const doc = `<env><body><item n="1">a</item><item n="2">b</item></body><header id="7"></header><note>x<b>y</b></note></env>`
+	runScriptCases(t, []scriptCase{
+		{name: "xml", script: `query -xml-input -xml . doc`, want: doc + "\n"},
+		{name: "through json", script: `query -xml-input -ndjson . doc | query -xml .`, want: doc + "\n"},
+		{name: "json", script: `query -ndjson '.' json | query -xml . | query -xml-input -ndjson .`, want: `{"a":{"b":["1","2"],"c":"x"}}` + "\n"},
+		{name: "root and item", script: `query -xml -xml-root list -xml-item n '[1, 2]' json`, want: "<list><n>1</n><n>2</n></list>\n"},
+		{name: "invalid name", script: `query -xml '{"a b": 1}' json`, status: 1, wantErr: `invalid XML element name: "a b"`},
+	}, nil, "doc="+doc, `json={"a": {"b": ["1", "2"], "c": "x"}}`)
+}